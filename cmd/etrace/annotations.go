@@ -0,0 +1,82 @@
+/*
+ * Copyright (C) 2019 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/anonymouse64/etrace/internal/profiling"
+)
+
+// parseAnnotations returns the JSON object on the last line of the script
+// output which starts with {, scripts can print anything else before it
+func parseAnnotations(out []byte) (map[string]interface{}, error) {
+	lines := strings.Split(string(out), "\n")
+	for i := len(lines) - 1; i >= 0; i-- {
+		line := strings.TrimSpace(lines[i])
+		if !strings.HasPrefix(line, "{") {
+			continue
+		}
+		var annotations map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &annotations); err != nil {
+			return nil, fmt.Errorf("cannot parse annotations %q: %w", line, err)
+		}
+		return annotations, nil
+	}
+	return nil, nil
+}
+
+// runScript runs a prepare or restore script for iteration iter of iterations,
+// adding any annotations it printed with --script-annotations to annotations
+func (x *runOptions) runScript(which, script string, args []string, iter, iterations uint, annotations map[string]interface{}) {
+	// so that scripts can do something different every iteration, such as
+	// using another input file
+	env := []string{
+		fmt.Sprintf("ETRACE_ITERATION=%d", iter+1),
+		fmt.Sprintf("ETRACE_ITERATIONS=%d", iterations),
+	}
+	if x.untracedPass {
+		// the untraced run isn't one of the iterations, it's numbered after
+		// the traced one it measures
+		env = []string{
+			fmt.Sprintf("ETRACE_UNTRACED_ITERATION=%d", x.tracedIteration+1),
+			fmt.Sprintf("ETRACE_ITERATIONS=%d", x.tracedIterations),
+		}
+	}
+	out, err := profiling.RunScriptWithOptions(script, args, profiling.ScriptOptions{
+		Timeout: x.ScriptTimeout,
+		Env:     env,
+	})
+	if err != nil {
+		logError(fmt.Errorf("running %s script: %w", which, err))
+		return
+	}
+	if !x.ScriptAnnotations {
+		return
+	}
+	scriptAnnotations, err := parseAnnotations(out)
+	if err != nil {
+		logError(fmt.Errorf("%s script: %w", which, err))
+		return
+	}
+	for k, v := range scriptAnnotations {
+		annotations[k] = v
+	}
+}
@@ -0,0 +1,294 @@
+/*
+ * Copyright (C) 2019 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+	"strings"
+)
+
+type cmdBatch struct {
+	runOptions
+	Shuffle  bool   `long:"shuffle" description:"Run the commands in a random order"`
+	Baseline string `long:"baseline" value-name:"command" description:"Command from the batch to compare the mean startup time of every command against"`
+
+	Args struct {
+		File string `positional-arg-name:"file" description:"File with the commands to run, one per line or as a JSON array (default is stdin)"`
+	} `positional-args:"yes"`
+}
+
+// BatchResult is the result of running every command in a batch, keyed by
+// the command line
+type BatchResult struct {
+	Etrace  BuildInfo
+	Seed    int64
+	Results map[string]*OutputResult
+	// Ratios is the mean startup time of every command relative to the
+	// baseline command, only set with --baseline. Commands without any valid
+	// runs are left out, as is every command when the baseline has none.
+	Ratios map[string]float64 `json:",omitempty"`
+}
+
+// parseBatchCommands parses the commands of a batch, which is either a JSON
+// array where every command is a string or an array of arguments, or
+// otherwise one command per line with blank lines and # comments ignored
+func parseBatchCommands(r io.Reader) ([][]string, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var cmds [][]string
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte("[")) {
+		var entries []json.RawMessage
+		if err := json.Unmarshal(data, &entries); err != nil {
+			return nil, fmt.Errorf("cannot parse commands: %w", err)
+		}
+		for _, entry := range entries {
+			var line string
+			if err := json.Unmarshal(entry, &line); err == nil {
+				cmds = append(cmds, strings.Fields(line))
+				continue
+			}
+			var args []string
+			if err := json.Unmarshal(entry, &args); err != nil {
+				return nil, fmt.Errorf("cannot parse command %s: must be a string or an array of strings", entry)
+			}
+			cmds = append(cmds, args)
+		}
+	} else {
+		for _, line := range strings.Split(string(data), "\n") {
+			line = strings.TrimSpace(line)
+			if line == "" || strings.HasPrefix(line, "#") {
+				continue
+			}
+			cmds = append(cmds, strings.Fields(line))
+		}
+	}
+
+	// the results are keyed by the command line, so a command given twice
+	// would replace the results of the first one
+	seen := make(map[string]bool, len(cmds))
+	for _, cmd := range cmds {
+		if len(cmd) == 0 {
+			return nil, fmt.Errorf("cannot use empty command")
+		}
+		name := strings.Join(cmd, " ")
+		if seen[name] {
+			return nil, fmt.Errorf("cannot run %q more than once in a batch", name)
+		}
+		seen[name] = true
+	}
+	if len(cmds) == 0 {
+		return nil, fmt.Errorf("no commands to run")
+	}
+	return cmds, nil
+}
+
+func (x *cmdBatch) Execute(args []string) error {
+	in := os.Stdin
+	if x.Args.File != "" && x.Args.File != "-" {
+		f, err := os.Open(x.Args.File)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		in = f
+	}
+	cmds, err := parseBatchCommands(in)
+	if err != nil {
+		return err
+	}
+
+	if x.Baseline != "" {
+		found := false
+		for _, cmd := range cmds {
+			if strings.Join(cmd, " ") == x.Baseline {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("cannot use %q as the baseline: not one of the commands", x.Baseline)
+		}
+	}
+
+	w, err := x.prepare()
+	if err != nil {
+		return err
+	}
+	batchRes, err := x.runAll(w, cmds)
+	if err := x.finishOutput(err); err != nil {
+		return err
+	}
+
+	if x.FailOnError {
+		for _, cmd := range cmds {
+			name := strings.Join(cmd, " ")
+			if err := batchRes.Results[name].failure(); err != nil {
+				return fmt.Errorf("%s: %w", name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// runAll runs every command, writing the results to w
+func (x *cmdBatch) runAll(w io.Writer, cmds [][]string) (*BatchResult, error) {
+	if x.Shuffle {
+		rng.Shuffle(len(cmds), func(i, j int) { cmds[i], cmds[j] = cmds[j], cmds[i] })
+	}
+
+	batchRes := &BatchResult{
+		Etrace:  currentBuildInfo(),
+		Seed:    x.seed,
+		Results: make(map[string]*OutputResult, len(cmds)),
+	}
+	for i, cmd := range cmds {
+		name := strings.Join(cmd, " ")
+		if x.plainOutput() {
+			fmt.Fprintf(w, "Running %s\n", name)
+		}
+
+		// keep the saved strace logs of every command apart
+		x.logPrefix = fmt.Sprintf("cmd-%d-", i)
+		outRes, err := x.run(w, cmd)
+		if err != nil {
+			return nil, fmt.Errorf("cannot run %q: %w", name, err)
+		}
+		batchRes.Results[name] = outRes
+		if x.SQLite != "" {
+			if err := x.appendSQLite(outRes); err != nil {
+				return nil, err
+			}
+		}
+
+		switch {
+		case x.Markdown:
+			fmt.Fprintf(w, "### `%s`\n\n", name)
+			outRes.writeMarkdown(w)
+			fmt.Fprintln(w)
+		case x.plainOutput() || x.Raw:
+			if err := x.writeReport(w, outRes); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if x.Baseline != "" {
+		batchRes.Ratios = baselineRatios(batchRes.Results, x.Baseline)
+		switch {
+		case x.Markdown:
+			writeBaselineMarkdown(w, cmds, batchRes)
+		case x.plainOutput():
+			writeBaseline(w, cmds, batchRes)
+		}
+	}
+
+	if x.OpenMetrics {
+		results := make([]openMetricsResult, 0, len(cmds))
+		for _, cmd := range cmds {
+			name := strings.Join(cmd, " ")
+			results = append(results, openMetricsResult{name: name, res: batchRes.Results[name]})
+		}
+		writeOpenMetrics(w, results)
+	}
+
+	if x.JSONOutput {
+		// keep the full results for --fail-on-error
+		sampledRes := *batchRes
+		sampledRes.Results = make(map[string]*OutputResult, len(batchRes.Results))
+		for name, res := range batchRes.Results {
+			sampledRes.Results[name] = res.sampled(x.SampleRuns)
+		}
+		if err := x.writeJSON(w, &sampledRes); err != nil {
+			return nil, err
+		}
+	}
+	return batchRes, nil
+}
+
+// baselineRatios computes the mean startup time of every result relative to
+// the mean of the baseline result, where more than 1 is slower, leaving out
+// the results without a mean
+func baselineRatios(results map[string]*OutputResult, baseline string) map[string]float64 {
+	base := meanDuration(results[baseline].startupTimes())
+	if base == 0 {
+		return nil
+	}
+	ratios := make(map[string]float64, len(results))
+	for name, res := range results {
+		mean := meanDuration(res.startupTimes())
+		if mean == 0 {
+			continue
+		}
+		ratios[name] = float64(mean) / float64(base)
+	}
+	return ratios
+}
+
+// describeRatio describes how a command compares to the baseline, where a
+// ratio of 0 or one which isn't finite means either has no valid runs
+func describeRatio(ratio float64) string {
+	switch {
+	case ratio <= 0 || math.IsNaN(ratio) || math.IsInf(ratio, 0):
+		return "n/a"
+	case ratio > 1:
+		return fmt.Sprintf("%.2f (%.2fx slower)", ratio, ratio)
+	case ratio < 1:
+		return fmt.Sprintf("%.2f (%.2fx faster)", ratio, 1/ratio)
+	default:
+		return fmt.Sprintf("%.2f (same)", ratio)
+	}
+}
+
+// writeBaseline writes the mean of every command in the order they were run
+// along with how it compares to the baseline
+func writeBaseline(w io.Writer, cmds [][]string, batchRes *BatchResult) {
+	fmt.Fprintln(w, "Compared to the baseline:")
+	tw := tabWriterGeneric(w)
+	fmt.Fprintln(tw, "Command\tMean\tRatio\t")
+	for _, cmd := range cmds {
+		name := strings.Join(cmd, " ")
+		// a missing ratio is 0, which is described as n/a
+		ratio := batchRes.Ratios[name]
+		mean := meanDuration(batchRes.Results[name].startupTimes())
+		fmt.Fprintf(tw, "%s\t%v\t%s\t\n", name, rounded(mean), describeRatio(ratio))
+	}
+	tw.Flush()
+}
+
+// writeBaselineMarkdown is writeBaseline as a Markdown table
+func writeBaselineMarkdown(w io.Writer, cmds [][]string, batchRes *BatchResult) {
+	fmt.Fprintf(w, "### Compared to the baseline\n\n")
+	fmt.Fprintln(w, "| Command | Mean | Ratio |")
+	fmt.Fprintln(w, "| --- | --- | --- |")
+	for _, cmd := range cmds {
+		name := strings.Join(cmd, " ")
+		ratio := batchRes.Ratios[name]
+		mean := meanDuration(batchRes.Results[name].startupTimes())
+		fmt.Fprintf(w, "| `%s` | %v | %s |\n", name, rounded(mean), describeRatio(ratio))
+	}
+	fmt.Fprintln(w)
+}
@@ -0,0 +1,62 @@
+/*
+ * Copyright (C) 2019 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package main
+
+import (
+	"time"
+
+	"gopkg.in/check.v1"
+)
+
+type batchTestSuite struct{}
+
+var _ = check.Suite(&batchTestSuite{})
+
+func resultWithStartups(startups ...time.Duration) *OutputResult {
+	res := &OutputResult{}
+	for _, startup := range startups {
+		res.Runs = append(res.Runs, Execution{TimeToDisplay: startup})
+	}
+	return res
+}
+
+func (s *batchTestSuite) TestBaselineRatios(c *check.C) {
+	results := map[string]*OutputResult{
+		"base":   resultWithStartups(time.Second, 3*time.Second),
+		"slower": resultWithStartups(4 * time.Second),
+		"faster": resultWithStartups(time.Second),
+		// the only run went over --max-startup
+		"invalid": {Runs: []Execution{{TimeToDisplay: time.Second, OverMaxStartup: true}}},
+	}
+	c.Check(baselineRatios(results, "base"), check.DeepEquals, map[string]float64{
+		"base":   1,
+		"slower": 2,
+		"faster": 0.5,
+	})
+
+	// nothing can be compared to a baseline without any valid runs
+	c.Check(baselineRatios(results, "invalid"), check.IsNil)
+}
+
+func (s *batchTestSuite) TestDescribeRatio(c *check.C) {
+	c.Check(describeRatio(2), check.Equals, "2.00 (2.00x slower)")
+	c.Check(describeRatio(0.5), check.Equals, "0.50 (2.00x faster)")
+	c.Check(describeRatio(1), check.Equals, "1.00 (same)")
+	// missing from the ratios
+	c.Check(describeRatio(0), check.Equals, "n/a")
+}
@@ -0,0 +1,264 @@
+/*
+ * Copyright (C) 2019 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CapturedOutput is the output of the command captured with --capture-output
+type CapturedOutput struct {
+	Stdout string
+	Stderr string
+	// Truncated is true if either stream was larger than the maximum size and
+	// only the start of it was kept
+	Truncated bool
+}
+
+// how long to wait for the rest of the output after the run is over
+const captureDrainTimeout = 100 * time.Millisecond
+
+// outputCapture keeps the start of everything written to a pipe, up to a
+// maximum size, while still passing it all on to another writer.
+// The command is given the write end of the pipe directly rather than a
+// plain io.Writer so that cmd.Wait() doesn't block on any background
+// processes which still hold the pipe open.
+type outputCapture struct {
+	mu        sync.Mutex
+	buf       bytes.Buffer
+	max       int
+	truncated bool
+
+	r, w *os.File
+	done chan struct{}
+}
+
+func newOutputCapture(max int, tee io.Writer) (*outputCapture, error) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	c := &outputCapture{
+		max:  max,
+		r:    r,
+		w:    w,
+		done: make(chan struct{}),
+	}
+	go func() {
+		defer close(c.done)
+		io.Copy(io.MultiWriter(tee, c), r)
+	}()
+	return c, nil
+}
+
+// Write implements io.Writer, dropping anything over the maximum size
+func (c *outputCapture) Write(p []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	left := c.max - c.buf.Len()
+	if len(p) > left {
+		c.truncated = true
+		if left > 0 {
+			c.buf.Write(p[:left])
+		}
+	} else {
+		c.buf.Write(p)
+	}
+	return len(p), nil
+}
+
+// started closes our copy of the write end of the pipe once the command has
+// been started with it
+func (c *outputCapture) started() {
+	c.w.Close()
+}
+
+// finish stops capturing, returning what was captured and whether it was
+// truncated
+func (c *outputCapture) finish() (string, bool) {
+	// close the write end in case the command never started
+	c.w.Close()
+	select {
+	case <-c.done:
+	case <-time.After(captureDrainTimeout):
+	}
+	c.r.Close()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.buf.String(), c.truncated
+}
+
+// stderrSplitter separates what strace and sudo print themselves from the
+// traced command's stderr, which they share. Their messages are always
+// prefixed with their name, and are written to debug with everything else
+// passed on to the command's stderr. Like outputCapture the command is given
+// the write end of a pipe.
+type stderrSplitter struct {
+	r, w *os.File
+	done chan struct{}
+}
+
+func newStderrSplitter(cmdStderr, debug io.Writer) (*stderrSplitter, error) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	s := &stderrSplitter{
+		r:    r,
+		w:    w,
+		done: make(chan struct{}),
+	}
+	go func() {
+		defer close(s.done)
+		br := bufio.NewReader(r)
+		for {
+			// the command's output is only passed on a line at a time
+			line, err := br.ReadString('\n')
+			if strings.HasPrefix(line, "strace: ") || strings.HasPrefix(line, "sudo: ") {
+				io.WriteString(debug, line)
+			} else if line != "" {
+				io.WriteString(cmdStderr, line)
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+	return s, nil
+}
+
+// started closes our copy of the write end of the pipe once the command has
+// been started with it
+func (s *stderrSplitter) started() {
+	s.w.Close()
+}
+
+// finish waits for the rest of the output after the run is over
+func (s *stderrSplitter) finish() {
+	s.w.Close()
+	select {
+	case <-s.done:
+	case <-time.After(captureDrainTimeout):
+	}
+	s.r.Close()
+}
+
+// OutputMarkerTimes are when the lines matching --start-output-marker and
+// --wait-for-output were printed, relative to starting the command
+type OutputMarkerTimes struct {
+	// Start is zero without --start-output-marker
+	Start time.Duration
+	Ready time.Duration
+	// Delta is the time from the start marker to the ready marker
+	Delta time.Duration
+}
+
+// outputMarkers looks through the lines the command prints for the ready
+// marker, only after the start marker if there is one
+type outputMarkers struct {
+	start, ready *regexp.Regexp
+
+	mu        sync.Mutex
+	startedAt time.Time
+	readyAt   time.Time
+	// readyCh is closed once the ready marker has been printed
+	readyCh chan struct{}
+}
+
+func newOutputMarkers(start, ready *regexp.Regexp) *outputMarkers {
+	return &outputMarkers{
+		start:   start,
+		ready:   ready,
+		readyCh: make(chan struct{}),
+	}
+}
+
+func (m *outputMarkers) line(line string) {
+	now := time.Now()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if !m.readyAt.IsZero() {
+		return
+	}
+	if m.start != nil && m.startedAt.IsZero() {
+		if !m.start.MatchString(line) {
+			return
+		}
+		m.startedAt = now
+	}
+	if m.ready.MatchString(line) {
+		m.readyAt = now
+		close(m.readyCh)
+	}
+}
+
+// times returns when the markers were printed relative to start
+func (m *outputMarkers) times(start time.Time) (*OutputMarkerTimes, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.readyAt.IsZero() {
+		if m.start != nil && m.startedAt.IsZero() {
+			return nil, fmt.Errorf("no line matching the start marker %q was printed", m.start)
+		}
+		return nil, fmt.Errorf("no line matching %q was printed", m.ready)
+	}
+	t := &OutputMarkerTimes{
+		Ready: m.readyAt.Sub(start),
+		Delta: m.readyAt.Sub(start),
+	}
+	if m.start != nil {
+		t.Start = m.startedAt.Sub(start)
+		t.Delta = m.readyAt.Sub(m.startedAt)
+	}
+	return t, nil
+}
+
+// writer returns a writer which passes everything on to tee while looking
+// at every complete line for the markers
+func (m *outputMarkers) writer(tee io.Writer) io.Writer {
+	return &markerWriter{markers: m, tee: tee}
+}
+
+type markerWriter struct {
+	markers *outputMarkers
+	tee     io.Writer
+	partial []byte
+}
+
+func (w *markerWriter) Write(p []byte) (int, error) {
+	n, err := w.tee.Write(p)
+	w.partial = append(w.partial, p...)
+	for {
+		i := bytes.IndexByte(w.partial, '\n')
+		if i < 0 {
+			break
+		}
+		w.markers.line(string(w.partial[:i]))
+		w.partial = w.partial[i+1:]
+	}
+	return n, err
+}
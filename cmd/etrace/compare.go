@@ -0,0 +1,192 @@
+/*
+ * Copyright (C) 2019 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type cmdCompare struct {
+	RegressionMetric    string `long:"regression-metric" default:"time-to-display" choice:"time-to-display" choice:"time-to-run" description:"Which time to compare the medians of"`
+	RegressionThreshold string `long:"regression-threshold" value-name:"percent" description:"Exit with an error if the median of the metric of any command got slower by more than this, such as 5%, for use in CI"`
+	JSONOutput          bool   `short:"j" long:"json" description:"Output the comparison in JSON"`
+
+	Args struct {
+		Old string `positional-arg-name:"old" description:"JSON results from etrace run or etrace batch to compare against"`
+		New string `positional-arg-name:"new" description:"JSON results to compare, with the same commands"`
+	} `positional-args:"yes" required:"yes"`
+}
+
+// CommandComparison is how a command's runs in the old results compare to
+// those in the new ones
+type CommandComparison struct {
+	Old RunSummary
+	New RunSummary
+	// Change is how much slower the median of the new runs is than the old
+	// in percent, where negative is faster
+	Change    float64
+	Regressed bool
+}
+
+// CompareResult is the comparison of every command in two results files
+type CompareResult struct {
+	Etrace    BuildInfo
+	Metric    string
+	Threshold float64 `json:",omitempty"`
+	Commands  map[string]*CommandComparison
+}
+
+// parsePercent parses a percentage with or without the % sign
+func parsePercent(s string) (float64, error) {
+	percent, err := strconv.ParseFloat(strings.TrimSuffix(strings.TrimSpace(s), "%"), 64)
+	if err != nil {
+		return 0, fmt.Errorf("cannot parse %q as a percentage", s)
+	}
+	if percent < 0 {
+		return 0, fmt.Errorf("cannot use a negative percentage %q", s)
+	}
+	return percent, nil
+}
+
+// metricTimes returns the metric of every valid run in res
+func metricTimes(res *OutputResult, metric string) []time.Duration {
+	if metric == "time-to-run" {
+		return res.runTimes()
+	}
+	return res.startupTimes()
+}
+
+// readCommandResults reads the results in path by command
+func readCommandResults(path string) (map[string]*OutputResult, error) {
+	results, err := readResults(path)
+	if err != nil {
+		return nil, err
+	}
+	byCommand := make(map[string]*OutputResult, len(results))
+	for _, fr := range results {
+		byCommand[fr.command] = fr.res
+	}
+	return byCommand, nil
+}
+
+func (x *cmdCompare) Execute(args []string) error {
+	var threshold float64
+	if x.RegressionThreshold != "" {
+		var err error
+		if threshold, err = parsePercent(x.RegressionThreshold); err != nil {
+			return err
+		}
+	}
+	oldResults, err := readCommandResults(x.Args.Old)
+	if err != nil {
+		return err
+	}
+	newResults, err := readCommandResults(x.Args.New)
+	if err != nil {
+		return err
+	}
+
+	cmp := &CompareResult{
+		Etrace:    currentBuildInfo(),
+		Metric:    x.RegressionMetric,
+		Threshold: threshold,
+		Commands:  make(map[string]*CommandComparison),
+	}
+	for name, oldRes := range oldResults {
+		newRes, ok := newResults[name]
+		if !ok {
+			log.Printf("warning: %s is only in %s, not comparing it", name, x.Args.Old)
+			continue
+		}
+		oldTimes := metricTimes(oldRes, x.RegressionMetric)
+		newTimes := metricTimes(newRes, x.RegressionMetric)
+		c := &CommandComparison{
+			Old: *summarize(len(oldRes.Runs), oldTimes),
+			New: *summarize(len(newRes.Runs), newTimes),
+		}
+		if c.Old.Median != 0 && len(newTimes) != 0 {
+			c.Change = 100 * float64(c.New.Median-c.Old.Median) / float64(c.Old.Median)
+			c.Regressed = x.RegressionThreshold != "" && c.Change > threshold
+		}
+		cmp.Commands[name] = c
+	}
+	for name := range newResults {
+		if _, ok := oldResults[name]; !ok {
+			log.Printf("warning: %s is only in %s, not comparing it", name, x.Args.New)
+		}
+	}
+	if len(cmp.Commands) == 0 {
+		return fmt.Errorf("no commands are in both %s and %s", x.Args.Old, x.Args.New)
+	}
+
+	if x.JSONOutput {
+		if err := json.NewEncoder(os.Stdout).Encode(cmp); err != nil {
+			return err
+		}
+	} else {
+		cmp.writeTable(os.Stdout)
+	}
+	return cmp.regression()
+}
+
+// sortedCommands returns the names of the commands compared in order
+func (c *CompareResult) sortedCommands() []string {
+	names := make([]string, 0, len(c.Commands))
+	for name := range c.Commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (c *CompareResult) writeTable(w io.Writer) {
+	tw := tabWriterGeneric(w)
+	fmt.Fprintln(tw, "Command\tOld median\tNew median\tChange")
+	for _, name := range c.sortedCommands() {
+		cc := c.Commands[name]
+		change := fmt.Sprintf("%+.1f%%", cc.Change)
+		if cc.Regressed {
+			change += " (regressed)"
+		}
+		fmt.Fprintf(tw, "%s\t%v\t%v\t%s\n", name, rounded(cc.Old.Median), rounded(cc.New.Median), change)
+	}
+	tw.Flush()
+}
+
+// regression returns an error describing the commands which regressed, if
+// any
+func (c *CompareResult) regression() error {
+	var regressed []string
+	for _, name := range c.sortedCommands() {
+		if cc := c.Commands[name]; cc.Regressed {
+			regressed = append(regressed, fmt.Sprintf("%s (%+.1f%%)", name, cc.Change))
+		}
+	}
+	if len(regressed) == 0 {
+		return nil
+	}
+	return fmt.Errorf("the median %s regressed by more than %v%% for %s", c.Metric, c.Threshold, strings.Join(regressed, ", "))
+}
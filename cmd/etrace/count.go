@@ -0,0 +1,125 @@
+/*
+ * Copyright (C) 2019 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/anonymouse64/etrace/internal/xdotool"
+)
+
+// countWindows runs the command once and prints the windows matching the
+// window spec and which processes they belong to, without timing anything, to
+// check what the window options select
+func (x *runOptions) countWindows(w io.Writer, cmdArgs []string) error {
+	switch {
+	case x.NoWindowWait:
+		return errors.New("cannot use --count-only with --no-window-wait")
+	case x.SystemdUnit:
+		return errors.New("cannot use --count-only with --systemd-unit")
+	case x.Remote != "":
+		return errors.New("cannot use --count-only with --remote")
+	case x.Shell && strings.TrimSpace(strings.Join(cmdArgs, " ")) == "":
+		return errors.New("cannot run an empty command with --shell")
+	}
+	if err := x.checkDependencies(); err != nil {
+		return err
+	}
+	if x.Xvfb {
+		if err := x.startXvfb(); err != nil {
+			return err
+		}
+		defer x.stopXvfb()
+	}
+
+	windowspec, err := x.windowSpec(cmdArgs)
+	if err != nil {
+		return err
+	}
+	xtool := xdotool.MakeXDoToolForDisplay(x.Display)
+	wm := xtool.WindowManager()
+	_, err = exec.LookPath("wmctrl")
+	wmctrlCloses := wm.EWMH && err == nil
+
+	targetCmd := x.targetCmd(cmdArgs)
+	cmd := exec.Command(targetCmd[0], targetCmd[1:]...)
+	cmd.Dir = x.WorkDir
+	if x.Display != "" {
+		cmd.Env = append(os.Environ(), "DISPLAY="+x.Display)
+	}
+	cmd.Stdin = x.cmdStdin()
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	exited := make(chan struct{})
+	go func() {
+		cmd.Wait()
+		close(exited)
+	}()
+
+	// wait for all of the windows, as with --all-windows
+	timeout := windowWatchTimeout
+	if x.MaxStartup != 0 {
+		timeout = x.MaxStartup
+	}
+	appeared, err := xtool.WatchWindows(windowspec, x.WindowSettle, timeout)
+	if err != nil && !errors.Is(err, xdotool.ErrWindowTimeout) {
+		x.abortCommand(cmd, exited)
+		return fmt.Errorf("waiting for windows to appear: %w", err)
+	}
+
+	pids := make(map[int]bool)
+	for _, window := range appeared {
+		pid, err := xtool.PidForWindowID(window.ID)
+		if err != nil {
+			// not every client sets _NET_WM_PID
+			fmt.Fprintf(w, "Window %s (unknown pid)\n", window.ID)
+			continue
+		}
+		pids[pid] = true
+		fmt.Fprintf(w, "Window %s (pid %d)\n", window.ID, pid)
+	}
+	fmt.Fprintf(w, "%d matching windows from %d processes\n", len(appeared), len(pids))
+
+	if x.CloseMode == "none" {
+		return nil
+	}
+	for _, window := range appeared {
+		if _, err := x.closeWindow(xtool, window.ID, wmctrlCloses); err != nil {
+			log.Printf("warning: cannot close window %s: %v", window.ID, err)
+		}
+	}
+	if x.CloseMode == "graceful" || x.NoKill {
+		select {
+		case <-exited:
+			return nil
+		case <-time.After(x.ExitTimeout):
+		}
+	}
+	x.abortCommand(cmd, exited)
+	return nil
+}
@@ -0,0 +1,175 @@
+/*
+ * Copyright (C) 2019 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/anonymouse64/etrace/internal/strace"
+)
+
+type cmdDoctor struct {
+	Display string `long:"display" description:"X display to check instead of $DISPLAY"`
+}
+
+// doctorCheck is a single check of the environment etrace needs
+type doctorCheck struct {
+	name string
+	// optional checks are only needed for some options, so failing them
+	// only warns
+	optional bool
+	// check returns some details about what was found
+	check func() (string, error)
+	// hint is how to fix a failed check
+	hint string
+}
+
+func (x *cmdDoctor) checks() []doctorCheck {
+	display := x.Display
+	if display == "" {
+		display = os.Getenv("DISPLAY")
+	}
+	return []doctorCheck{
+		{
+			name: "sudo",
+			check: func() (string, error) {
+				// caches are dropped and strace is run with sudo without a
+				// terminal to ask for a password on
+				out, err := exec.Command("sudo", "-n", "true").CombinedOutput()
+				if msg := strings.TrimSpace(string(out)); err != nil && msg != "" {
+					return "", errors.New(msg)
+				}
+				if err != nil {
+					return "", err
+				}
+				return "can run without a password prompt", nil
+			},
+			hint: "run 'sudo -v' before etrace, or allow running sudo without a password",
+		},
+		{
+			name:  "strace",
+			check: strace.Version,
+			hint:  "install strace (i.e. apt install strace or snap install strace-static), or use --no-trace",
+		},
+		{
+			name: "ptrace",
+			check: func() (string, error) {
+				b, err := ioutil.ReadFile("/proc/sys/kernel/yama/ptrace_scope")
+				if os.IsNotExist(err) {
+					return "yama is not enabled", nil
+				}
+				if err != nil {
+					return "", err
+				}
+				// strace runs as root, which is only prevented from tracing
+				// with scope 3
+				scope := strings.TrimSpace(string(b))
+				if scope == "3" {
+					return "", errors.New("kernel.yama.ptrace_scope is 3, tracing is disabled")
+				}
+				return "kernel.yama.ptrace_scope is " + scope, nil
+			},
+			hint: "ptrace_scope 3 can only be reset by rebooting, use --no-trace until then",
+		},
+		{
+			name:  "xdotool",
+			check: lookPathCheck("xdotool"),
+			hint:  "install xdotool (i.e. apt install xdotool), or use --no-window-wait",
+		},
+		{
+			name:     "wmctrl",
+			optional: true,
+			check:    lookPathCheck("wmctrl"),
+			hint:     "install wmctrl (i.e. apt install wmctrl) to close windows which xdotool fails to",
+		},
+		{
+			name: "display",
+			check: func() (string, error) {
+				if display == "" {
+					return "", errors.New("DISPLAY is not set")
+				}
+				cmd := exec.Command("xdotool", "getdisplaygeometry")
+				cmd.Env = append(os.Environ(), "DISPLAY="+display)
+				if out, err := cmd.CombinedOutput(); err != nil {
+					return "", fmt.Errorf("cannot connect to %s: %s", display, strings.TrimSpace(string(out)))
+				}
+				return display, nil
+			},
+			hint: "run etrace from a graphical session or use --display, or use --no-window-wait",
+		},
+		{
+			name:     "cgroup",
+			optional: true,
+			check: func() (string, error) {
+				b, err := ioutil.ReadFile("/sys/fs/cgroup/cgroup.controllers")
+				if err != nil {
+					return "", errors.New("cgroup v2 is not mounted at /sys/fs/cgroup")
+				}
+				if !strings.Contains(" "+string(b)+" ", " memory ") {
+					return "", errors.New("the memory controller is not available")
+				}
+				return "cgroup v2 with the memory controller", nil
+			},
+			hint: "boot with cgroup v2 to use --memory-limit",
+		},
+	}
+}
+
+func lookPathCheck(name string) func() (string, error) {
+	return func() (string, error) {
+		return exec.LookPath(name)
+	}
+}
+
+func (x *cmdDoctor) Execute(args []string) error {
+	w := tabWriterGeneric(os.Stdout)
+	failed := 0
+	var hints []string
+	for _, c := range x.checks() {
+		details, err := c.check()
+		status := "PASS"
+		if err != nil {
+			status = "FAIL"
+			if c.optional {
+				status = "WARN"
+			} else {
+				failed++
+			}
+			details = err.Error()
+			hints = append(hints, fmt.Sprintf("%s: %s", c.name, c.hint))
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\n", status, c.name, details)
+	}
+	w.Flush()
+
+	if len(hints) != 0 {
+		fmt.Println()
+		for _, hint := range hints {
+			fmt.Println(hint)
+		}
+	}
+	if failed != 0 {
+		return fmt.Errorf("%d checks failed", failed)
+	}
+	return nil
+}
@@ -0,0 +1,80 @@
+/*
+ * Copyright (C) 2019 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+const (
+	// the most buckets the startup times are split into
+	histogramBuckets = 10
+	// the width of the longest bar
+	histogramWidth = 40
+)
+
+// writeHistogram prints a bar chart of how many runs' startup times fell into
+// each of a number of equally sized ranges between the fastest and slowest
+func (o *OutputResult) writeHistogram(w io.Writer) {
+	times := o.startupTimes()
+	if len(times) == 0 {
+		return
+	}
+	min, max := minDuration(times), maxDuration(times)
+
+	n := histogramBuckets
+	if len(times) < n {
+		n = len(times)
+	}
+	width := (max - min) / time.Duration(n)
+	if width == 0 {
+		// every run took the same time
+		n, width = 1, 1
+	}
+	counts := make([]int, n)
+	for _, t := range times {
+		i := int((t - min) / width)
+		// the slowest run is at the end of the last bucket
+		if i >= n {
+			i = n - 1
+		}
+		counts[i]++
+	}
+	most := 0
+	for _, count := range counts {
+		if count > most {
+			most = count
+		}
+	}
+
+	fmt.Fprintln(w, "Startup time histogram:")
+	tw := tabWriterGeneric(w)
+	for i, count := range counts {
+		lo := min + time.Duration(i)*width
+		hi := lo + width
+		if i == n-1 {
+			hi = max
+		}
+		bar := strings.Repeat("#", (count*histogramWidth+most-1)/most)
+		fmt.Fprintf(tw, "%v - %v\t%d\t%s\n", rounded(lo), rounded(hi), count, bar)
+	}
+	tw.Flush()
+}
@@ -18,39 +18,123 @@
 package main
 
 import (
+	"bufio"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"log"
+	"math/rand"
 	"os"
 	"os/exec"
+	"os/signal"
 	"path/filepath"
+	"regexp"
+	"strconv"
 	"strings"
+	"sync"
 	"syscall"
 	"text/tabwriter"
 	"time"
 
+	"github.com/anonymouse64/etrace/internal/desktop"
 	"github.com/anonymouse64/etrace/internal/files"
+	"github.com/anonymouse64/etrace/internal/flatpak"
+	"github.com/anonymouse64/etrace/internal/perf"
 	"github.com/anonymouse64/etrace/internal/profiling"
+	"github.com/anonymouse64/etrace/internal/remote"
 	"github.com/anonymouse64/etrace/internal/snaps"
 	"github.com/anonymouse64/etrace/internal/strace"
+	"github.com/anonymouse64/etrace/internal/systemd"
 	"github.com/anonymouse64/etrace/internal/xdotool"
+	"github.com/anonymouse64/etrace/internal/xvfb"
 	flags "github.com/jessevdk/go-flags"
 )
 
 // Command is the command for the runner
 type Command struct {
-	Run                  cmdRun `command:"run" description:"Run a command"`
-	ShowErrors           bool   `short:"e" long:"errors" description:"Show errors as they happen"`
-	AdditionalIterations uint   `short:"n" long:"additional-iterations" description:"Number of additional iterations to run (1 iteration is always run)"`
+	Run                  cmdRun     `command:"run" description:"Run a command"`
+	Batch                cmdBatch   `command:"batch" description:"Run a list of commands read from a file or stdin"`
+	Version              cmdVersion `command:"version" description:"Show the version of etrace"`
+	Doctor               cmdDoctor  `command:"doctor" description:"Check that everything etrace needs is set up"`
+	Merge                cmdMerge   `command:"merge" description:"Combine the JSON results of several runs into statistics grouped by command, file or tag"`
+	Compare              cmdCompare `command:"compare" description:"Compare the median startup time of the commands in two JSON results"`
+	ShowErrors           bool       `short:"e" long:"errors" description:"Show errors as they happen"`
+	AdditionalIterations uint       `short:"n" long:"additional-iterations" description:"Number of additional iterations to run (1 iteration is always run)"`
+	Seed                 int64      `long:"seed" description:"Seed for any randomized ordering, if not specified a seed is picked and recorded in the output"`
+	Precision            int        `long:"precision" value-name:"digits" description:"Round the durations shown in tables and Markdown to this many significant digits, the JSON output is never rounded"`
+	ShowVersion          func()     `long:"version" description:"Show the version of etrace"`
 }
 
 // OutputResult is the result of running a command with various information
 // encoded in it
 type OutputResult struct {
-	Runs []Execution
+	// Etrace is the build of etrace which produced the result
+	Etrace BuildInfo
+	// Command is the command as it was given to etrace
+	Command []string
+	// TraceCommand is the full command line used to run the command, including
+	// any wrapping with sudo, strace or snap run
+	TraceCommand  []string
+	StraceVersion string
+	// Seed is the seed used for any randomized ordering, so that it can be
+	// reproduced with --seed
+	Seed int64
+	// SlowestLogIteration is the number of the iteration whose strace log
+	// was kept with --keep-slowest-log
+	SlowestLogIteration int
+	// InterIterationDelay is how long was slept between iterations
+	InterIterationDelay time.Duration
+	// WindowManager is the window manager of the display the windows were on
+	WindowManager string
+	// Tags are the labels given with --tag
+	Tags map[string]string
+	// System is the machine the command ran on, it isn't known with --remote
+	System *profiling.SystemInfo `json:",omitempty"`
+	// Summary aggregates the startup times of every iteration, it's only set
+	// with --sample-runs as Runs then only has some of them
+	Summary *RunSummary `json:",omitempty"`
+	// SampledIterations are the numbers of the iterations kept in Runs with
+	// --sample-runs
+	SampledIterations []int `json:",omitempty"`
+	Runs              []Execution
+}
+
+// failure returns an error describing the first iteration which had errors or
+// where the command failed, if any
+func (o *OutputResult) failure() error {
+	for i, run := range o.Runs {
+		if len(run.Errors) != 0 {
+			return fmt.Errorf("iteration %d had %d errors, the first being: %v", i+1, len(run.Errors), run.Errors[0])
+		}
+		if run.OverMaxStartup {
+			return fmt.Errorf("iteration %d: no window appeared within the maximum startup time", i+1)
+		}
+		if run.UnderMinStartup {
+			return fmt.Errorf("iteration %d: the window appeared faster than the minimum startup time, it was likely already open", i+1)
+		}
+		if run.ExitCode > 0 {
+			return fmt.Errorf("iteration %d: command exited with status %d", i+1, run.ExitCode)
+		}
+	}
+	return nil
+}
+
+// WindowResult is a window which matched the window spec during a run
+type WindowResult struct {
+	ID            string
+	TimeToDisplay time.Duration
+	// Pid is the pid owning the window, which is only known when etrace
+	// closes the window itself
+	Pid int
+	// CloseMethod is how the window was closed, "wmctrl" when the window
+	// manager was asked to close it or "xdotool" when its connection to X was
+	// destroyed, and empty when it wasn't closed or closing it failed
+	CloseMethod string `json:",omitempty"`
+	// Killed is whether the window's process was still running after
+	// closing the window and had to be killed
+	Killed bool `json:",omitempty"`
 }
 
 // Execution represents a single run
@@ -58,30 +142,222 @@ type Execution struct {
 	ExecveTiming  *strace.ExecveTiming
 	TimeToDisplay time.Duration
 	TimeToRun     time.Duration
-	Errors        []error
+	TimeToIdle    time.Duration
+	Windows       []WindowResult
+	SnapInfo      *snaps.Info
+	// TimeToRunCPU is the user and system CPU time used by the windows'
+	// processes before they were closed, or by the command and the children
+	// it waited for if it exited by itself, which includes strace when tracing
+	TimeToRunCPU time.Duration
+	// TimeToDBusName is when the command acquired the name given with
+	// --wait-for-dbus-name
+	TimeToDBusName time.Duration
+	// TimeToActive is when the window became the active one, ready for input,
+	// only measured with --wait-for-active
+	TimeToActive time.Duration `json:",omitempty"`
+	// TimeToLaunch is how long after starting the command, the launcher,
+	// the events given with --launch-events were all sent, which is where
+	// the other times are measured from
+	TimeToLaunch time.Duration `json:",omitempty"`
+	// TimeToFile is when the file given with --wait-for-file appeared
+	TimeToFile time.Duration `json:",omitempty"`
+	// OutputMarkers are only collected with --wait-for-output
+	OutputMarkers *OutputMarkerTimes
+	// FlatpakInfo is only collected with --use-flatpak-run
+	FlatpakInfo *flatpak.Info
+	// SyscallSummary is only collected with --strace-summary
+	SyscallSummary *strace.SyscallSummary
+	// SystemBusyness is only measured with --system-busyness
+	SystemBusyness *profiling.SystemBusyness
+	// Memory is only collected with --memory-limit
+	Memory *profiling.MemoryEvents
+	// MappedFiles is only collected with --mmap-fault-analysis
+	MappedFiles []profiling.MappedFile `json:",omitempty"`
+	// Denials are the AppArmor and seccomp denials logged for the command,
+	// only collected with --report-denials
+	Denials []snaps.Denial `json:",omitempty"`
+	// PerfCounters is only collected with --perf-counters
+	PerfCounters *perf.Counters
+	// Unit is the state of the unit after starting it with --systemd-unit
+	Unit *systemd.Timing
+	// Daemonized is whether the command left processes running in the
+	// background after exiting, which were followed instead
+	Daemonized bool
+	// ClockSkewed is whether the strace timings disagreed with the time
+	// measured here by more than --clock-skew-threshold
+	ClockSkewed bool
+	// VoluntaryCtxSwitches and InvoluntaryCtxSwitches are the context switches
+	// of the windows' processes just before they were closed, or of the
+	// command and the children it waited for if it exited by itself
+	VoluntaryCtxSwitches   int64
+	InvoluntaryCtxSwitches int64
+	// TracingOverhead is how much longer the traced run took to start up than
+	// an untraced run right after it, only measured with --measure-overhead
+	TracingOverhead time.Duration
+	// OverMaxStartup is whether no window appeared within --max-startup, in
+	// which case the iteration was aborted
+	OverMaxStartup bool
+	// UnderMinStartup is whether the window appeared faster than
+	// --min-startup, which likely means it was already open and the run
+	// isn't valid
+	UnderMinStartup bool
+	// ExitCode is the exit status of the command, or -1 if it was killed or
+	// hadn't exited by the end of the run
+	ExitCode int
+	Output   *CapturedOutput
+	// Tags are the labels given with --tag, repeated here so that each run
+	// can be grouped on its own
+	Tags map[string]string
+	// Annotations are set by the prepare and restore scripts with
+	// --script-annotations
+	Annotations map[string]interface{}
+	// Env is the environment variables the command was run with which
+	// matched --record-env
+	Env    map[string]string `json:",omitempty"`
+	Errors errorList
+}
+
+// runOptions are the options shared by all commands which run programs
+type runOptions struct {
+	WindowName        string        `short:"w" long:"window-name" description:"Window name to wait for, or a comma separated list of names where any of them will do"`
+	PrepareScript     string        `short:"p" long:"prepare-script" description:"Script to run to prepare a run, with the number of the iteration and the number of iterations in $ETRACE_ITERATION and $ETRACE_ITERATIONS, or with $ETRACE_UNTRACED_ITERATION instead for the untraced run of --measure-overhead"`
+	PrepareScriptArgs []string      `long:"prepare-script-args" description:"Args to provide to the prepare script"`
+	RestoreScript     string        `short:"r" long:"restore-script" description:"Script to run to restore after a run, with $ETRACE_ITERATION and $ETRACE_ITERATIONS set like for the prepare script"`
+	RestoreScriptArgs []string      `long:"restore-script-args" description:"Args to provide to the restore script"`
+	ScriptTimeout     time.Duration `long:"script-timeout" description:"Kill the prepare and restore scripts if they run for longer than this"`
+	ScriptAnnotations bool          `long:"script-annotations" description:"Add the JSON object printed on the last line by the prepare and restore scripts to the iteration's results"`
+	WindowClass       string        `short:"c" long:"class-name" description:"Window class to use with xdotool instead of the the first Command, or a comma separated list of classes where any of them will do"`
+	DesktopFile       string        `long:"desktop-file" value-name:"path" description:"Use the StartupWMClass of this .desktop file as the window class, or with auto find the command's .desktop file"`
+	LiteralClass      bool          `long:"literal-class" description:"Match the window class as plain text rather than as a regular expression, it still matches anywhere in the class and ignores case like without this"`
+	NoTrace           bool          `short:"t" long:"no-trace" description:"Don't trace the process, just time the total execution"`
+	Shell             bool          `long:"shell" description:"Run the command, joined with spaces, through sh -c to use pipes and redirects, which makes sh the first exec traced and the first word of the command the fallback window class"`
+	RunThroughSnap    bool          `short:"s" long:"use-snap-run" description:"Run command through snap run"`
+	SnapRunArgs       []string      `long:"snap-run-args" description:"Args to provide to snap run before the snap's name"`
+	DiscardSnapNs     bool          `short:"d" long:"discard-snap-ns" description:"Discard the snap namespace before running the snap"`
+	RunThroughFlatpak bool          `long:"use-flatpak-run" description:"Run command, which is a flatpak app ID, through flatpak run"`
+	KillFlatpak       bool          `long:"kill-flatpak-instance" description:"Stop any running instance of the flatpak before running it"`
+	NoStdin           bool          `long:"no-stdin" description:"Never connect the command's stdin to etrace's, by default it is only connected when it is a terminal so that the command can't block on or consume piped input"`
+	ProgramStdoutLog  string        `long:"cmd-stdout" description:"Log file for run command's stdout, {iter} is replaced with the iteration to log each one to its own file"`
+	ProgramStderrLog  string        `long:"cmd-stderr" description:"Log file for run command's stderr, {iter} is replaced with the iteration to log each one to its own file"`
+	JSONOutput        bool          `short:"j" long:"json" description:"Output results in JSON"`
+	JSONIndent        bool          `long:"json-indent" description:"Indent the JSON output to make it readable, implies --json"`
+	Markdown          bool          `long:"markdown" description:"Output results as a Markdown table"`
+	OpenMetrics       bool          `long:"openmetrics" description:"Output results in the OpenMetrics text format, with the slowest iteration of every startup time bucket as an exemplar"`
+	OutputFile        string        `short:"o" long:"output-file" description:"A file to output the results (empty string means stdout)"`
+	SQLite            string        `long:"sqlite" value-name:"file" description:"Also append every run as a row of the runs table in this SQLite database, creating it if needed, to query the results over time"`
+	NoWindowWait      bool          `long:"no-window-wait" description:"Don't wait for the window to appear, just run until the program exits"`
+	NoKill            bool          `long:"no-kill" description:"Same as --close-mode=graceful"`
+	CloseMode         string        `long:"close-mode" default:"kill" choice:"kill" choice:"graceful" choice:"none" description:"How to end the command once its window appears: close the windows and kill it, close the windows and wait for it to exit, or leave it running"`
+	ExitTimeout       time.Duration `long:"exit-timeout" default:"10s" description:"How long to wait for the command to exit with --close-mode=graceful before killing it"`
+	InspectTimeout    time.Duration `long:"inspect-timeout" default:"5m" description:"How long to wait for enter to be pressed with --close-mode=none before continuing"`
+	Remote            string        `long:"remote" value-name:"user@host" description:"Run the command on another machine over ssh (requires --no-window-wait)"`
+	ReplayEvents      string        `long:"replay-events" value-name:"file" description:"After the window appears, run the xdotool commands in this file against it, one per line after a delay such as: 500ms key --window {window} ctrl+n"`
+	LaunchEvents      string        `long:"launch-events" value-name:"file" description:"After starting the command, which is a launcher, run the xdotool commands in this file to launch the app from it, such as: 1s type gnome-calculator, and measure the startup time from after the last one"`
+	WaitForDBusName   string        `long:"wait-for-dbus-name" value-name:"name" description:"Also measure the time until the command acquires this well-known D-Bus name"`
+	DBusBus           string        `long:"dbus-bus" default:"session" choice:"session" choice:"system" description:"Bus to wait for the D-Bus name on"`
+	WaitForOutput     string        `long:"wait-for-output" value-name:"regex" description:"Instead of waiting for the command to exit, wait for it to print a line matching this regex and then kill it (requires --no-window-wait)"`
+	WaitForFile       string        `long:"wait-for-file" value-name:"path" description:"Instead of waiting for the command to exit, wait for it to create this file, such as a pid file or socket, and then kill it (requires --no-window-wait)"`
+	StartOutputMarker string        `long:"start-output-marker" value-name:"regex" description:"Measure the startup time from when the command prints a line matching this regex rather than from launching it (requires --wait-for-output)"`
+	WaitForActive     bool          `long:"wait-for-active" description:"After the window appears, also measure the time until it becomes the active window and is ready for input"`
+	WaitForIdle       bool          `long:"wait-for-idle" description:"After the window appears, also measure the time until the window's process stops using the CPU"`
+	IdleThreshold     float64       `long:"idle-threshold" default:"5" description:"CPU usage percentage below which the process is considered idle"`
+	IdlePeriod        time.Duration `long:"idle-period" default:"1s" description:"How long the CPU usage must stay below the idle threshold"`
+	IdleTimeout       time.Duration `long:"idle-timeout" default:"60s" description:"How long to wait for the process to go idle"`
+	RecordEnv         []string      `long:"record-env" value-name:"glob" description:"Record the environment variables the command was run with whose names match this glob, such as GTK_* or LC_*, in the results (can be repeated)"`
+	WorkDir           string        `long:"workdir" value-name:"dir" description:"Run the command in this directory instead of the current one"`
+	FreshHome         bool          `long:"fresh-home" description:"Run every iteration with $HOME set to a new empty directory to measure first run startup"`
+	ClearJITCache     []string      `long:"clear-jit-cache" value-name:"runtime" description:"Remove the code this runtime, one of jvm, mesa, mono or v8, compiled and cached in previous iterations before every iteration, for a true cold start (can be repeated)"`
+	NoFlushCaches     bool          `long:"no-flush-caches" description:"Don't drop the kernel caches before every iteration, which needs root, to measure startup with warm caches"`
+	EvictTargetOnly   bool          `long:"evict-target-only" description:"Instead of dropping all caches, only evict the files the command executed or mapped in a previous iteration"`
+	MemoryLimit       string        `long:"memory-limit" value-name:"size" description:"Run the command in a cgroup v2 limited to this much memory, such as 512M, to measure startup under memory pressure (when tracing, strace is in the cgroup too and its memory counts against the limit, use --no-trace for the command's alone)"`
+	FailedOpens       bool          `long:"failed-opens" description:"Also trace file accesses to report paths which were looked for but don't exist"`
+	NetworkActivity   bool          `long:"network-activity" description:"Also trace socket and connect calls to report every connection to another host the command attempted while starting, such as update checks"`
+	ReportDenials     bool          `long:"report-denials" description:"Report the AppArmor and seccomp denials the kernel logged for the command during every iteration, which can slow down the startup of confined snaps"`
+	MmapFaultAnalysis bool          `long:"mmap-fault-analysis" description:"Before closing the windows, report how much of each file their processes mapped was actually faulted in, to see whether prefetching the files would help"`
+	Futexes           bool          `long:"futexes" description:"Also trace futex calls to report how long threads waited on locks"`
+	Threads           bool          `long:"threads" description:"Also trace clone and the other process calls to count the threads the command created and the most running at once"`
+	FirstDraw         bool          `long:"first-draw" description:"Also trace writes to the X server to estimate when the command first drew something"`
+	StraceSummary     bool          `long:"strace-summary" description:"Only count the time spent in each syscall with strace -c, which has much less overhead than tracing every exec"`
+	MaxEvents         int           `long:"max-events" description:"Only keep the first N exec events and failed paths from the trace, counting the rest"`
+	MinSyscallTime    time.Duration `long:"min-syscall-duration" value-name:"duration" description:"Trace every syscall and report the ones which took at least this long, slowest first, to find the expensive calls (tracing every syscall slows down the command more)"`
+	StraceStringLimit int           `long:"strace-string-limit" value-name:"N" description:"Print string arguments up to N characters long in the trace instead of strace's default of 32, to see long paths in full"`
+	StraceBufferSize  int           `long:"strace-buffer-size" value-name:"MiB" default:"16" description:"How much of the trace to keep in memory when parsing it falls behind, after which strace and so the command are blocked writing it and a warning is shown, 0 only uses the kernel's pipe buffer"`
+	StraceLogDir      string        `long:"strace-log-dir" description:"Directory to save the strace log of every iteration to"`
+	MeasureOverhead   bool          `long:"measure-overhead" description:"Also run every iteration without tracing to report how much tracing slows down startup"`
+	TracerCmd         string        `long:"tracer-cmd" value-name:"template" description:"Command to trace with instead of strace, where {fifo} is replaced with where to write the trace and {cmd} with the command"`
+	TracerFormat      string        `long:"tracer-format" choice:"strace" choice:"strace-summary" default:"strace" description:"Format of what --tracer-cmd writes, like strace -ttt -f or strace -c"`
+	StraceFifo        string        `long:"strace-fifo" value-name:"path" description:"Existing named pipe for strace to write the trace to, instead of one made by etrace"`
+	StraceDebug       bool          `long:"strace-debug" description:"Show what strace itself prints on stderr separately from the command's stderr"`
+	KeepSlowestLog    string        `long:"keep-slowest-log" value-name:"path" description:"Save the strace log of only the iteration with the slowest startup to this file"`
+	Compress          bool          `long:"compress" description:"Gzip the saved strace logs and the output file, adding .gz to their names"`
+	Pty               bool          `long:"pty" description:"Run the command in a pseudo-terminal so it sees a TTY, with both its stdout and stderr going to its stdout"`
+	CaptureOutput     bool          `long:"capture-output" description:"Include the command's stdout and stderr in the results"`
+	CaptureOutputMax  int           `long:"capture-output-max" default:"65536" description:"Maximum number of bytes of each output stream to keep with --capture-output"`
+	IterationDelay    time.Duration `long:"inter-iteration-delay" description:"How long to sleep between iterations to let the system settle"`
+	Display           string        `long:"display" description:"X display to run the command on and look for its windows on, instead of $DISPLAY"`
+	Xvfb              bool          `long:"xvfb" description:"Start an Xvfb virtual X server on a free display to run the command on, and stop it afterwards, to run without a display such as in CI"`
+	XvfbScreen        string        `long:"xvfb-screen" default:"1920x1080x24" value-name:"WxHxD" description:"Width, height and depth of the Xvfb screen"`
+	ClockSkewLimit    time.Duration `long:"clock-skew-threshold" default:"1s" description:"Warn when the strace measured run time differs from the measured run time by more than this"`
+	FailOnError       bool          `long:"fail-on-error" description:"Exit with an error if any iteration had errors or the command exited with a non-zero status"`
+	AllWindows        bool          `long:"all-windows" description:"Record the time each matching window appears, until no new windows appear for the settle period"`
+	WindowSettle      time.Duration `long:"window-settle" default:"2s" description:"How long to wait for more windows with --all-windows"`
+	Columns           string        `long:"columns" description:"Comma separated list of columns to show for each exec, any of start, stop, elapsed, exec and argv for the full command line"`
+	Tree              bool          `long:"tree" description:"Show the execs as a tree of the exec or process which led to each one, with how long each ran on its own and with everything under it"`
+	FilterSyscall     []string      `long:"filter-syscall" description:"Only show events from this syscall (can be repeated)"`
+	FilterPath        string        `long:"filter-path" description:"Only show events for paths matching this glob, where * does not match /"`
+	Histogram         bool          `long:"histogram" description:"Print a histogram of the startup times of all iterations"`
+	IterationTable    bool          `long:"iteration-table" description:"Print a table of the startup time and number of errors of every iteration, to spot flaky ones"`
+	Report            string        `long:"report" choice:"best" choice:"mean" choice:"median" choice:"worst" description:"Print a single startup time selected from all iterations as the result"`
+	Raw               bool          `long:"raw" description:"Only print the number selected with --report, for use in scripts"`
+	RawUnit           string        `long:"raw-unit" default:"ms" choice:"ns" choice:"us" choice:"ms" choice:"s" description:"Unit of the number printed with --raw"`
+	Tag               []string      `long:"tag" value-name:"key=value" description:"Label to add to the results for grouping them later (can be repeated)"`
+	KeepRunning       bool          `long:"keep-running" description:"After the window appears, leave the command running and traced until interrupted with Ctrl-C to profile interacting with it"`
+	SampleRuns        int           `long:"sample-runs" value-name:"N" description:"Only include N iterations in the JSON output, from the fastest to the slowest, along with a summary of all of them"`
+	SystemBusyness    bool          `long:"system-busyness" description:"Measure how busy the rest of the system was during every iteration from /proc/stat, to explain outliers"`
+	PerfCounters      bool          `long:"perf-counters" description:"Count hardware events such as instructions, cache misses and branch misses with perf stat (requires --no-trace)"`
+	MaxStartup        time.Duration `long:"max-startup" description:"Stop waiting for the window after this long, aborting the iteration and recording it as over the maximum startup time"`
+	MinStartup        time.Duration `long:"min-startup" description:"Mark iterations where the window appeared faster than this as invalid, as it was likely already open"`
+	SystemdUnit       bool          `long:"systemd-unit" description:"Start the command, which is a systemd unit, with systemctl and measure the time until it is active or has notified that it is ready (requires --no-trace)"`
+	SystemdUser       bool          `long:"systemd-user" description:"Start the unit with the user's service manager instead of the system's"`
+
+	// set up by prepare
+	output       *files.AtomicFile
+	displayOpts  strace.DisplayOptions
+	remoteUser   string
+	seed         int64
+	tags         map[string]string
+	events       []xdotool.Event
+	launchEvents []xdotool.Event
+	startMarker  *regexp.Regexp
+	readyMarker  *regexp.Regexp
+	// logPrefix is prepended to the name of saved strace logs
+	logPrefix string
+	// untracedPass runs a single iteration for --measure-overhead, after
+	// the traced iteration with the index tracedIteration
+	untracedPass     bool
+	tracedIteration  uint
+	tracedIterations uint
+	// xserver is the Xvfb started with --xvfb
+	xserver *xvfb.Server
 }
 
 type cmdRun struct {
-	WindowName        string   `short:"w" long:"window-name" description:"Window name to wait for"`
-	PrepareScript     string   `short:"p" long:"prepare-script" description:"Script to run to prepare a run"`
-	PrepareScriptArgs []string `long:"prepare-script-args" description:"Args to provide to the prepare script"`
-	RestoreScript     string   `short:"r" long:"restore-script" description:"Script to run to restore after a run"`
-	RestoreScriptArgs []string `long:"restore-script-args" description:"Args to provide to the restore script"`
-	WindowClass       string   `short:"c" long:"class-name" description:"Window class to use with xdotool instead of the the first Command"`
-	NoTrace           bool     `short:"t" long:"no-trace" description:"Don't trace the process, just time the total execution"`
-	RunThroughSnap    bool     `short:"s" long:"use-snap-run" description:"Run command through snap run"`
-	DiscardSnapNs     bool     `short:"d" long:"discard-snap-ns" description:"Discard the snap namespace before running the snap"`
-	ProgramStdoutLog  string   `long:"cmd-stdout" description:"Log file for run command's stdout"`
-	ProgramStderrLog  string   `long:"cmd-stderr" description:"Log file for run command's stderr"`
-	JSONOutput        bool     `short:"j" long:"json" description:"Output results in JSON"`
-	OutputFile        string   `short:"o" long:"output-file" description:"A file to output the results (empty string means stdout)"`
-	NoWindowWait      bool     `long:"no-window-wait" description:"Don't wait for the window to appear, just run until the program exits"`
+	runOptions
+
+	CountOnly bool `long:"count-only" description:"Only run the command once and print how many windows matched and their pids, without tracing or timing anything, to check the window options"`
 
 	Args struct {
 		Cmd []string `description:"Command to run" required:"yes"`
 	} `positional-args:"yes" required:"yes"`
 }
 
+// how long to wait for any window to appear with --all-windows
+const windowWatchTimeout = 2 * time.Minute
+
+// activeWindowTimeout is how long to wait for a window to become active, which
+// with focus stealing prevention it may never do
+const activeWindowTimeout = 10 * time.Second
+
 // The current input command
 var currentCmd Command
 var parser = flags.NewParser(&currentCmd, flags.Default)
@@ -93,6 +369,7 @@ func main() {
 	}
 
 	log.SetFlags(log.LstdFlags | log.Lshortfile)
+	currentCmd.ShowVersion = versionFlag
 	_, err = parser.Parse()
 	if err != nil {
 		os.Exit(1)
@@ -103,8 +380,12 @@ func tabWriterGeneric(w io.Writer) *tabwriter.Writer {
 	return tabwriter.NewWriter(w, 5, 3, 2, ' ', 0)
 }
 
-func wmctrlCloseWindow(name string) error {
-	out, err := exec.Command("wmctrl", "-c", name).CombinedOutput()
+func wmctrlCloseWindow(display, wid string) error {
+	cmd := exec.Command("wmctrl", "-i", "-c", wid)
+	if display != "" {
+		cmd.Env = append(os.Environ(), "DISPLAY="+display)
+	}
+	out, err := cmd.CombinedOutput()
 	if err != nil {
 		log.Println(string(out))
 		return err
@@ -112,278 +393,1750 @@ func wmctrlCloseWindow(name string) error {
 	return nil
 }
 
-var errs []error
+// closeWindow closes the window by asking the window manager to close it, like
+// clicking its close button, with --close-mode=graceful so that the command
+// can exit by itself, otherwise by destroying its connection to X, returning
+// which of wmctrl or xdotool closed it
+func (x *runOptions) closeWindow(xtool xdotool.Xtooler, wid string, wmctrlCloses bool) (string, error) {
+	if x.CloseMode == "graceful" && wmctrlCloses {
+		if err := wmctrlCloseWindow(x.Display, wid); err == nil {
+			return "wmctrl", nil
+		}
+	}
+	if err := xtool.CloseWindowID(wid); err != nil {
+		return "", err
+	}
+	return "xdotool", nil
+}
 
-func resetErrors() {
-	errs = nil
+var (
+	keypressesOnce sync.Once
+	keypresses     chan struct{}
+)
+
+// waitForKeypress waits for enter to be pressed, or for the timeout
+func waitForKeypress(timeout time.Duration) {
+	// a single reader is shared so that one left waiting after a timeout
+	// doesn't swallow the next keypress
+	keypressesOnce.Do(func() {
+		keypresses = make(chan struct{})
+		go func() {
+			r := bufio.NewReader(os.Stdin)
+			for {
+				if _, err := r.ReadString('\n'); err != nil {
+					return
+				}
+				keypresses <- struct{}{}
+			}
+		}()
+	})
+
+	fmt.Fprintf(os.Stderr, "Leaving the command running, press enter to continue (or wait %v)\n", timeout)
+	select {
+	case <-keypresses:
+	case <-time.After(timeout):
+	}
 }
 
-func logError(err error) {
-	errs = append(errs, err)
-	if currentCmd.ShowErrors {
-		log.Println(err)
+// waitForInterrupt waits until either Ctrl-C is pressed or the command exits,
+// returning whether the command exited, which it usually does shortly after
+// Ctrl-C as it is sent the interrupt too
+func waitForInterrupt(exited <-chan struct{}) bool {
+	interrupted := make(chan os.Signal, 1)
+	signal.Notify(interrupted, os.Interrupt)
+	defer signal.Stop(interrupted)
+
+	select {
+	case <-exited:
+		return true
+	case <-interrupted:
+	}
+	select {
+	case <-exited:
+		return true
+	case <-time.After(time.Second):
+		return false
 	}
 }
 
-func (x *cmdRun) Execute(args []string) error {
-	// check the output file
-	w := os.Stdout
-	if x.OutputFile != "" {
-		// TODO: add option for appending?
-		// if the file already exists, delete it and open a new file
-		file, err := files.EnsureExistsAndOpen(x.OutputFile, true)
-		if err != nil {
-			return err
+// daemonizedChildren returns the processes which were re-parented to us after
+// their parent exited, which with etrace as the child subreaper are all our
+// children apart from the command itself and Xvfb
+func (x *runOptions) daemonizedChildren(cmd *exec.Cmd) []int {
+	children, err := profiling.Children(os.Getpid())
+	if err != nil {
+		logError(fmt.Errorf("listing child processes: %w", err))
+		return nil
+	}
+	var daemons []int
+	for _, pid := range children {
+		if cmd.Process != nil && pid == cmd.Process.Pid {
+			continue
 		}
-		w = file
+		if x.xserver != nil && pid == x.xserver.Pid() {
+			continue
+		}
+		daemons = append(daemons, pid)
 	}
+	return daemons
+}
 
-	outRes := OutputResult{}
-	i := uint(0)
-	for i = 0; i < 1+currentCmd.AdditionalIterations; i++ {
-		// run the prepare script if it's available
-		if x.PrepareScript != "" {
-			err := profiling.RunScript(x.PrepareScript, x.PrepareScriptArgs)
-			if err != nil {
-				logError(fmt.Errorf("running prepare script: %w", err))
-			}
-		}
+// clockStepped returns whether the run time strace measured, which comes from
+// wallclock timestamps, differs from the elapsed time measured with the
+// monotonic clock by more than the threshold, which happens when the wallclock
+// is stepped (i.e. by NTP) during the run
+func clockStepped(straceTotal, elapsed, threshold time.Duration) bool {
+	diff := elapsed - straceTotal
+	if diff < 0 {
+		diff = -diff
+	}
+	return straceTotal < 0 || diff > threshold
+}
 
-		// handle if the command should be run through `snap run`
-		targetCmd := x.Args.Cmd
-		if x.RunThroughSnap {
-			targetCmd = append([]string{"snap", "run"}, targetCmd...)
+// windowCtxSwitches sums the context switches of the given window pids, each
+// of which is only counted once
+func windowCtxSwitches(pids []int) (voluntary, involuntary int64) {
+	seen := make(map[int]bool)
+	for _, pid := range pids {
+		// pids which couldn't be looked up are left as 0
+		if pid == 0 || seen[pid] {
+			continue
+		}
+		seen[pid] = true
+		v, i, err := profiling.ContextSwitches(pid)
+		if err != nil {
+			logError(fmt.Errorf("reading context switches of pid %d: %w", pid, err))
+			continue
 		}
+		voluntary += v
+		involuntary += i
+	}
+	return voluntary, involuntary
+}
 
-		doneCh := make(chan bool, 1)
-		var straceErr error
-		var slg *strace.ExecveTiming
-		var cmd *exec.Cmd
-		var fw *os.File
-		if !x.NoTrace {
-			// setup private tmp dir with strace fifo
-			straceTmp, err := ioutil.TempDir("", "exec-trace")
-			if err != nil {
-				return err
-			}
-			defer os.RemoveAll(straceTmp)
-			straceLog := filepath.Join(straceTmp, "strace.fifo")
-			if err := syscall.Mkfifo(straceLog, 0640); err != nil {
-				return err
-			}
-			// ensure we have one writer on the fifo so that if strace fails
-			// nothing blocks
-			fw, err = os.OpenFile(straceLog, os.O_RDWR, 0640)
-			if err != nil {
-				return err
-			}
-			defer fw.Close()
+// windowCPUTime sums the CPU time used by the given window pids, each of which
+// is only counted once
+func windowCPUTime(pids []int) time.Duration {
+	seen := make(map[int]bool)
+	var total time.Duration
+	for _, pid := range pids {
+		if pid == 0 || seen[pid] {
+			continue
+		}
+		seen[pid] = true
+		cpu, err := profiling.ProcessCPUTime(pid)
+		if err != nil {
+			logError(fmt.Errorf("reading CPU time of pid %d: %w", pid, err))
+			continue
+		}
+		total += cpu
+	}
+	return total
+}
 
-			// read strace data from fifo async
-			go func() {
-				slg, straceErr = strace.TraceExecveTimings(straceLog, -1)
-				close(doneCh)
-			}()
+// how many of the mapped files with the most faulted in to show
+const displayedMappedFiles = 10
 
-			cmd, err = strace.TraceExecCommand(straceLog, targetCmd...)
-			if err != nil {
-				return err
-			}
-		} else {
-			// Don't setup tracing, so just use exec.Command directly
-			// x.Args.Cmd (and thus targetCmd) is guaranteed to be at least one
-			// element given that it is a required argument
-			prog := targetCmd[0]
-			var args []string
-			// setup args if there's more than 1
-			if len(targetCmd) > 1 {
-				args = targetCmd[1:]
-			}
-			cmd = exec.Command(prog, args...)
+// displayMappedFiles shows how much of the files with the most faulted in was
+// mapped and how much faulted in
+func displayMappedFiles(w io.Writer, files []profiling.MappedFile) {
+	wtab := tabWriterGeneric(w)
+	fmt.Fprintln(wtab, "Mapped files with the most faulted in:")
+	fmt.Fprintln(wtab, "\tMapped\tFaulted\tPath")
+	for i, f := range files {
+		if i == displayedMappedFiles {
+			break
 		}
+		fmt.Fprintf(wtab, "\t%d\t%d (%.0f%%)\t%s\n", f.Mapped, f.Faulted, 100*float64(f.Faulted)/float64(f.Mapped), f.Path)
+	}
+	wtab.Flush()
+}
 
-		cmd.Stdin = os.Stdin
-		// redirect all output from the child process to the log files if they exist
-		// otherwise just to this process's stdout, etc.
+// displayDenials shows the AppArmor and seccomp denials of an iteration
+func displayDenials(w io.Writer, denials []snaps.Denial) {
+	fmt.Fprintf(w, "AppArmor and seccomp denials: %d\n", len(denials))
+	if len(denials) == 0 {
+		return
+	}
+	wtab := tabWriterGeneric(w)
+	fmt.Fprintln(wtab, "\tKind\tProfile\tProcess\tOperation\tPath")
+	for _, d := range denials {
+		fmt.Fprintf(wtab, "\t%s\t%s\t%s (%d)\t%s\t%s\n", d.Kind, d.Profile, d.Comm, d.Pid, d.Operation, d.Name)
+	}
+	wtab.Flush()
+}
 
-		cmd.Stdout = os.Stdout
-		cmd.Stderr = os.Stderr
-		if x.ProgramStdoutLog != "" {
-			f, err := files.EnsureExistsAndOpen(x.ProgramStdoutLog, false)
-			if err != nil {
-				return err
-			}
-			defer f.Close()
-			cmd.Stdout = f
+// killPids forcibly kills the given pids, returning whether any of them could
+// not be killed, the pids which were still running are added to killed if it
+// isn't nil
+func killPids(pids []int, killed map[int]bool) bool {
+	failed := false
+	for _, pid := range pids {
+		// pids which couldn't be looked up are left as 0, which would signal
+		// our whole process group
+		if pid <= 0 {
+			continue
 		}
-		if x.ProgramStderrLog != "" {
-			f, err := files.EnsureExistsAndOpen(x.ProgramStderrLog, false)
-			if err != nil {
-				return err
+		// FindProcess always succeeds on unix
+		proc, _ := os.FindProcess(pid)
+		if err := proc.Signal(os.Kill); err != nil {
+			// if the process already exited then try wmctrl
+			if !strings.Contains(err.Error(), "process already finished") {
+				logError(fmt.Errorf("killing window process pid %d: %w", pid, err))
+				failed = true
 			}
-			defer f.Close()
-			cmd.Stderr = f
+		} else if killed != nil {
+			killed[pid] = true
 		}
+	}
+	return failed
+}
 
-		if x.DiscardSnapNs {
-			if !x.RunThroughSnap {
-				return errors.New("cannot use --discard-snap-ns without --use-snap-run")
-			}
-			// the name of the snap in this case is the first argument
-			err := snaps.DiscardSnapNs(x.Args.Cmd[0])
-			if err != nil {
-				return err
+// desktopWindowClass returns the window class from the .desktop file given with
+// --desktop-file, finding the one for app with auto
+func desktopWindowClass(path, app string) (string, error) {
+	if path == "auto" {
+		var err error
+		path, err = desktop.Find(filepath.Base(app))
+		if err != nil {
+			return "", err
+		}
+	}
+	return desktop.StartupWMClass(path)
+}
+
+// abortCommand kills the command along with everything it started, waiting for
+// it to exit
+func (x *runOptions) abortCommand(cmd *exec.Cmd, exited <-chan struct{}) {
+	// the command failed to start
+	if cmd.Process == nil {
+		return
+	}
+	descendants, err := profiling.Descendants(cmd.Process.Pid)
+	if err != nil {
+		logError(fmt.Errorf("listing the command's processes: %w", err))
+	}
+	// sudo and strace can't be killed here as they run as root, but they exit
+	// once the command they run is gone
+	for _, pid := range append([]int{cmd.Process.Pid}, descendants...) {
+		syscall.Kill(pid, syscall.SIGKILL)
+	}
+	select {
+	case <-exited:
+	case <-time.After(x.ExitTimeout):
+		logError(fmt.Errorf("command did not exit within %v of being aborted", x.ExitTimeout))
+	}
+}
+
+// recordedEnv returns the variables in env whose names match any of globs,
+// where later variables override earlier ones like for exec
+func recordedEnv(env []string, globs []string) map[string]string {
+	recorded := make(map[string]string)
+	for _, kv := range env {
+		kv := strings.SplitN(kv, "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		for _, glob := range globs {
+			if matched, _ := filepath.Match(glob, kv[0]); matched {
+				recorded[kv[0]] = kv[1]
+				break
 			}
 		}
+	}
+	return recorded
+}
 
-		xtool := xdotool.MakeXDoTool()
+// commandArgv returns a copy of the full argv that cmd will be run with
+func commandArgv(cmd *exec.Cmd) []string {
+	argv := make([]string, len(cmd.Args))
+	copy(argv, cmd.Args)
+	return argv
+}
 
-		tryXToolClose := true
-		tryWmctrl := false
-		var wids []string
+// waitForWindowIdle waits for the process owning the window wid to stop using
+// the CPU
+func waitForWindowIdle(xtool xdotool.Xtooler, wid string, threshold float64, period, timeout time.Duration) (time.Time, error) {
+	pid, err := xtool.PidForWindowID(wid)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("getting pid for wid %s: %w", wid, err)
+	}
+	return profiling.WaitForIdle(pid, threshold, period, 100*time.Millisecond, timeout)
+}
 
-		windowspec := xdotool.Window{}
-		// check which opts are defined
-		if x.WindowClass != "" {
-			// prefer window class from option
-			windowspec.Class = x.WindowClass
-		} else if x.WindowName != "" {
-			// then window name
-			windowspec.Name = x.WindowName
-		} else {
-			// finally fall back to base cmd as the class
-			// note we use the original command and note the processed targetCmd
-			// because for example when measuring a snap, we invoke etrace like so:
-			// $ ./etrace run --use-snap chromium
-			// where targetCmd becomes []string{"snap","run","chromium"}
-			// but we still want to use "chromium" as the windowspec class
-			windowspec.Class = filepath.Base(x.Args.Cmd[0])
-		}
-
-		// before running the final command, free the caches to get most accurate
-		// timing
-		err := profiling.FreeCaches()
-		if err != nil {
-			return err
-		}
+// openCmdLog opens a --cmd-stdout or --cmd-stderr log for an iteration, a log
+// shared by all iterations is appended to while one with {iter} in its name is
+// only for this iteration and is replaced
+func openCmdLog(path string, iter uint) (*os.File, error) {
+	if !strings.Contains(path, "{iter}") {
+		return files.EnsureExistsAndOpen(path, false)
+	}
+	path = strings.Replace(path, "{iter}", strconv.FormatUint(uint64(iter)+1, 10), -1)
+	return files.EnsureExistsAndOpen(path, true)
+}
 
-		// start running the command
-		start := time.Now()
-		err = cmd.Start()
+// compressedName adds .gz to path with --compress
+func (x *runOptions) compressedName(path string) string {
+	if x.Compress && !strings.HasSuffix(path, ".gz") {
+		return path + ".gz"
+	}
+	return path
+}
 
-		if x.NoWindowWait {
-			// if we aren't waiting on the window class, then just wait for the
-			// command to return
-			cmd.Wait()
-		} else {
-			// now wait until the window appears
-			wids, err = xtool.WaitForWindow(windowspec)
-			if err != nil {
-				logError(fmt.Errorf("waiting for window appearance: %w", err))
-				// if we don't get the wid properly then we can't try closing
-				tryXToolClose = false
-			}
-		}
+// slowestLogPath is where --keep-slowest-log keeps the log of the command
+// being run
+func (x *runOptions) slowestLogPath() string {
+	dir, name := filepath.Split(x.KeepSlowestLog)
+	return x.compressedName(filepath.Join(dir, x.logPrefix+name))
+}
 
-		// save the startup time
-		startup := time.Since(start)
+// rng is used for all randomized ordering decisions so that they can be
+// reproduced with --seed
+var rng *rand.Rand
 
-		// now get the pids before closing the window so we can gracefully try
-		// closing the windows before forcibly killing them later
-		if tryXToolClose {
-			pids := make([]int, len(wids))
-			for i, wid := range wids {
-				pid, err := xtool.PidForWindowID(wid)
-				if err != nil {
-					logError(fmt.Errorf("getting pid for wid %s: %w", wid, err))
-					tryWmctrl = true
-					break
-				}
-				pids[i] = pid
-			}
+// seedRand sets up rng from --seed, picking a seed from the current time if
+// none was specified, and returns the seed used
+func seedRand() int64 {
+	seed := currentCmd.Seed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	rng = rand.New(rand.NewSource(seed))
+	return seed
+}
 
-			// close the windows
-			for _, wid := range wids {
-				err = xtool.CloseWindowID(wid)
-				if err != nil {
-					logError(fmt.Errorf("closing window: %w", err))
-					tryWmctrl = true
-				}
-			}
+var errs []error
 
-			// kill the app pids in case x fails to close the window
-			for _, pid := range pids {
-				// FindProcess always succeeds on unix
-				proc, _ := os.FindProcess(pid)
-				if err := proc.Signal(os.Kill); err != nil {
-					// if the process already exited then try wmctrl
-					if !strings.Contains(err.Error(), "process already finished") {
-						logError(fmt.Errorf("killing window process pid %d: %w", pid, err))
-						tryWmctrl = true
-					}
-				}
-			}
-		}
+// errorList is written to JSON as the messages of the errors, so that results
+// can be read back in
+type errorList []error
 
-		if tryWmctrl {
-			err = wmctrlCloseWindow(x.WindowName)
-			if err != nil {
-				logError(fmt.Errorf("closing window with wmctrl: %w", err))
-			}
+func (l errorList) MarshalJSON() ([]byte, error) {
+	msgs := make([]string, len(l))
+	for i, err := range l {
+		msgs[i] = err.Error()
+	}
+	return json.Marshal(msgs)
+}
+
+func (l *errorList) UnmarshalJSON(data []byte) error {
+	var msgs []string
+	if err := json.Unmarshal(data, &msgs); err != nil {
+		return err
+	}
+	*l = make(errorList, len(msgs))
+	for i, msg := range msgs {
+		(*l)[i] = errors.New(msg)
+	}
+	return nil
+}
+
+func resetErrors() {
+	errs = nil
+}
+
+func logError(err error) {
+	errs = append(errs, err)
+	if currentCmd.ShowErrors {
+		log.Println(err)
+	}
+}
+
+// checkDependencies ensures the programs needed for the selected options are
+// installed before anything is run
+func (x *runOptions) checkDependencies() error {
+	if x.ReportDenials {
+		if _, err := exec.LookPath("journalctl"); err != nil {
+			return errors.New("cannot find journalctl to read the kernel log for --report-denials")
 		}
+	}
+	if x.SystemdUnit {
+		if _, err := exec.LookPath("systemctl"); err != nil {
+			return errors.New("cannot find systemctl, --systemd-unit needs systemd")
+		}
+	}
+	if x.Xvfb {
+		if _, err := exec.LookPath("Xvfb"); err != nil {
+			return errors.New("cannot find Xvfb, please install it (i.e. apt install xvfb) to use --xvfb")
+		}
+	}
+	// without waiting for a window nothing needs to interact with X
+	if x.NoWindowWait {
+		return nil
+	}
+	if _, err := exec.LookPath("xdotool"); err != nil {
+		return errors.New("cannot find xdotool, please install it (i.e. apt install xdotool) or use --no-window-wait")
+	}
+	if _, err := exec.LookPath("wmctrl"); err != nil {
+		log.Println("cannot find wmctrl, windows which fail to close with xdotool will be left open, please install it (i.e. apt install wmctrl)")
+	}
+	return nil
+}
 
-		if !x.NoTrace {
-			// ensure we close the fifo here so that the strace.TraceExecCommand()
-			// helper gets a EOF from the fifo (i.e. all writers must be closed
-			// for this)
-			fw.Close()
+func (x *cmdRun) Execute(args []string) error {
+	if x.CountOnly {
+		return x.countWindows(os.Stdout, x.Args.Cmd)
+	}
+	w, err := x.prepare()
+	if err != nil {
+		return err
+	}
+	outRes, err := x.run(w, x.Args.Cmd)
+	if err == nil && x.SQLite != "" {
+		err = x.appendSQLite(outRes)
+	}
+	if err == nil {
+		err = x.writeResult(w, outRes)
+	}
+	if err := x.finishOutput(err); err != nil {
+		return err
+	}
 
-			// wait for strace reader
-			<-doneCh
-			if straceErr == nil {
-				// make a new tabwriter to stderr
-				if !x.JSONOutput {
-					wtab := tabWriterGeneric(w)
-					slg.Display(wtab)
-				}
-			} else {
-				logError(fmt.Errorf("cannot extract runtime data: %w", straceErr))
-			}
+	if x.FailOnError {
+		return outRes.failure()
+	}
+	return nil
+}
+
+// writeJSON writes v as JSON on a single line, or indented with --json-indent
+func (x *runOptions) writeJSON(w io.Writer, v interface{}) error {
+	enc := json.NewEncoder(w)
+	if x.JSONIndent {
+		enc.SetIndent("", "  ")
+	}
+	return enc.Encode(v)
+}
+
+func (x *cmdRun) writeResult(w io.Writer, outRes *OutputResult) error {
+	switch {
+	case x.JSONOutput:
+		return x.writeJSON(w, outRes.sampled(x.SampleRuns))
+	case x.Markdown:
+		outRes.writeMarkdown(w)
+		return nil
+	case x.OpenMetrics:
+		writeOpenMetrics(w, []openMetricsResult{{name: strings.Join(outRes.Command, " "), res: outRes}})
+		return nil
+	default:
+		return x.writeReport(w, outRes)
+	}
+}
+
+// cmdStdin returns what to connect the command's stdin to, where nil is
+// /dev/null
+func (x *runOptions) cmdStdin() io.Reader {
+	if x.NoStdin || !isTerminal(os.Stdin) {
+		return nil
+	}
+	return os.Stdin
+}
+
+// plainOutput returns whether results are printed as plain text as they
+// happen rather than all at the end in another format
+func (x *runOptions) plainOutput() bool {
+	return !x.JSONOutput && !x.Markdown && !x.Raw && !x.OpenMetrics
+}
+
+// prepare validates the options and sets up everything shared between runs,
+// returning the writer to output results to
+func (x *runOptions) prepare() (io.Writer, error) {
+	if err := x.prepareOutput(); err != nil {
+		return nil, err
+	}
+	if err := x.prepareCommand(); err != nil {
+		return nil, err
+	}
+	if err := x.checkDependencies(); err != nil {
+		return nil, err
+	}
+	if err := x.prepareWindows(); err != nil {
+		return nil, err
+	}
+	if err := x.prepareTracing(); err != nil {
+		return nil, err
+	}
+	if err := x.prepareRemote(); err != nil {
+		return nil, err
+	}
+
+	// rather than failing once the first iteration is about to run
+	if !x.NoFlushCaches && x.Remote == "" {
+		if err := profiling.CheckFreeCaches(); err != nil {
+			return nil, fmt.Errorf("%w, use --no-flush-caches to run without dropping caches", err)
 		}
+	}
 
-		if x.RestoreScript != "" {
-			err := profiling.RunScript(x.RestoreScript, x.RestoreScriptArgs)
-			if err != nil {
-				logError(fmt.Errorf("running restore script: %w", err))
-			}
+	x.seed = seedRand()
+
+	// follow processes which daemonize
+	if x.Remote == "" {
+		if err := profiling.SetChildSubreaper(); err != nil {
+			log.Printf("cannot become child subreaper, daemonizing commands won't be detected: %v", err)
 		}
+	}
 
-		run := Execution{
-			ExecveTiming:  slg,
-			TimeToDisplay: startup,
-			Errors:        errs,
+	if x.Xvfb {
+		if err := x.startXvfb(); err != nil {
+			return nil, err
 		}
+	}
 
-		// if we're not tracing then just use startup time as time to run
-		if x.NoTrace {
-			run.TimeToRun = startup
+	// the output file only replaces any existing file once all the results
+	// are written, see finishOutput
+	if x.OutputFile != "" {
+		// TODO: add option for appending?
+		var file *files.AtomicFile
+		var err error
+		if x.Compress {
+			file, err = files.NewGzipAtomicFile(x.compressedName(x.OutputFile))
 		} else {
-			run.TimeToRun = slg.TotalTime
+			file, err = files.NewAtomicFile(x.OutputFile)
 		}
-
-		// add the run to our result
-		outRes.Runs = append(outRes.Runs, run)
-
-		if !x.JSONOutput {
-			fmt.Fprintln(w, "Total startup time:", startup)
+		if err != nil {
+			x.stopXvfb()
+			return nil, err
 		}
-
-		resetErrors()
+		x.output = file
+		return file, nil
 	}
+	return os.Stdout, nil
+}
 
-	if x.JSONOutput {
-		json.NewEncoder(w).Encode(outRes)
+// prepareOutput checks how the results are shown
+func (x *runOptions) prepareOutput() error {
+	if x.JSONIndent {
+		x.JSONOutput = true
+	}
+	if x.JSONOutput && x.Markdown {
+		return errors.New("cannot use --json and --markdown together")
+	}
+	if x.OpenMetrics && (x.JSONOutput || x.Markdown || x.Raw) {
+		return errors.New("cannot use --openmetrics with --json, --markdown or --raw")
+	}
+	if x.Pty {
+		switch {
+		case x.ProgramStderrLog != "":
+			return errors.New("cannot use --pty with --cmd-stderr, the command's stderr goes to its stdout")
+		case x.StraceDebug:
+			return errors.New("cannot use --pty with --strace-debug")
+		case x.Remote != "":
+			return errors.New("cannot use --pty with --remote")
+		}
+	}
+	if x.Raw {
+		switch {
+		case x.Report == "":
+			return errors.New("cannot use --raw without --report")
+		case x.JSONOutput || x.Markdown:
+			return errors.New("cannot use --raw with --json or --markdown")
+		}
+	}
+	if x.SampleRuns != 0 {
+		switch {
+		case x.SampleRuns < 0:
+			return errors.New("cannot sample a negative number of runs")
+		case !x.JSONOutput:
+			return errors.New("cannot use --sample-runs without --json")
+		}
 	}
+	if x.Columns != "" {
+		columns, err := strace.ParseColumns(x.Columns)
+		if err != nil {
+			return err
+		}
+		x.displayOpts.Columns = columns
+	}
+	if x.FilterPath != "" {
+		if _, err := filepath.Match(x.FilterPath, ""); err != nil {
+			return fmt.Errorf("invalid --filter-path %q: %w", x.FilterPath, err)
+		}
+	}
+	x.displayOpts.Syscalls = x.FilterSyscall
+	x.displayOpts.PathGlob = x.FilterPath
+	x.displayOpts.Precision = currentCmd.Precision
+	if x.Tree {
+		switch {
+		case x.Columns != "":
+			return errors.New("cannot use --tree with --columns")
+		case len(x.FilterSyscall) != 0 || x.FilterPath != "":
+			return errors.New("cannot use --tree with --filter-syscall or --filter-path")
+		}
+		x.displayOpts.Tree = true
+	}
+	if len(x.Tag) != 0 {
+		x.tags = make(map[string]string, len(x.Tag))
+		for _, tag := range x.Tag {
+			kv := strings.SplitN(tag, "=", 2)
+			if len(kv) != 2 || kv[0] == "" {
+				return fmt.Errorf("invalid tag %q, expected key=value", tag)
+			}
+			x.tags[kv[0]] = kv[1]
+		}
+	}
+	return nil
+}
 
+// prepareCommand checks how the command is run
+func (x *runOptions) prepareCommand() error {
+	if x.SystemdUser && !x.SystemdUnit {
+		return errors.New("cannot use --systemd-user without --systemd-unit")
+	}
+	if x.SystemdUnit {
+		switch {
+		case !x.NoTrace:
+			return errors.New("cannot trace a systemd unit, use --no-trace")
+		case x.Remote != "":
+			return errors.New("cannot use --systemd-unit with --remote")
+		case x.RunThroughSnap || x.RunThroughFlatpak:
+			return errors.New("cannot use --systemd-unit with --use-snap-run or --use-flatpak-run")
+		}
+		// units have no window to wait for
+		x.NoWindowWait = true
+	}
+	if len(x.SnapRunArgs) != 0 && !x.RunThroughSnap {
+		return errors.New("cannot use --snap-run-args without --use-snap-run")
+	}
+	if x.RunThroughSnap && x.RunThroughFlatpak {
+		return errors.New("cannot use --use-snap-run with --use-flatpak-run")
+	}
+	if x.Shell {
+		switch {
+		case x.RunThroughSnap || x.RunThroughFlatpak:
+			return errors.New("cannot use --shell with --use-snap-run or --use-flatpak-run")
+		case x.SystemdUnit:
+			return errors.New("cannot use --shell with --systemd-unit")
+		case x.Remote != "":
+			return errors.New("cannot use --shell with --remote, the remote shell already runs the command")
+		}
+	}
+	if x.WorkDir != "" {
+		if x.SystemdUnit {
+			return errors.New("cannot use --workdir with --systemd-unit, set WorkingDirectory in the unit instead")
+		}
+		if fi, err := os.Stat(x.WorkDir); err != nil {
+			return fmt.Errorf("cannot use --workdir: %w", err)
+		} else if !fi.IsDir() {
+			return fmt.Errorf("cannot use --workdir: %s is not a directory", x.WorkDir)
+		}
+	}
+	if x.MemoryLimit != "" {
+		if err := profiling.ValidateMemoryLimit(x.MemoryLimit); err != nil {
+			return fmt.Errorf("cannot use --memory-limit: %w", err)
+		}
+	}
+	if len(x.ClearJITCache) != 0 && x.Remote != "" {
+		return errors.New("cannot use --clear-jit-cache with --remote")
+	}
+	if len(x.RecordEnv) != 0 && x.Remote != "" {
+		return errors.New("cannot use --record-env with --remote")
+	}
+	for _, glob := range x.RecordEnv {
+		if _, err := filepath.Match(glob, ""); err != nil {
+			return fmt.Errorf("invalid --record-env glob %q: %w", glob, err)
+		}
+	}
+	for _, runtime := range x.ClearJITCache {
+		known := false
+		for _, r := range profiling.JITCacheRuntimes() {
+			known = known || r == runtime
+		}
+		if !known {
+			return fmt.Errorf("cannot clear the JIT cache of unknown runtime %q, expected one of %s", runtime, strings.Join(profiling.JITCacheRuntimes(), ", "))
+		}
+	}
+	if x.ReportDenials && x.Remote != "" {
+		return errors.New("cannot use --report-denials with --remote")
+	}
+	return nil
+}
+
+// prepareWindows checks how the command's startup is waited for and how
+// it's closed
+func (x *runOptions) prepareWindows() error {
+	if x.NoKill {
+		if x.CloseMode == "none" {
+			return errors.New("cannot use --no-kill with --close-mode=none")
+		}
+		x.CloseMode = "graceful"
+	}
+	if x.CloseMode == "none" {
+		switch {
+		case currentCmd.AdditionalIterations != 0:
+			// the window left open would be found by the next iteration
+			return errors.New("cannot use --close-mode=none with --additional-iterations")
+		case x.StraceFifo != "":
+			return errors.New("cannot use --close-mode=none with --strace-fifo, strace keeps writing to it after the run")
+		}
+	}
+	if x.DesktopFile != "" && x.WindowClass != "" {
+		return errors.New("cannot use --desktop-file with --class-name")
+	}
+	if x.LiteralClass && x.WindowClass == "" && x.DesktopFile == "" && x.WindowName != "" {
+		return errors.New("cannot use --literal-class with --window-name")
+	}
+	if x.WaitForDBusName != "" {
+		if x.Remote != "" {
+			return errors.New("cannot use --wait-for-dbus-name with --remote")
+		}
+		if _, err := exec.LookPath("dbus-send"); err != nil {
+			return errors.New("cannot find dbus-send, please install it (i.e. apt install dbus) to use --wait-for-dbus-name")
+		}
+	}
+	if x.StartOutputMarker != "" && x.WaitForOutput == "" {
+		return errors.New("cannot use --start-output-marker without --wait-for-output")
+	}
+	if x.WaitForOutput != "" {
+		switch {
+		case !x.NoWindowWait:
+			return errors.New("cannot use --wait-for-output without --no-window-wait")
+		case x.Remote != "":
+			return errors.New("cannot use --wait-for-output with --remote")
+		}
+		var err error
+		if x.readyMarker, err = regexp.Compile(x.WaitForOutput); err != nil {
+			return fmt.Errorf("invalid --wait-for-output regex: %w", err)
+		}
+		if x.StartOutputMarker != "" {
+			if x.startMarker, err = regexp.Compile(x.StartOutputMarker); err != nil {
+				return fmt.Errorf("invalid --start-output-marker regex: %w", err)
+			}
+		}
+	}
+	if x.WaitForFile != "" {
+		switch {
+		case !x.NoWindowWait:
+			return errors.New("cannot use --wait-for-file without --no-window-wait")
+		case x.Remote != "":
+			return errors.New("cannot use --wait-for-file with --remote")
+		case x.WaitForOutput != "":
+			return errors.New("cannot use --wait-for-file with --wait-for-output")
+		}
+	}
+	if x.ReplayEvents != "" {
+		if x.NoWindowWait {
+			return errors.New("cannot use --replay-events with --no-window-wait")
+		}
+		f, err := os.Open(x.ReplayEvents)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		if x.events, err = xdotool.ParseEvents(f); err != nil {
+			return fmt.Errorf("cannot read events from %s: %w", x.ReplayEvents, err)
+		}
+	}
+	if x.LaunchEvents != "" {
+		switch {
+		case x.NoWindowWait:
+			return errors.New("cannot use --launch-events with --no-window-wait")
+		case x.Remote != "":
+			return errors.New("cannot use --launch-events with --remote")
+		}
+		f, err := os.Open(x.LaunchEvents)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		if x.launchEvents, err = xdotool.ParseEvents(f); err != nil {
+			return fmt.Errorf("cannot read events from %s: %w", x.LaunchEvents, err)
+		}
+		for _, e := range x.launchEvents {
+			if strings.Contains(strings.Join(e.Command, " "), "{window}") {
+				return fmt.Errorf("cannot use {window} in %s, no window has appeared yet when launching", x.LaunchEvents)
+			}
+		}
+	}
+	if x.MaxStartup != 0 && x.NoWindowWait {
+		return errors.New("cannot use --max-startup with --no-window-wait")
+	}
+	if x.MmapFaultAnalysis && x.NoWindowWait {
+		return errors.New("cannot use --mmap-fault-analysis with --no-window-wait, the command has exited by the end of the run")
+	}
+	if x.MinStartup != 0 {
+		switch {
+		case x.NoWindowWait:
+			return errors.New("cannot use --min-startup with --no-window-wait")
+		case x.MaxStartup != 0 && x.MinStartup >= x.MaxStartup:
+			return errors.New("--min-startup must be less than --max-startup")
+		}
+	}
+	if x.WaitForActive && x.NoWindowWait {
+		return errors.New("cannot use --wait-for-active with --no-window-wait")
+	}
+	if x.KeepRunning {
+		switch {
+		case x.NoWindowWait:
+			return errors.New("cannot use --keep-running with --no-window-wait")
+		case x.CloseMode == "none":
+			return errors.New("cannot use --keep-running with --close-mode=none")
+		}
+	}
+	return nil
+}
+
+// prepareTracing checks how the command is traced, creating the
+// --strace-log-dir
+func (x *runOptions) prepareTracing() error {
+	if x.TracerCmd != "" {
+		switch {
+		case x.NoTrace:
+			return errors.New("cannot use --tracer-cmd with --no-trace")
+		case x.Remote != "":
+			return errors.New("cannot use --tracer-cmd with --remote")
+		case !strings.Contains(x.TracerCmd, "{cmd}"):
+			return errors.New("--tracer-cmd must contain {cmd}")
+		}
+		// the summary is parsed the same however it was made
+		if x.TracerFormat == "strace-summary" {
+			x.StraceSummary = true
+		}
+	}
+	if x.Futexes && x.NoTrace {
+		return errors.New("cannot use --futexes with --no-trace")
+	}
+	if x.Threads && x.NoTrace {
+		return errors.New("cannot use --threads with --no-trace")
+	}
+	if x.FailedOpens && x.NoTrace {
+		return errors.New("cannot use --failed-opens with --no-trace")
+	}
+	if x.FirstDraw && x.NoTrace {
+		return errors.New("cannot use --first-draw with --no-trace")
+	}
+	if x.NetworkActivity && x.NoTrace {
+		return errors.New("cannot use --network-activity with --no-trace")
+	}
+	if x.StraceBufferSize < 0 {
+		return errors.New("cannot use a negative --strace-buffer-size")
+	}
+	if x.StraceStringLimit != 0 {
+		switch {
+		case x.StraceStringLimit < 0:
+			return errors.New("cannot use a negative --strace-string-limit")
+		case x.NoTrace:
+			return errors.New("cannot use --strace-string-limit with --no-trace")
+		case x.TracerCmd != "":
+			return errors.New("cannot use --strace-string-limit with --tracer-cmd, add -s to the tracer command instead")
+		}
+	}
+	if x.MinSyscallTime != 0 {
+		switch {
+		case x.MinSyscallTime < 0:
+			return errors.New("cannot use a negative --min-syscall-duration")
+		case x.NoTrace:
+			return errors.New("cannot use --min-syscall-duration with --no-trace")
+		case x.TracerCmd != "":
+			return errors.New("cannot use --min-syscall-duration with --tracer-cmd")
+		}
+	}
+	if x.StraceSummary {
+		switch {
+		case x.NoTrace:
+			return errors.New("cannot use --strace-summary with --no-trace")
+		case x.FailedOpens:
+			return errors.New("cannot use --strace-summary with --failed-opens")
+		case x.Futexes:
+			return errors.New("cannot use --strace-summary with --futexes, the summary already has the time spent in futex")
+		case x.Threads:
+			return errors.New("cannot use --strace-summary with --threads")
+		case x.FirstDraw:
+			return errors.New("cannot use --strace-summary with --first-draw")
+		case x.NetworkActivity:
+			return errors.New("cannot use --strace-summary with --network-activity")
+		case x.StraceStringLimit != 0:
+			return errors.New("cannot use --strace-summary with --strace-string-limit, the summary has no arguments")
+		case x.MinSyscallTime != 0:
+			return errors.New("cannot use --strace-summary with --min-syscall-duration, the summary already has the time spent in every syscall")
+		case x.StraceLogDir != "":
+			return errors.New("cannot use --strace-summary with --strace-log-dir")
+		case x.KeepSlowestLog != "":
+			return errors.New("cannot use --strace-summary with --keep-slowest-log")
+		case x.Remote != "":
+			return errors.New("cannot use --strace-summary with --remote")
+		}
+	}
+	if x.EvictTargetOnly {
+		switch {
+		case x.NoFlushCaches:
+			return errors.New("cannot use --evict-target-only with --no-flush-caches")
+		case x.NoTrace:
+			return errors.New("cannot use --evict-target-only with --no-trace")
+		case x.StraceSummary:
+			return errors.New("cannot use --evict-target-only with --strace-summary")
+		case x.Remote != "":
+			return errors.New("cannot use --evict-target-only with --remote")
+		}
+	}
+	if x.MeasureOverhead {
+		switch {
+		case x.NoTrace:
+			return errors.New("cannot use --measure-overhead with --no-trace")
+		case x.EvictTargetOnly:
+			return errors.New("cannot use --measure-overhead with --evict-target-only")
+		case x.CloseMode == "none":
+			// the untraced run would find the window left open
+			return errors.New("cannot use --measure-overhead with --close-mode=none")
+		}
+	}
+	if x.StraceFifo != "" {
+		switch {
+		case x.NoTrace:
+			return errors.New("cannot use --strace-fifo with --no-trace")
+		case x.StraceSummary:
+			return errors.New("cannot use --strace-fifo with --strace-summary")
+		case x.Remote != "":
+			return errors.New("cannot use --strace-fifo with --remote")
+		}
+		fi, err := os.Stat(x.StraceFifo)
+		if err != nil {
+			return err
+		}
+		if fi.Mode()&os.ModeNamedPipe == 0 {
+			return fmt.Errorf("cannot use %s with --strace-fifo: not a named pipe", x.StraceFifo)
+		}
+	}
+	if x.StraceDebug && x.NoTrace {
+		return errors.New("cannot use --strace-debug with --no-trace")
+	}
+	if x.PerfCounters {
+		switch {
+		case !x.NoTrace:
+			return errors.New("cannot use --perf-counters without --no-trace, as perf would count strace too")
+		case x.Remote != "":
+			return errors.New("cannot use --perf-counters with --remote")
+		}
+	}
+	if x.KeepSlowestLog != "" && x.NoTrace {
+		return errors.New("cannot use --keep-slowest-log with --no-trace")
+	}
+	if x.StraceLogDir != "" {
+		if x.NoTrace {
+			return errors.New("cannot use --strace-log-dir with --no-trace")
+		}
+		if err := os.MkdirAll(x.StraceLogDir, 0755); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// prepareRemote checks what can be used with --remote, getting the user
+// the command is run as there
+func (x *runOptions) prepareRemote() error {
+	if x.Remote != "" {
+		if !x.NoWindowWait {
+			return errors.New("cannot use --remote without --no-window-wait")
+		}
+		if x.DiscardSnapNs {
+			return errors.New("cannot use --discard-snap-ns with --remote")
+		}
+		if x.KillFlatpak {
+			return errors.New("cannot use --kill-flatpak-instance with --remote")
+		}
+		if x.FreshHome {
+			return errors.New("cannot use --fresh-home with --remote")
+		}
+		if x.WorkDir != "" {
+			return errors.New("cannot use --workdir with --remote")
+		}
+		if x.Display != "" {
+			return errors.New("cannot use --display with --remote")
+		}
+		if x.Xvfb {
+			return errors.New("cannot use --xvfb with --remote")
+		}
+		if x.MemoryLimit != "" {
+			return errors.New("cannot use --memory-limit with --remote")
+		}
+		var err error
+		x.remoteUser, err = remote.User(x.Remote)
+		if err != nil {
+			return fmt.Errorf("cannot get user on remote host: %w", err)
+		}
+	}
+	return nil
+}
+
+// finishOutput moves the output file into place if err is nil, otherwise the
+// partial output is discarded
+func (x *runOptions) finishOutput(err error) error {
+	x.stopXvfb()
+	if x.output == nil {
+		return err
+	}
+	if err != nil {
+		x.output.Cancel()
+		return err
+	}
+	return x.output.Commit()
+}
+
+// startXvfb starts the Xvfb server for --xvfb, which the command is then run
+// and its windows looked for on
+func (x *runOptions) startXvfb() error {
+	if x.Display != "" {
+		return errors.New("cannot use --xvfb with --display")
+	}
+	server, err := xvfb.Start(x.XvfbScreen)
+	if err != nil {
+		return err
+	}
+	x.xserver = server
+	x.Display = server.Display
+	return nil
+}
+
+// stopXvfb stops the server started with --xvfb, if any
+func (x *runOptions) stopXvfb() {
+	if x.xserver != nil {
+		x.xserver.Stop()
+		x.xserver = nil
+	}
+}
+
+// windowSpec returns the windows to wait for when running cmdArgs, from the
+// options or falling back to the command's name
+func (x *runOptions) windowSpec(cmdArgs []string) (xdotool.Window, error) {
+	windowClass := x.WindowClass
+	if x.DesktopFile != "" {
+		class, err := desktopWindowClass(x.DesktopFile, cmdArgs[0])
+		if err != nil {
+			return xdotool.Window{}, err
+		}
+		windowClass = class
+	}
+
+	var windowspec xdotool.Window
+	// check which opts are defined
+	if windowClass != "" {
+		// prefer window class from option, where a window with any of the
+		// comma separated classes counts
+		var candidates []xdotool.Window
+		for _, class := range strings.Split(windowClass, ",") {
+			candidates = append(candidates, xdotool.Window{Class: class, LiteralClass: x.LiteralClass})
+		}
+		windowspec = xdotool.AnyOf(candidates...)
+	} else if x.WindowName != "" {
+		// then window name
+		var candidates []xdotool.Window
+		for _, name := range strings.Split(x.WindowName, ",") {
+			candidates = append(candidates, xdotool.Window{Name: name})
+		}
+		windowspec = xdotool.AnyOf(candidates...)
+	} else {
+		windowspec.LiteralClass = x.LiteralClass
+		// finally fall back to base cmd as the class
+		// note we use the original command and note the processed targetCmd
+		// because for example when measuring a snap, we invoke etrace like so:
+		// $ ./etrace run --use-snap chromium
+		// where targetCmd becomes []string{"snap","run","chromium"}
+		// but we still want to use "chromium" as the windowspec class
+		windowspec.Class = filepath.Base(cmdArgs[0])
+		if x.Shell {
+			// the whole command may be in the first argument
+			windowspec.Class = filepath.Base(strings.Fields(strings.Join(cmdArgs, " "))[0])
+		}
+	}
+	return windowspec, nil
+}
+
+// targetCmd returns the command to actually run for cmdArgs, through sh -c,
+// snap run or flatpak run
+func (x *runOptions) targetCmd(cmdArgs []string) []string {
+	targetCmd := cmdArgs
+	if x.Shell {
+		targetCmd = []string{"sh", "-c", strings.Join(cmdArgs, " ")}
+	}
+	// handle if the command should be run through `snap run`
+	if x.RunThroughSnap {
+		snapRun := append([]string{"snap", "run"}, x.SnapRunArgs...)
+		targetCmd = append(snapRun, targetCmd...)
+	}
+	// or through `flatpak run`
+	if x.RunThroughFlatpak {
+		targetCmd = append([]string{"flatpak", "run"}, targetCmd...)
+	}
+	return targetCmd
+}
+
+// run runs the given command for all iterations
+func (x *runOptions) run(w io.Writer, cmdArgs []string) (*OutputResult, error) {
+	outRes := &OutputResult{
+		Etrace:              currentBuildInfo(),
+		Command:             cmdArgs,
+		Seed:                x.seed,
+		InterIterationDelay: x.IterationDelay,
+		Tags:                x.tags,
+	}
+	if x.Remote == "" {
+		var err error
+		if outRes.System, err = profiling.ReadSystemInfo(); err != nil {
+			log.Printf("warning: cannot read system info: %v", err)
+		}
+	}
+	if x.Shell && strings.TrimSpace(strings.Join(cmdArgs, " ")) == "" {
+		return nil, errors.New("cannot run an empty command with --shell")
+	}
+	if x.SystemdUnit {
+		return x.runUnit(w, outRes, systemd.Unit{Name: cmdArgs[0], User: x.SystemdUser})
+	}
+	if !x.NoTrace && x.TracerCmd == "" {
+		var version string
+		var err error
+		if x.Remote != "" {
+			version, err = remote.Output(x.Remote, "strace", "-V")
+			version = strings.SplitN(version, "\n", 2)[0]
+		} else {
+			version, err = strace.Version()
+		}
+		if err != nil {
+			return nil, err
+		}
+		outRes.StraceVersion = version
+	}
+
+	windowspec, err := x.windowSpec(cmdArgs)
+	if err != nil {
+		return nil, err
+	}
+
+	// pick how to close windows from what the window manager supports, as
+	// wmctrl can't close anything without one
+	wmctrlCloses := false
+	if !x.NoWindowWait {
+		wm := xdotool.MakeXDoToolForDisplay(x.Display).WindowManager()
+		outRes.WindowManager = wm.Name
+		_, err := exec.LookPath("wmctrl")
+		wmctrlCloses = wm.EWMH && err == nil
+	}
+
+	warnedDaemonized := false
+	// files the command used in previous iterations, for --evict-target-only
+	targetFiles := make(map[string]bool)
+	var slowestStartup time.Duration
+	i := uint(0)
+	iterations := 1 + currentCmd.AdditionalIterations
+	if x.untracedPass {
+		iterations = 1
+	}
+	for i = 0; i < iterations; i++ {
+		// let the system settle from the previous iteration
+		if i > 0 && x.IterationDelay > 0 {
+			time.Sleep(x.IterationDelay)
+		}
+
+		// run the prepare script if it's available
+		annotations := make(map[string]interface{})
+		if x.PrepareScript != "" {
+			x.runScript("prepare", x.PrepareScript, x.PrepareScriptArgs, i, iterations, annotations)
+		}
+
+		for _, runtime := range x.ClearJITCache {
+			if _, err := profiling.ClearJITCache(runtime); err != nil {
+				logError(err)
+			}
+		}
+
+		targetCmd := x.targetCmd(cmdArgs)
+		snapInfo, flatpakInfo := x.packageInfo(cmdArgs)
+
+		traceOpts := x.traceOptions()
+		var freshHome string
+		if x.FreshHome {
+			// every iteration gets a brand new $HOME, as if this were the first
+			// time the command was run
+			var err error
+			freshHome, err = ioutil.TempDir("", "etrace-home")
+			if err != nil {
+				return nil, err
+			}
+			traceOpts.Env = append(traceOpts.Env, "HOME="+freshHome)
+		}
+
+		// with --keep-slowest-log every log is written out until it's known
+		// whether this iteration is the slowest yet
+		candidateLog, err := x.slowestLogCandidate()
+		if err != nil {
+			return nil, err
+		}
+		var keep io.Writer
+		if candidateLog != nil {
+			keep = candidateLog
+		}
+
+		cmd, trace, err := x.tracedCommand(targetCmd, i, traceOpts, keep)
+		if err != nil {
+			return nil, err
+		}
+
+		// the command is the same across iterations except for temporary paths,
+		// so just record the first one
+		if outRes.TraceCommand == nil {
+			outRes.TraceCommand = commandArgv(cmd)
+		}
+		cmd.Dir = x.WorkDir
+
+		// the trace and streams are cleaned up at the end of every iteration
+		// rather than deferred, so they don't pile up over many iterations
+		streams, err := x.connectStreams(cmd, i)
+		if err != nil {
+			trace.cleanup()
+			return nil, err
+		}
+		cleanup := func() {
+			streams.close()
+			trace.cleanup()
+		}
+
+		if err := x.resetPackage(cmdArgs); err != nil {
+			cleanup()
+			return nil, err
+		}
+
+		xtool := xdotool.MakeXDoToolForDisplay(x.Display)
+
+		if err := x.freeCaches(targetFiles); err != nil {
+			cleanup()
+			return nil, err
+		}
+
+		memCgroup, err := x.memoryCgroup(cmd, i)
+		if err != nil {
+			cleanup()
+			return nil, err
+		}
+
+		var cpuBefore *profiling.SystemCPUTimes
+		if x.SystemBusyness {
+			cpuBefore = systemCPUTimes()
+		}
+		// a file left over from a previous run only counts once it's replaced
+		var fileWatch *files.FileWatch
+		if x.WaitForFile != "" {
+			fileWatch = files.WatchFile(x.WaitForFile)
+		}
+
+		// start running the command
+		proc, err := x.startCommand(cmd, memCgroup, streams)
+
+		// with --launch-events the command is only the launcher, so the app
+		// starts up once it's been launched from it
+		start := proc.start
+		if len(x.launchEvents) != 0 && err == nil {
+			if err := xtool.ReplayEvents("", x.launchEvents); err != nil {
+				logError(fmt.Errorf("sending launch events: %w", err))
+			}
+			start = time.Now()
+		}
+
+		// the name is looked for alongside waiting for the window
+		dbusName := x.watchDBusName(proc.exited)
+
+		sw := x.waitForStartup(xtool, windowspec, proc, streams.markers, fileWatch, start)
+		if len(sw.daemons) != 0 && !warnedDaemonized {
+			log.Printf("warning: %s daemonized, following its background processes %v instead", cmdArgs[0], sw.daemons)
+			warnedDaemonized = true
+		}
+
+		// save the startup time, which is when the first window appeared when
+		// watching all the windows
+		startup := time.Since(start)
+		if len(sw.windows) != 0 {
+			startup = sw.windows[0].TimeToDisplay
+		} else {
+			for _, wid := range sw.wids {
+				sw.windows = append(sw.windows, WindowResult{ID: wid, TimeToDisplay: startup})
+			}
+		}
+
+		var markerTimes *OutputMarkerTimes
+		if streams.markers != nil {
+			markerTimes, err = streams.markers.times(start)
+			if err != nil {
+				logError(err)
+			} else {
+				startup = markerTimes.Delta
+			}
+		}
+		if sw.timeToFile != 0 {
+			startup = sw.timeToFile
+		}
+
+		// a window which appears faster than any real startup was most likely
+		// already there before the command was run
+		underMinStartup := x.MinStartup != 0 && !sw.overMaxStartup && len(sw.wids) != 0 && startup < x.MinStartup
+		if underMinStartup {
+			log.Printf("warning: a window appeared after only %v in iteration %d, below the minimum startup time of %v, it was likely already open", startup, i+1, x.MinStartup)
+		}
+
+		if sw.overMaxStartup {
+			log.Printf("warning: no window appeared within the maximum startup time of %v in iteration %d, aborting it", x.MaxStartup, i+1)
+			x.abortCommand(cmd, proc.exited)
+		}
+
+		var timeToDBusName time.Duration
+		if dbusName != nil {
+			timeToDBusName = dbusName.wait(start)
+		}
+
+		timeToActive, timeToIdle := x.useWindow(xtool, sw, start)
+
+		if x.KeepRunning {
+			fmt.Fprintf(os.Stderr, "Startup took %v, leaving the command running until interrupted with Ctrl-C\n", startup)
+			if waitForInterrupt(proc.exited) {
+				// the command exited on its own or from the interrupt so
+				// there is nothing left to close
+				sw.closeWindows = false
+			}
+		}
+
+		if x.CloseMode == "none" && !x.NoWindowWait && !sw.overMaxStartup {
+			// leave the command running for inspection, strace is left
+			// tracing it as it would kill the command if stopped
+			waitForKeypress(x.InspectTimeout)
+			if x.PerfCounters {
+				// perf stops counting and writes the counts when interrupted
+				if err := cmd.Process.Signal(os.Interrupt); err != nil {
+					logError(fmt.Errorf("stopping perf: %w", err))
+				}
+			}
+			sw.closeWindows = false
+		}
+
+		// the command's CPU time is only known until its windows are closed
+		var cpuAfter *profiling.SystemCPUTimes
+		if cpuBefore != nil {
+			cpuAfter = systemCPUTimes()
+		}
+
+		usage := x.closeWindows(xtool, sw, proc, wmctrlCloses)
+
+		traced := x.readTrace(w, trace, proc)
+		if traced.timing != nil {
+			for _, f := range traced.timing.MappedFiles {
+				targetFiles[f] = true
+			}
+		}
+
+		var denials []snaps.Denial
+		if x.ReportDenials {
+			denials = x.denials(proc, sw.daemons, traced.timing, cmdArgs)
+		}
+
+		var memory *profiling.MemoryEvents
+		if memCgroup != nil {
+			memory = memoryEvents(memCgroup, cmdArgs[0], i)
+		}
+
+		if x.RestoreScript != "" {
+			x.runScript("restore", x.RestoreScript, x.RestoreScriptArgs, i, iterations, annotations)
+		}
+
+		if freshHome != "" {
+			if err := os.RemoveAll(freshHome); err != nil {
+				logError(fmt.Errorf("removing fresh home directory: %w", err))
+			}
+		}
+
+		output := streams.finish(x.CaptureOutput)
+		cleanup()
+
+		exitCode := -1
+		select {
+		case <-proc.exited:
+			// the state is missing if the command failed to start
+			if cmd.ProcessState != nil {
+				exitCode = cmd.ProcessState.ExitCode()
+				if ru, ok := cmd.ProcessState.SysUsage().(*syscall.Rusage); ok && usage.voluntary == 0 && usage.involuntary == 0 {
+					usage.voluntary, usage.involuntary = ru.Nvcsw, ru.Nivcsw
+				}
+				if usage.cpuTime == 0 {
+					usage.cpuTime = cmd.ProcessState.UserTime() + cmd.ProcessState.SystemTime()
+				}
+			}
+		default:
+		}
+
+		run := Execution{
+			ExecveTiming:   traced.timing,
+			SyscallSummary: traced.summary,
+			Memory:         memory,
+			PerfCounters:   traced.counters,
+			TimeToDisplay:  startup,
+			TimeToIdle:     timeToIdle,
+			TimeToActive:   timeToActive,
+			Windows:        sw.windows,
+			SnapInfo:       snapInfo,
+			FlatpakInfo:    flatpakInfo,
+			Daemonized:     len(sw.daemons) != 0,
+			ClockSkewed:    traced.clockSkewed,
+			ExitCode:       exitCode,
+			Output:         output,
+			Tags:           x.tags,
+			Errors:         errs,
+		}
+		run.VoluntaryCtxSwitches, run.InvoluntaryCtxSwitches = usage.voluntary, usage.involuntary
+		run.TimeToRunCPU = usage.cpuTime
+		run.OverMaxStartup = sw.overMaxStartup
+		run.UnderMinStartup = underMinStartup
+		run.TimeToDBusName = timeToDBusName
+		run.TimeToFile = sw.timeToFile
+		run.MappedFiles = usage.mappedFiles
+		run.Denials = denials
+		if len(x.launchEvents) != 0 {
+			run.TimeToLaunch = start.Sub(proc.start)
+		}
+		run.OutputMarkers = markerTimes
+		if cpuAfter != nil {
+			// the strace overhead counts as the rest of the system unless the
+			// command exited by itself
+			run.SystemBusyness = profiling.Busyness(cpuBefore, cpuAfter, usage.cpuTime)
+		}
+		if len(annotations) != 0 {
+			run.Annotations = annotations
+		}
+		if len(x.RecordEnv) != 0 {
+			// the command gets our environment along with what's set for it
+			run.Env = recordedEnv(append(os.Environ(), traceOpts.Env...), x.RecordEnv)
+		}
+
+		// if we're not tracing execs, or the trace couldn't be read, then just
+		// use startup time as time to run
+		if traced.timing != nil {
+			run.TimeToRun = traced.timing.TotalTime
+		} else {
+			run.TimeToRun = startup
+		}
+
+		if candidateLog != nil {
+			if i == 0 || startup > slowestStartup {
+				if err := candidateLog.Commit(); err != nil {
+					logError(fmt.Errorf("cannot save slowest strace log: %w", err))
+				} else {
+					slowestStartup = startup
+					outRes.SlowestLogIteration = int(i) + 1
+				}
+			} else {
+				candidateLog.Cancel()
+			}
+		}
+
+		if x.MeasureOverhead {
+			overhead, untracedErrs, err := x.measureOverhead(cmdArgs, startup, i, iterations)
+			if err != nil {
+				return nil, err
+			}
+			run.TracingOverhead = overhead
+			run.Errors = append(run.Errors, untracedErrs...)
+		}
+
+		// add the run to our result
+		outRes.Runs = append(outRes.Runs, run)
+
+		if x.plainOutput() {
+			x.displayRun(w, &run)
+		}
+
+		resetErrors()
+	}
+
+	if x.plainOutput() {
+		x.displayRunTotals(w, outRes)
+	}
+
+	return outRes, nil
+}
+
+// runningCommand is a started command which is reaped in the background so
+// that its exit can be waited on without blocking
+type runningCommand struct {
+	cmd *exec.Cmd
+	// start is when the command was started
+	start time.Time
+	// exited is closed once the command has exited, at exitedAt
+	exited   chan struct{}
+	exitedAt time.Time
+}
+
+// startCommand starts the command in its memory cgroup if there is one. The
+// command is still waited on if it fails to start, so that the run is
+// recorded without any results.
+func (x *runOptions) startCommand(cmd *exec.Cmd, memCgroup *profiling.MemoryCgroup, streams *commandStreams) (*runningCommand, error) {
+	proc := &runningCommand{
+		cmd:    cmd,
+		start:  time.Now(),
+		exited: make(chan struct{}),
+	}
+	err := cmd.Start()
+	if memCgroup != nil && err == nil {
+		if err := memCgroup.Start(cmd.Process.Pid); err != nil {
+			logError(fmt.Errorf("moving command into memory cgroup: %w", err))
+		}
+		// don't count the time spent setting up the cgroup
+		proc.start = time.Now()
+	}
+	streams.started()
+
+	go func() {
+		cmd.Wait()
+		proc.exitedAt = time.Now()
+		close(proc.exited)
+	}()
+	return proc, err
+}
+
+// slowestLogCandidate is where the strace log is written with
+// --keep-slowest-log until it's known whether this iteration is the slowest
+// yet, nil without it
+func (x *runOptions) slowestLogCandidate() (*files.AtomicFile, error) {
+	if x.KeepSlowestLog == "" {
+		return nil, nil
+	}
+	if x.Compress {
+		return files.NewGzipAtomicFile(x.slowestLogPath())
+	}
+	return files.NewAtomicFile(x.slowestLogPath())
+}
+
+// traceOptions are the extra options for how the command is traced and run
+func (x *runOptions) traceOptions() strace.TraceOptions {
+	traceOpts := strace.TraceOptions{
+		FailedOpens: x.FailedOpens,
+		MappedFiles: x.EvictTargetOnly,
+		Futexes:     x.Futexes,
+		FirstDraw:   x.FirstDraw,
+		Network:     x.NetworkActivity,
+		MaxEvents:   x.MaxEvents,
+		StringLimit: x.StraceStringLimit,
+	}
+	traceOpts.MinSyscallDuration = x.MinSyscallTime
+	// the tree and the denials need to know every process the command
+	// created, not just the ones which exec'd
+	traceOpts.Processes = x.Threads || x.Tree || x.ReportDenials
+	if x.Display != "" {
+		traceOpts.Env = append(traceOpts.Env, "DISPLAY="+x.Display)
+	}
+	return traceOpts
+}
+
+// packageInfo records what snap or flatpak is being measured, the prepare
+// script may have just installed a different revision
+func (x *runOptions) packageInfo(cmdArgs []string) (*snaps.Info, *flatpak.Info) {
+	var snapInfo *snaps.Info
+	var flatpakInfo *flatpak.Info
+	if x.RunThroughFlatpak && x.Remote == "" {
+		var err error
+		flatpakInfo, err = flatpak.AppInfo(cmdArgs[0])
+		if err != nil {
+			logError(fmt.Errorf("getting flatpak info: %w", err))
+		}
+	}
+	// TODO: get the snap info from the remote host too
+	if x.RunThroughSnap && x.Remote == "" {
+		var err error
+		snapInfo, err = snaps.SnapInfo(cmdArgs[0])
+		if err != nil {
+			logError(fmt.Errorf("getting snap info: %w", err))
+		}
+	}
+	return snapInfo, flatpakInfo
+}
+
+// resetPackage discards the snap's mount namespace or kills the running
+// flatpak instances, so that the command starts from scratch
+func (x *runOptions) resetPackage(cmdArgs []string) error {
+	if x.DiscardSnapNs {
+		if !x.RunThroughSnap {
+			return errors.New("cannot use --discard-snap-ns without --use-snap-run")
+		}
+		// the name of the snap in this case is the first argument
+		if err := snaps.DiscardSnapNs(cmdArgs[0]); err != nil {
+			return err
+		}
+	}
+	if x.KillFlatpak {
+		if !x.RunThroughFlatpak {
+			return errors.New("cannot use --kill-flatpak-instance without --use-flatpak-run")
+		}
+		// the app ID in this case is the first argument
+		if err := flatpak.KillInstances(cmdArgs[0]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// displayRun shows the results of a single run with the plain output
+func (x *runOptions) displayRun(w io.Writer, run *Execution) {
+	startup := run.TimeToDisplay
+	if run.OverMaxStartup {
+		fmt.Fprintf(w, "Startup exceeded the maximum of %v, aborted\n", x.MaxStartup)
+	} else if run.UnderMinStartup {
+		fmt.Fprintf(w, "Startup of %v is below the minimum of %v, ignored\n", rounded(startup), x.MinStartup)
+	} else {
+		fmt.Fprintln(w, "Total startup time:", rounded(startup))
+	}
+	if run.SystemBusyness != nil {
+		fmt.Fprintf(w, "System busyness: %.1f%% busy, %.1f%% waiting for I/O, %v used by other processes\n", run.SystemBusyness.Busy, run.SystemBusyness.IOWait, rounded(run.SystemBusyness.OtherCPUTime))
+	}
+	if run.OutputMarkers != nil && x.startMarker != nil {
+		fmt.Fprintf(w, "Output markers printed after: %v (start), %v (ready)\n", rounded(run.OutputMarkers.Start), rounded(run.OutputMarkers.Ready))
+	}
+	if x.WaitForActive {
+		fmt.Fprintln(w, "Time to active window:", rounded(run.TimeToActive))
+	}
+	if x.WaitForDBusName != "" {
+		fmt.Fprintf(w, "Time to acquire %s: %v\n", x.WaitForDBusName, rounded(run.TimeToDBusName))
+	}
+	if x.WaitForFile != "" {
+		fmt.Fprintf(w, "Time to create %s: %v\n", x.WaitForFile, rounded(run.TimeToFile))
+	}
+	if x.MeasureOverhead {
+		fmt.Fprintln(w, "Tracing overhead:", rounded(run.TracingOverhead))
+	}
+	if len(run.MappedFiles) != 0 {
+		displayMappedFiles(w, run.MappedFiles)
+	}
+	if x.ReportDenials {
+		displayDenials(w, run.Denials)
+	}
+}
+
+// displayRunTotals shows what's left once all the runs are done with the
+// plain output
+func (x *runOptions) displayRunTotals(w io.Writer, outRes *OutputResult) {
+	if x.MaxStartup != 0 {
+		over := 0
+		for _, run := range outRes.Runs {
+			if run.OverMaxStartup {
+				over++
+			}
+		}
+		if over != 0 {
+			fmt.Fprintf(w, "%d of %d iterations exceeded the maximum startup time of %v\n", over, len(outRes.Runs), x.MaxStartup)
+		}
+	}
+	if x.MinStartup != 0 {
+		under := 0
+		for _, run := range outRes.Runs {
+			if run.UnderMinStartup {
+				under++
+			}
+		}
+		if under != 0 {
+			fmt.Fprintf(w, "%d of %d iterations were below the minimum startup time of %v\n", under, len(outRes.Runs), x.MinStartup)
+		}
+	}
+	if x.IterationTable {
+		outRes.writeIterationTable(w)
+	}
+	if x.Histogram {
+		outRes.writeHistogram(w)
+	}
+}
+
+// untracedOptions returns the options for the untraced run measuring the
+// overhead of the traced iteration with the given index. Only what affects
+// the startup time is kept, leaving out tracing, waiting on the user and
+// anything only reported.
+func (x *runOptions) untracedOptions(iter, iterations uint) *runOptions {
+	untraced := *x
+	untraced.untracedPass = true
+	untraced.tracedIteration = iter
+	untraced.tracedIterations = iterations
+	untraced.MeasureOverhead = false
+
+	untraced.NoTrace = true
+	untraced.StraceSummary = false
+	untraced.PerfCounters = false
+	untraced.FailedOpens = false
+	untraced.NetworkActivity = false
+	untraced.Futexes = false
+	untraced.Threads = false
+	untraced.FirstDraw = false
+	untraced.MinSyscallTime = 0
+	untraced.StraceDebug = false
+	untraced.StraceLogDir = ""
+	untraced.KeepSlowestLog = ""
+
+	untraced.KeepRunning = false
+	untraced.CloseMode = "kill"
+	untraced.events = nil
+
+	untraced.ReportDenials = false
+	untraced.MmapFaultAnalysis = false
+	untraced.WaitForIdle = false
+	untraced.WaitForActive = false
+	untraced.SystemBusyness = false
+	untraced.CaptureOutput = false
+	untraced.RecordEnv = nil
+	untraced.ScriptAnnotations = false
+	untraced.Histogram = false
+	untraced.IterationTable = false
+	// the logs are per iteration, which the untraced run isn't
+	untraced.ProgramStdoutLog = ""
+	untraced.ProgramStderrLog = ""
+	return &untraced
+}
+
+// measureOverhead runs the command once more without tracing after the
+// traced iteration with the given index, returning how much longer the traced
+// startup took along with any errors from the untraced run
+func (x *runOptions) measureOverhead(cmdArgs []string, tracedStartup time.Duration, iter, iterations uint) (time.Duration, []error, error) {
+	untraced := x.untracedOptions(iter, iterations)
+	if x.IterationDelay > 0 {
+		time.Sleep(x.IterationDelay)
+	}
+	// the errors so far belong to the traced run, which already has them
+	resetErrors()
+	res, err := untraced.run(ioutil.Discard, cmdArgs)
+	if err != nil {
+		return 0, nil, err
+	}
+	untracedRun := res.Runs[0]
+	var untracedErrs []error
+	for _, err := range untracedRun.Errors {
+		untracedErrs = append(untracedErrs, fmt.Errorf("untraced run: %w", err))
+	}
+	return tracedStartup - untracedRun.TimeToDisplay, untracedErrs, nil
+}
+
+// rawUnits are the units which --raw can print in
+var rawUnits = map[string]time.Duration{
+	"ns": time.Nanosecond,
+	"us": time.Microsecond,
+	"ms": time.Millisecond,
+	"s":  time.Second,
+}
+
+// rounded rounds d to the precision given with --precision for showing it
+func rounded(d time.Duration) time.Duration {
+	return strace.RoundDuration(d, currentCmd.Precision)
+}
+
+// writeIterationTable prints the times and number of errors of every iteration
+func (o *OutputResult) writeIterationTable(w io.Writer) {
+	tw := tabWriterGeneric(w)
+	fmt.Fprintln(tw, "Iteration\tStartup time\tTotal run time\tErrors")
+	for i, run := range o.Runs {
+		fmt.Fprintf(tw, "%d\t%v\t%v\t%d\n", i+1, rounded(run.TimeToDisplay), rounded(run.TimeToRun), len(run.Errors))
+	}
+	tw.Flush()
+}
+
+// writeReport prints the single startup time selected with --report, if any
+func (x *runOptions) writeReport(w io.Writer, outRes *OutputResult) error {
+	if x.Report == "" {
+		return nil
+	}
+	result, err := reportDuration(x.Report, outRes.startupTimes())
+	if err != nil {
+		return err
+	}
+	if x.Raw {
+		fmt.Fprintln(w, strconv.FormatFloat(float64(result)/float64(rawUnits[x.RawUnit]), 'f', -1, 64))
+		return nil
+	}
+	fmt.Fprintf(w, "Reported (%s) startup time: %v\n", x.Report, rounded(result))
 	return nil
 }
@@ -0,0 +1,100 @@
+/*
+ * Copyright (C) 2019 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+func markdownRow(w io.Writer, cells ...string) {
+	fmt.Fprintf(w, "| %s |\n", strings.Join(cells, " | "))
+}
+
+// markdownTime formats d for a Markdown table, struck through if it's left
+// out of the mean
+func markdownTime(d time.Duration, invalid bool) string {
+	if invalid {
+		return "~~" + rounded(d).String() + "~~"
+	}
+	return rounded(d).String()
+}
+
+// writeMarkdown renders a Markdown table of every iteration with the mean of
+// all the iterations as the last row. The times of the iterations which went
+// over --max-startup or under --min-startup, and the run times which can't be
+// trusted as the clock was stepped, are struck through and left out of the
+// mean like in the statistics.
+func (o *OutputResult) writeMarkdown(w io.Writer) {
+	// only show time to idle if it was measured
+	withIdle := false
+	for _, run := range o.Runs {
+		if run.TimeToIdle != 0 {
+			withIdle = true
+			break
+		}
+	}
+
+	header := []string{"Iteration", "Startup time", "Total run time"}
+	if withIdle {
+		header = append(header, "Time to idle")
+	}
+	header = append(header, "Errors")
+	markdownRow(w, header...)
+	sep := make([]string, len(header))
+	for i := range sep {
+		sep[i] = "---"
+	}
+	markdownRow(w, sep...)
+
+	var idleTimes []time.Duration
+	nErrs := 0
+	struck := false
+	for i, run := range o.Runs {
+		invalid := run.OverMaxStartup || run.UnderMinStartup
+		row := []string{
+			fmt.Sprint(i + 1),
+			markdownTime(run.TimeToDisplay, invalid),
+			markdownTime(run.TimeToRun, invalid || run.ClockSkewed),
+		}
+		if withIdle {
+			row = append(row, markdownTime(run.TimeToIdle, invalid))
+		}
+		row = append(row, fmt.Sprint(len(run.Errors)))
+		markdownRow(w, row...)
+
+		struck = struck || invalid || run.ClockSkewed
+		if !invalid {
+			idleTimes = append(idleTimes, run.TimeToIdle)
+		}
+		nErrs += len(run.Errors)
+	}
+
+	summary := []string{"**Mean**", rounded(meanDuration(o.startupTimes())).String(), rounded(meanDuration(o.runTimes())).String()}
+	if withIdle {
+		summary = append(summary, rounded(meanDuration(idleTimes)).String())
+	}
+	summary = append(summary, fmt.Sprint(nErrs))
+	markdownRow(w, summary...)
+	if struck {
+		fmt.Fprintln(w)
+		fmt.Fprintln(w, "Struck through times are left out of the mean.")
+	}
+}
@@ -0,0 +1,203 @@
+/*
+ * Copyright (C) 2019 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+type cmdMerge struct {
+	GroupBy    string `long:"group-by" value-name:"command|file|host|tag:key" default:"command" description:"What to group the runs by, the command which was run, the file the results came from, the machine it ran on or the value of a --tag"`
+	JSONOutput bool   `short:"j" long:"json" description:"Output the merged statistics in JSON"`
+	Markdown   bool   `long:"markdown" description:"Output the merged statistics as a Markdown table"`
+
+	Args struct {
+		Files []string `positional-arg-name:"file" description:"JSON results from etrace run or etrace batch" required:"1"`
+	} `positional-args:"yes" required:"yes"`
+}
+
+// MergeGroup is the statistics of all the runs in one group
+type MergeGroup struct {
+	RunSummary
+	// Files are the results files the runs came from
+	Files  []string
+	Errors int
+}
+
+// MergeResult is the combined statistics of several results files
+type MergeResult struct {
+	Etrace  BuildInfo
+	GroupBy string
+	Groups  map[string]*MergeGroup
+}
+
+// fileResult is a result read from a file, along with the command it's for
+type fileResult struct {
+	file    string
+	command string
+	res     *OutputResult
+}
+
+// readResults reads the results of either etrace run or etrace batch from a
+// JSON file
+func readResults(path string) ([]fileResult, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var doc struct {
+		OutputResult
+		// only a batch result has Results
+		Results map[string]*OutputResult
+	}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("cannot read results from %s: %w", path, err)
+	}
+
+	if doc.Results == nil {
+		command := doc.Command
+		// results from before the command was recorded only have the
+		// command as it was run
+		if len(command) == 0 {
+			command = doc.TraceCommand
+		}
+		return []fileResult{{file: path, command: strings.Join(command, " "), res: &doc.OutputResult}}, nil
+	}
+	names := make([]string, 0, len(doc.Results))
+	for name := range doc.Results {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	results := make([]fileResult, 0, len(names))
+	for _, name := range names {
+		results = append(results, fileResult{file: path, command: name, res: doc.Results[name]})
+	}
+	return results, nil
+}
+
+// groupOf returns the group run belongs to
+func (x *cmdMerge) groupOf(fr fileResult, run Execution) string {
+	switch {
+	case x.GroupBy == "command":
+		return fr.command
+	case x.GroupBy == "file":
+		return fr.file
+	case x.GroupBy == "host":
+		// results from before the system was recorded, or from --remote
+		if fr.res.System == nil {
+			return "(unknown)"
+		}
+		return fr.res.System.Hostname
+	default:
+		key := strings.TrimPrefix(x.GroupBy, "tag:")
+		if value, ok := run.Tags[key]; ok {
+			return value
+		}
+		return "(none)"
+	}
+}
+
+func (x *cmdMerge) Execute(args []string) error {
+	if x.JSONOutput && x.Markdown {
+		return fmt.Errorf("cannot use --json and --markdown together")
+	}
+	if x.GroupBy != "command" && x.GroupBy != "file" && x.GroupBy != "host" && (!strings.HasPrefix(x.GroupBy, "tag:") || x.GroupBy == "tag:") {
+		return fmt.Errorf("cannot group by %q, expected command, file, host or tag:key", x.GroupBy)
+	}
+
+	merged := &MergeResult{
+		Etrace:  currentBuildInfo(),
+		GroupBy: x.GroupBy,
+		Groups:  make(map[string]*MergeGroup),
+	}
+	runs := make(map[string]int)
+	times := make(map[string][]time.Duration)
+	for _, path := range x.Args.Files {
+		results, err := readResults(path)
+		if err != nil {
+			return err
+		}
+		for _, fr := range results {
+			for _, run := range fr.res.Runs {
+				name := x.groupOf(fr, run)
+				group, ok := merged.Groups[name]
+				if !ok {
+					group = &MergeGroup{}
+					merged.Groups[name] = group
+				}
+				if len(group.Files) == 0 || group.Files[len(group.Files)-1] != path {
+					group.Files = append(group.Files, path)
+				}
+				group.Errors += len(run.Errors)
+				runs[name]++
+				if !run.OverMaxStartup && !run.UnderMinStartup {
+					times[name] = append(times[name], run.TimeToDisplay)
+				}
+			}
+		}
+	}
+	for name, group := range merged.Groups {
+		group.RunSummary = *summarize(runs[name], times[name])
+	}
+
+	switch {
+	case x.JSONOutput:
+		return json.NewEncoder(os.Stdout).Encode(merged)
+	case x.Markdown:
+		merged.writeMarkdown(os.Stdout)
+	default:
+		merged.writeTable(os.Stdout)
+	}
+	return nil
+}
+
+// sortedGroups returns the names of the groups in order
+func (m *MergeResult) sortedGroups() []string {
+	names := make([]string, 0, len(m.Groups))
+	for name := range m.Groups {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (m *MergeResult) writeTable(w io.Writer) {
+	tw := tabWriterGeneric(w)
+	fmt.Fprintln(tw, "Group\tRuns\tFastest\tMedian\tMean\tSlowest\tErrors")
+	for _, name := range m.sortedGroups() {
+		g := m.Groups[name]
+		fmt.Fprintf(tw, "%s\t%d\t%v\t%v\t%v\t%v\t%d\n", name, g.Iterations, rounded(g.Fastest), rounded(g.Median), rounded(g.Mean), rounded(g.Slowest), g.Errors)
+	}
+	tw.Flush()
+}
+
+func (m *MergeResult) writeMarkdown(w io.Writer) {
+	markdownRow(w, "Group", "Runs", "Fastest", "Median", "Mean", "Slowest", "Errors")
+	markdownRow(w, "---", "---", "---", "---", "---", "---", "---")
+	for _, name := range m.sortedGroups() {
+		g := m.Groups[name]
+		markdownRow(w, "`"+name+"`", fmt.Sprint(g.Iterations), rounded(g.Fastest).String(), rounded(g.Median).String(), rounded(g.Mean).String(), rounded(g.Slowest).String(), fmt.Sprint(g.Errors))
+	}
+}
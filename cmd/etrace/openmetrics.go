@@ -0,0 +1,159 @@
+/*
+ * Copyright (C) 2019 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// openMetricsBuckets are the upper bounds in seconds of the buckets of the
+// startup time histogram, the last one being +Inf
+var openMetricsBuckets = []float64{0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// openMetricsResult is the result of a single command for the OpenMetrics
+// output, which is named after the command
+type openMetricsResult struct {
+	name string
+	res  *OutputResult
+}
+
+// openMetricsFloat formats f as OpenMetrics expects, always with a decimal
+// point so that the le labels are canonical
+func openMetricsFloat(f float64) string {
+	s := strconv.FormatFloat(f, 'f', -1, 64)
+	if !strings.Contains(s, ".") {
+		s += ".0"
+	}
+	return s
+}
+
+// openMetricsLabels formats the labels of a command's metrics, which are the
+// command itself and its tags, along with any extra labels in the order given
+func openMetricsLabels(name string, tags map[string]string, extra ...string) string {
+	escape := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	labels := []string{fmt.Sprintf(`command="%s"`, escape.Replace(name))}
+
+	keys := make([]string, 0, len(tags))
+	for k := range tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		// label names can only have letters, digits and underscores
+		key := strings.Map(func(r rune) rune {
+			switch {
+			case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '_':
+				return r
+			}
+			return '_'
+		}, k)
+		if key == "command" || key == "le" || (key[0] >= '0' && key[0] <= '9') {
+			key = "tag_" + key
+		}
+		labels = append(labels, fmt.Sprintf(`%s="%s"`, key, escape.Replace(tags[k])))
+	}
+	for i := 0; i+1 < len(extra); i += 2 {
+		labels = append(labels, fmt.Sprintf(`%s="%s"`, extra[i], extra[i+1]))
+	}
+	return "{" + strings.Join(labels, ",") + "}"
+}
+
+// openMetricsExemplar formats an exemplar pointing at the iteration with the
+// given index, numbered from 1 as everywhere else
+func openMetricsExemplar(i int, value string) string {
+	return fmt.Sprintf(` # {iteration="%d"} %s`, i+1, value)
+}
+
+// writeOpenMetrics writes the startup times of every result as an OpenMetrics
+// histogram, along with the errors of every result as a counter. Each bucket
+// of the histogram has the slowest iteration in it as an exemplar, and the
+// counter has the iteration with the most errors, so that a slow or failing
+// iteration can be looked up in the full results.
+func writeOpenMetrics(w io.Writer, results []openMetricsResult) {
+	fmt.Fprintln(w, "# TYPE etrace_startup_seconds histogram")
+	fmt.Fprintln(w, "# UNIT etrace_startup_seconds seconds")
+	fmt.Fprintln(w, "# HELP etrace_startup_seconds Time for the command to display its window, or to run with --no-window-wait.")
+	for _, r := range results {
+		// the last bucket is +Inf
+		counts := make([]int, len(openMetricsBuckets)+1)
+		// the slowest iteration in every bucket, or -1 for none
+		slowest := make([]int, len(counts))
+		for i := range slowest {
+			slowest[i] = -1
+		}
+
+		var sum time.Duration
+		count := 0
+		for i, run := range r.res.Runs {
+			if run.OverMaxStartup || run.UnderMinStartup {
+				continue
+			}
+			sum += run.TimeToDisplay
+			count++
+			secs := run.TimeToDisplay.Seconds()
+			b := sort.SearchFloat64s(openMetricsBuckets, secs)
+			counts[b]++
+			if slowest[b] == -1 || run.TimeToDisplay > r.res.Runs[slowest[b]].TimeToDisplay {
+				slowest[b] = i
+			}
+		}
+
+		cumulative := 0
+		for b := range counts {
+			le := "+Inf"
+			if b < len(openMetricsBuckets) {
+				le = openMetricsFloat(openMetricsBuckets[b])
+			}
+			cumulative += counts[b]
+			fmt.Fprintf(w, "etrace_startup_seconds_bucket%s %d",
+				openMetricsLabels(r.name, r.res.Tags, "le", le), cumulative)
+			if slowest[b] != -1 {
+				secs := r.res.Runs[slowest[b]].TimeToDisplay.Seconds()
+				fmt.Fprint(w, openMetricsExemplar(slowest[b], openMetricsFloat(secs)))
+			}
+			fmt.Fprintln(w)
+		}
+		labels := openMetricsLabels(r.name, r.res.Tags)
+		fmt.Fprintf(w, "etrace_startup_seconds_sum%s %s\n", labels, openMetricsFloat(sum.Seconds()))
+		fmt.Fprintf(w, "etrace_startup_seconds_count%s %d\n", labels, count)
+	}
+
+	fmt.Fprintln(w, "# TYPE etrace_errors counter")
+	fmt.Fprintln(w, "# HELP etrace_errors Errors which happened while running the command.")
+	for _, r := range results {
+		total := 0
+		worst := -1
+		for i, run := range r.res.Runs {
+			total += len(run.Errors)
+			if len(run.Errors) != 0 && (worst == -1 || len(run.Errors) > len(r.res.Runs[worst].Errors)) {
+				worst = i
+			}
+		}
+		fmt.Fprintf(w, "etrace_errors_total%s %d", openMetricsLabels(r.name, r.res.Tags), total)
+		if worst != -1 {
+			fmt.Fprint(w, openMetricsExemplar(worst, strconv.Itoa(len(r.res.Runs[worst].Errors))))
+		}
+		fmt.Fprintln(w)
+	}
+	fmt.Fprintln(w, "# EOF")
+}
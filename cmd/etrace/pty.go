@@ -0,0 +1,114 @@
+/*
+ * Copyright (C) 2019 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// ptyOutput gives the command a pseudo-terminal as its stdin, stdout and
+// stderr so that it behaves as it would when run from a terminal, copying
+// everything it prints on to another writer
+type ptyOutput struct {
+	master, tty *os.File
+	done        chan struct{}
+}
+
+func ioctl(f *os.File, req uint, arg unsafe.Pointer) error {
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, f.Fd(), uintptr(req), uintptr(arg)); errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// isTerminal returns whether f is a terminal
+func isTerminal(f *os.File) bool {
+	var termios syscall.Termios
+	return ioctl(f, syscall.TCGETS, unsafe.Pointer(&termios)) == nil
+}
+
+// openPty opens a new pseudo-terminal, returning its master side and the
+// terminal itself
+func openPty() (*os.File, *os.File, error) {
+	master, err := os.OpenFile("/dev/ptmx", os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+	var unlock int32
+	if err := ioctl(master, syscall.TIOCSPTLCK, unsafe.Pointer(&unlock)); err != nil {
+		master.Close()
+		return nil, nil, fmt.Errorf("cannot unlock pty: %w", err)
+	}
+	var n uint32
+	if err := ioctl(master, syscall.TIOCGPTN, unsafe.Pointer(&n)); err != nil {
+		master.Close()
+		return nil, nil, fmt.Errorf("cannot get pty number: %w", err)
+	}
+	tty, err := os.OpenFile(fmt.Sprintf("/dev/pts/%d", n), os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		master.Close()
+		return nil, nil, err
+	}
+	return master, tty, nil
+}
+
+func newPtyOutput(out io.Writer) (*ptyOutput, error) {
+	master, tty, err := openPty()
+	if err != nil {
+		return nil, err
+	}
+	p := &ptyOutput{
+		master: master,
+		tty:    tty,
+		done:   make(chan struct{}),
+	}
+	go func() {
+		defer close(p.done)
+		// reading fails with EIO rather than EOF once the terminal is closed
+		io.Copy(out, master)
+	}()
+	return p, nil
+}
+
+// sysProcAttr makes the terminal the controlling terminal of the command in
+// a new session, which needs the terminal to be the command's stdin
+func (p *ptyOutput) sysProcAttr() *syscall.SysProcAttr {
+	return &syscall.SysProcAttr{Setsid: true, Setctty: true, Ctty: 0}
+}
+
+// started closes our copy of the terminal once the command has been started
+// with it
+func (p *ptyOutput) started() {
+	p.tty.Close()
+}
+
+// finish waits for the rest of the output and closes the terminal
+func (p *ptyOutput) finish() {
+	// close the terminal in case the command never started
+	p.tty.Close()
+	select {
+	case <-p.done:
+	case <-time.After(captureDrainTimeout):
+	}
+	p.master.Close()
+}
@@ -0,0 +1,116 @@
+/*
+ * Copyright (C) 2019 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+
+	"github.com/anonymouse64/etrace/internal/profiling"
+	"github.com/anonymouse64/etrace/internal/remote"
+	"github.com/anonymouse64/etrace/internal/snaps"
+	"github.com/anonymouse64/etrace/internal/strace"
+)
+
+// freeCaches frees the caches before running the command to get the most
+// accurate timing, only evicting the files the command used before with
+// --evict-target-only
+func (x *runOptions) freeCaches(targetFiles map[string]bool) error {
+	switch {
+	case x.NoFlushCaches:
+		return nil
+	case x.Remote != "":
+		return remote.FreeCaches(x.Remote)
+	case x.EvictTargetOnly && len(targetFiles) != 0:
+		// only the first iteration drops all caches, as we don't know yet
+		// which files the command uses
+		files := make([]string, 0, len(targetFiles))
+		for f := range targetFiles {
+			files = append(files, f)
+		}
+		return profiling.EvictFiles(files)
+	}
+	return profiling.FreeCaches()
+}
+
+// memoryCgroup makes the cgroup limiting the command's memory with
+// --memory-limit, nil if there's no limit
+func (x *runOptions) memoryCgroup(cmd *exec.Cmd, iter uint) (*profiling.MemoryCgroup, error) {
+	if x.MemoryLimit == "" {
+		return nil, nil
+	}
+	name := fmt.Sprintf("etrace-%d-%d", os.Getpid(), iter)
+	memCgroup, err := profiling.NewMemoryCgroup(name, x.MemoryLimit)
+	if err != nil {
+		return nil, err
+	}
+	if err := memCgroup.Wrap(cmd); err != nil {
+		memCgroup.Remove()
+		return nil, err
+	}
+	return memCgroup, nil
+}
+
+// memoryEvents reads what happened in the memory cgroup and removes it
+func memoryEvents(memCgroup *profiling.MemoryCgroup, name string, iter uint) *profiling.MemoryEvents {
+	events, err := memCgroup.Events()
+	if err != nil {
+		logError(fmt.Errorf("reading memory cgroup events: %w", err))
+	} else if events.OOMKilled {
+		log.Printf("warning: %s ran out of memory and was killed in iteration %d", name, iter+1)
+	}
+	if err := memCgroup.Remove(); err != nil {
+		logError(err)
+	}
+	return events
+}
+
+// systemCPUTimes reads the system's CPU times for --system-busyness, which
+// are nil if they can't be read
+func systemCPUTimes() *profiling.SystemCPUTimes {
+	times, err := profiling.ReadSystemCPUTimes()
+	if err != nil {
+		logError(fmt.Errorf("reading system CPU times: %w", err))
+	}
+	return times
+}
+
+// denials finds the denials of the command's processes since it started
+func (x *runOptions) denials(proc *runningCommand, daemons []int, timing *strace.ExecveTiming, cmdArgs []string) []snaps.Denial {
+	// the trace has all of the command's processes, without it only
+	// the command itself and what it left running are known
+	var pids []int
+	if proc.cmd.Process != nil {
+		pids = append(pids, proc.cmd.Process.Pid)
+	}
+	pids = append(pids, daemons...)
+	if timing != nil {
+		pids = append(pids, timing.Pids()...)
+	}
+	var snap string
+	if x.RunThroughSnap {
+		snap = cmdArgs[0]
+	}
+	denials, err := snaps.Denials(proc.start, pids, snap)
+	if err != nil {
+		logError(err)
+	}
+	return denials
+}
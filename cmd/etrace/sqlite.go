@@ -0,0 +1,119 @@
+/*
+ * Copyright (C) 2019 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	// registers the pure Go "sqlite" driver, so that sqlite3 isn't needed
+	_ "modernc.org/sqlite"
+)
+
+// the table --sqlite appends the runs to, where everything measured which
+// doesn't have its own column can still be queried from the run's JSON with
+// json_extract()
+const sqliteSchema = `CREATE TABLE IF NOT EXISTS runs (
+	timestamp TEXT NOT NULL,
+	command TEXT NOT NULL,
+	tags TEXT,
+	iteration INTEGER NOT NULL,
+	time_to_display_ns INTEGER,
+	time_to_run_ns INTEGER,
+	time_to_idle_ns INTEGER,
+	time_to_run_cpu_ns INTEGER,
+	exit_code INTEGER,
+	over_max_startup INTEGER,
+	under_min_startup INTEGER,
+	errors INTEGER,
+	run TEXT
+)`
+
+const sqliteInsert = `INSERT INTO runs VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+
+// insertRuns adds every run in outRes to the runs table in a single
+// transaction
+func insertRuns(db *sql.DB, outRes *OutputResult, timestamp time.Time) error {
+	// a NULL rather than an empty object without tags
+	var tags interface{}
+	if len(outRes.Tags) != 0 {
+		b, err := json.Marshal(outRes.Tags)
+		if err != nil {
+			return err
+		}
+		tags = string(b)
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(sqliteSchema); err != nil {
+		return err
+	}
+	stmt, err := tx.Prepare(sqliteInsert)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	command := strings.Join(outRes.Command, " ")
+	for i, run := range outRes.Runs {
+		b, err := json.Marshal(run)
+		if err != nil {
+			return err
+		}
+		_, err = stmt.Exec(
+			timestamp.UTC().Format(time.RFC3339),
+			command,
+			tags,
+			i+1,
+			int64(run.TimeToDisplay),
+			int64(run.TimeToRun),
+			int64(run.TimeToIdle),
+			int64(run.TimeToRunCPU),
+			run.ExitCode,
+			run.OverMaxStartup,
+			run.UnderMinStartup,
+			len(run.Errors),
+			string(b),
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// appendSQLite adds the runs to the database given with --sqlite, creating it
+// if needed
+func (x *runOptions) appendSQLite(outRes *OutputResult) error {
+	db, err := sql.Open("sqlite", x.SQLite)
+	if err != nil {
+		return fmt.Errorf("cannot open %s: %w", x.SQLite, err)
+	}
+	defer db.Close()
+	if err := insertRuns(db, outRes, time.Now()); err != nil {
+		return fmt.Errorf("cannot add the runs to %s: %w", x.SQLite, err)
+	}
+	return nil
+}
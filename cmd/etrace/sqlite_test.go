@@ -0,0 +1,123 @@
+/*
+ * Copyright (C) 2019 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) { check.TestingT(t) }
+
+type sqliteTestSuite struct {
+	db *sql.DB
+}
+
+var _ = check.Suite(&sqliteTestSuite{})
+
+func (s *sqliteTestSuite) SetUpTest(c *check.C) {
+	db, err := sql.Open("sqlite", filepath.Join(c.MkDir(), "results.db"))
+	c.Assert(err, check.IsNil)
+	s.db = db
+}
+
+func (s *sqliteTestSuite) TearDownTest(c *check.C) {
+	s.db.Close()
+}
+
+var sqliteTestResult = &OutputResult{
+	Command: []string{"gnome-calculator", "--help"},
+	Tags:    map[string]string{"kernel": "5.4"},
+	Runs: []Execution{
+		{
+			TimeToDisplay: 2 * time.Second,
+			TimeToRun:     3 * time.Second,
+			ExitCode:      0,
+		},
+		{
+			TimeToDisplay:  time.Second,
+			OverMaxStartup: true,
+			ExitCode:       -1,
+			Errors:         errorList{errors.New("window did not close")},
+		},
+	},
+}
+
+func (s *sqliteTestSuite) TestInsertRuns(c *check.C) {
+	timestamp := time.Date(2019, 10, 1, 12, 0, 0, 0, time.UTC)
+	c.Assert(insertRuns(s.db, sqliteTestResult, timestamp), check.IsNil)
+
+	rows, err := s.db.Query("SELECT timestamp, command, tags, iteration, time_to_display_ns, time_to_run_ns, exit_code, over_max_startup, errors FROM runs ORDER BY iteration")
+	c.Assert(err, check.IsNil)
+	defer rows.Close()
+	type row struct {
+		timestamp, command, tags string
+		iteration                int
+		display, run             int64
+		exitCode                 int
+		overMax                  bool
+		errors                   int
+	}
+	var got []row
+	for rows.Next() {
+		var r row
+		c.Assert(rows.Scan(&r.timestamp, &r.command, &r.tags, &r.iteration, &r.display, &r.run, &r.exitCode, &r.overMax, &r.errors), check.IsNil)
+		got = append(got, r)
+	}
+	c.Assert(rows.Err(), check.IsNil)
+	c.Check(got, check.DeepEquals, []row{
+		{"2019-10-01T12:00:00Z", "gnome-calculator --help", `{"kernel":"5.4"}`, 1, int64(2 * time.Second), int64(3 * time.Second), 0, false, 0},
+		{"2019-10-01T12:00:00Z", "gnome-calculator --help", `{"kernel":"5.4"}`, 2, int64(time.Second), 0, -1, true, 1},
+	})
+}
+
+func (s *sqliteTestSuite) TestInsertRunsJSON(c *check.C) {
+	c.Assert(insertRuns(s.db, sqliteTestResult, time.Now()), check.IsNil)
+
+	var b string
+	c.Assert(s.db.QueryRow("SELECT run FROM runs WHERE iteration = 1").Scan(&b), check.IsNil)
+	var run Execution
+	c.Assert(json.Unmarshal([]byte(b), &run), check.IsNil)
+	c.Check(run.TimeToRun, check.Equals, 3*time.Second)
+
+	// the whole run can still be queried
+	var display int64
+	c.Assert(s.db.QueryRow("SELECT json_extract(run, '$.TimeToDisplay') FROM runs WHERE iteration = 2").Scan(&display), check.IsNil)
+	c.Check(display, check.Equals, int64(time.Second))
+}
+
+func (s *sqliteTestSuite) TestInsertRunsAppends(c *check.C) {
+	c.Assert(insertRuns(s.db, sqliteTestResult, time.Now()), check.IsNil)
+	// the table is only created the first time
+	c.Assert(insertRuns(s.db, &OutputResult{Command: []string{"true"}, Runs: []Execution{{}}}, time.Now()), check.IsNil)
+
+	var n int
+	c.Assert(s.db.QueryRow("SELECT count(*) FROM runs").Scan(&n), check.IsNil)
+	c.Check(n, check.Equals, 3)
+
+	// no tags are NULL rather than an empty object
+	var tags sql.NullString
+	c.Assert(s.db.QueryRow("SELECT tags FROM runs WHERE command = 'true'").Scan(&tags), check.IsNil)
+	c.Check(tags.Valid, check.Equals, false)
+}
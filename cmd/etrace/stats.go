@@ -0,0 +1,182 @@
+/*
+ * Copyright (C) 2019 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package main
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// startupTimes returns the time to display of every run in the result which
+// didn't go over --max-startup or under --min-startup
+func (o *OutputResult) startupTimes() []time.Duration {
+	times := make([]time.Duration, 0, len(o.Runs))
+	for _, run := range o.Runs {
+		if run.OverMaxStartup || run.UnderMinStartup {
+			continue
+		}
+		times = append(times, run.TimeToDisplay)
+	}
+	return times
+}
+
+// runTimes returns the time to run of every run startupTimes uses, apart from
+// the ones whose traced timings can't be trusted as the clock changed
+func (o *OutputResult) runTimes() []time.Duration {
+	times := make([]time.Duration, 0, len(o.Runs))
+	for _, run := range o.Runs {
+		if run.OverMaxStartup || run.UnderMinStartup || run.ClockSkewed {
+			continue
+		}
+		times = append(times, run.TimeToRun)
+	}
+	return times
+}
+
+func minDuration(times []time.Duration) time.Duration {
+	if len(times) == 0 {
+		return 0
+	}
+	min := times[0]
+	for _, t := range times[1:] {
+		if t < min {
+			min = t
+		}
+	}
+	return min
+}
+
+func maxDuration(times []time.Duration) time.Duration {
+	if len(times) == 0 {
+		return 0
+	}
+	max := times[0]
+	for _, t := range times[1:] {
+		if t > max {
+			max = t
+		}
+	}
+	return max
+}
+
+func meanDuration(times []time.Duration) time.Duration {
+	if len(times) == 0 {
+		return 0
+	}
+	var total time.Duration
+	for _, t := range times {
+		total += t
+	}
+	return total / time.Duration(len(times))
+}
+
+func medianDuration(times []time.Duration) time.Duration {
+	if len(times) == 0 {
+		return 0
+	}
+	// don't re-order the caller's slice
+	sorted := make([]time.Duration, len(times))
+	copy(sorted, times)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	mid := len(sorted) / 2
+	if len(sorted)%2 == 0 {
+		return (sorted[mid-1] + sorted[mid]) / 2
+	}
+	return sorted[mid]
+}
+
+// reportDuration computes the single value selected with --report over the
+// given times
+func reportDuration(report string, times []time.Duration) (time.Duration, error) {
+	switch report {
+	case "best":
+		return minDuration(times), nil
+	case "mean":
+		return meanDuration(times), nil
+	case "median":
+		return medianDuration(times), nil
+	case "worst":
+		return maxDuration(times), nil
+	default:
+		return 0, fmt.Errorf("unknown report type %q", report)
+	}
+}
+
+// RunSummary aggregates the startup times of all iterations
+type RunSummary struct {
+	Iterations int
+	Fastest    time.Duration
+	Median     time.Duration
+	Mean       time.Duration
+	Slowest    time.Duration
+}
+
+// summarize returns the summary of the startup times of iterations runs,
+// which may be more than there are times for
+func summarize(iterations int, times []time.Duration) *RunSummary {
+	return &RunSummary{
+		Iterations: iterations,
+		Fastest:    minDuration(times),
+		Median:     medianDuration(times),
+		Mean:       meanDuration(times),
+		Slowest:    maxDuration(times),
+	}
+}
+
+// sampled returns a copy of the result with only n of the runs, spread evenly
+// from the fastest to the slowest, and a summary of all of them, or the result
+// itself if there are no more than n runs
+func (o *OutputResult) sampled(n int) *OutputResult {
+	if n == 0 || len(o.Runs) <= n {
+		return o
+	}
+
+	res := *o
+	res.Summary = summarize(len(o.Runs), o.startupTimes())
+
+	byStartup := make([]int, len(o.Runs))
+	for i := range byStartup {
+		byStartup[i] = i
+	}
+	sort.SliceStable(byStartup, func(i, j int) bool {
+		return o.Runs[byStartup[i]].TimeToDisplay < o.Runs[byStartup[j]].TimeToDisplay
+	})
+
+	// a single sample is the median
+	picked := make(map[int]bool, n)
+	if n == 1 {
+		picked[byStartup[len(byStartup)/2]] = true
+	} else {
+		for k := 0; k < n; k++ {
+			picked[byStartup[k*(len(byStartup)-1)/(n-1)]] = true
+		}
+	}
+
+	// keep the runs in the order they happened
+	res.Runs = make([]Execution, 0, n)
+	res.SampledIterations = make([]int, 0, n)
+	for i, run := range o.Runs {
+		if picked[i] {
+			res.Runs = append(res.Runs, run)
+			res.SampledIterations = append(res.SampledIterations, i+1)
+		}
+	}
+	return &res
+}
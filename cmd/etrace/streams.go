@@ -0,0 +1,176 @@
+/*
+ * Copyright (C) 2019 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+)
+
+// commandStreams are where the command's output goes: the log files, the
+// output markers, what's captured and the terminal it runs in
+type commandStreams struct {
+	logs                         []*os.File
+	markers                      *outputMarkers
+	stdoutCapture, stderrCapture *outputCapture
+	pty                          *ptyOutput
+	straceStderr                 *stderrSplitter
+}
+
+// connectStreams redirects all output from the command to the log files if
+// they exist, otherwise just to this process's stdout, etc.
+func (x *runOptions) connectStreams(cmd *exec.Cmd, iter uint) (*commandStreams, error) {
+	s := &commandStreams{}
+	if err := x.connect(s, cmd, iter); err != nil {
+		s.close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// connect sets up the streams in s for the command
+func (x *runOptions) connect(s *commandStreams, cmd *exec.Cmd, iter uint) error {
+	cmd.Stdin = x.cmdStdin()
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if x.ProgramStdoutLog != "" {
+		f, err := openCmdLog(x.ProgramStdoutLog, iter)
+		if err != nil {
+			return err
+		}
+		s.logs = append(s.logs, f)
+		cmd.Stdout = f
+	}
+	if x.ProgramStderrLog != "" {
+		f, err := openCmdLog(x.ProgramStderrLog, iter)
+		if err != nil {
+			return err
+		}
+		s.logs = append(s.logs, f)
+		cmd.Stderr = f
+	}
+
+	// look for the markers in everything the command prints
+	if x.readyMarker != nil {
+		s.markers = newOutputMarkers(x.startMarker, x.readyMarker)
+		cmd.Stdout = s.markers.writer(cmd.Stdout)
+		cmd.Stderr = s.markers.writer(cmd.Stderr)
+	}
+
+	// also keep the output for the results, still passing it on as
+	// above, which is also how the markers are given the output through
+	// a pipe
+	if x.CaptureOutput || s.markers != nil {
+		captureMax := 0
+		if x.CaptureOutput {
+			captureMax = x.CaptureOutputMax
+		}
+		var err error
+		s.stdoutCapture, err = newOutputCapture(captureMax, cmd.Stdout)
+		if err != nil {
+			return err
+		}
+		s.stderrCapture, err = newOutputCapture(captureMax, cmd.Stderr)
+		if err != nil {
+			return err
+		}
+		cmd.Stdout = s.stdoutCapture.w
+		cmd.Stderr = s.stderrCapture.w
+	}
+
+	// the terminal replaces all of the command's streams, passing what it
+	// prints on to where its stdout would go
+	if x.Pty {
+		var err error
+		s.pty, err = newPtyOutput(cmd.Stdout)
+		if err != nil {
+			return fmt.Errorf("cannot open pty: %w", err)
+		}
+		cmd.Stdin = s.pty.tty
+		cmd.Stdout = s.pty.tty
+		cmd.Stderr = s.pty.tty
+		cmd.SysProcAttr = s.pty.sysProcAttr()
+	}
+
+	// strace is given the command's stderr, so take its own messages back
+	// out of it before it reaches the log or the captured output
+	if x.StraceDebug {
+		var err error
+		s.straceStderr, err = newStderrSplitter(cmd.Stderr, os.Stderr)
+		if err != nil {
+			return err
+		}
+		cmd.Stderr = s.straceStderr.w
+	}
+	return nil
+}
+
+// started is called once the command has started
+func (s *commandStreams) started() {
+	if s.stdoutCapture != nil {
+		// the terminal's output is still written to the captured stdout
+		// and the splitter's to the captured stderr until they're
+		// finished
+		if s.pty == nil {
+			s.stdoutCapture.started()
+		}
+		if s.straceStderr == nil {
+			s.stderrCapture.started()
+		}
+	}
+	if s.straceStderr != nil {
+		s.straceStderr.started()
+	}
+	if s.pty != nil {
+		s.pty.started()
+	}
+}
+
+// finish waits for the rest of the output once the run is over, returning
+// what was captured if capture is true
+func (s *commandStreams) finish(capture bool) *CapturedOutput {
+	// the command's stderr needs to be split before what's captured from
+	// it is finished
+	if s.straceStderr != nil {
+		s.straceStderr.finish()
+	}
+	if s.pty != nil {
+		s.pty.finish()
+	}
+	if capture {
+		output := &CapturedOutput{}
+		var stdoutTruncated, stderrTruncated bool
+		output.Stdout, stdoutTruncated = s.stdoutCapture.finish()
+		output.Stderr, stderrTruncated = s.stderrCapture.finish()
+		output.Truncated = stdoutTruncated || stderrTruncated
+		return output
+	}
+	if s.stdoutCapture != nil {
+		s.stdoutCapture.finish()
+		s.stderrCapture.finish()
+	}
+	return nil
+}
+
+// close closes the log files
+func (s *commandStreams) close() {
+	for _, f := range s.logs {
+		f.Close()
+	}
+}
@@ -0,0 +1,106 @@
+/*
+ * Copyright (C) 2019 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/anonymouse64/etrace/internal/profiling"
+	"github.com/anonymouse64/etrace/internal/systemd"
+)
+
+// runUnit measures how long unit takes to start for every iteration, stopping
+// it again after each one
+func (x *runOptions) runUnit(w io.Writer, outRes *OutputResult, unit systemd.Unit) (*OutputResult, error) {
+	iterations := 1 + currentCmd.AdditionalIterations
+	for i := uint(0); i < iterations; i++ {
+		if i > 0 && x.IterationDelay > 0 {
+			time.Sleep(x.IterationDelay)
+		}
+
+		annotations := make(map[string]interface{})
+		if x.PrepareScript != "" {
+			x.runScript("prepare", x.PrepareScript, x.PrepareScriptArgs, i, iterations, annotations)
+		}
+
+		// starting a unit which is already active does nothing
+		if err := unit.Stop(); err != nil {
+			return nil, err
+		}
+
+		if !x.NoFlushCaches {
+			if err := profiling.FreeCaches(); err != nil {
+				return nil, err
+			}
+		}
+
+		start := time.Now()
+		if err := unit.Start(); err != nil {
+			logError(err)
+		}
+		wall := time.Since(start)
+
+		// systemd knows when the unit became active more precisely than the
+		// time systemctl took, which includes talking to systemd
+		startup := wall
+		timing, err := unit.Timing()
+		switch {
+		case err != nil:
+			logError(err)
+		case timing.ActiveState != "active":
+			logError(fmt.Errorf("%s is %s after starting", unit.Name, timing.ActiveState))
+		case timing.TimeToActive != 0:
+			startup = timing.TimeToActive
+		}
+
+		if err := unit.Stop(); err != nil {
+			logError(err)
+		}
+
+		if x.RestoreScript != "" {
+			x.runScript("restore", x.RestoreScript, x.RestoreScriptArgs, i, iterations, annotations)
+		}
+
+		run := Execution{
+			TimeToDisplay: startup,
+			TimeToRun:     wall,
+			Unit:          timing,
+			ExitCode:      -1,
+			Tags:          x.tags,
+			Errors:        errs,
+		}
+		if len(annotations) != 0 {
+			run.Annotations = annotations
+		}
+		outRes.Runs = append(outRes.Runs, run)
+
+		if x.plainOutput() {
+			fmt.Fprintln(w, "Total startup time:", rounded(startup))
+		}
+
+		resetErrors()
+	}
+
+	if x.Histogram && x.plainOutput() {
+		outRes.writeHistogram(w)
+	}
+
+	return outRes, nil
+}
@@ -0,0 +1,385 @@
+/*
+ * Copyright (C) 2019 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/anonymouse64/etrace/internal/files"
+	"github.com/anonymouse64/etrace/internal/perf"
+	"github.com/anonymouse64/etrace/internal/remote"
+	"github.com/anonymouse64/etrace/internal/strace"
+)
+
+// commandTrace is how the command of a single iteration is traced, or counted
+// with perf, and where that is read from once it has run
+type commandTrace struct {
+	opts strace.TraceOptions
+	iter uint
+	keep io.Writer
+
+	// the local trace is read from the fifo in the background
+	done   chan bool
+	timing *strace.ExecveTiming
+	err    error
+	fw     *os.File
+
+	remoteLog  string
+	summaryLog string
+	perfLog    string
+	// strace writes to a file instead of the fifo with --close-mode=none
+	straceFile string
+
+	// temporary files and dirs removed by cleanup
+	tmp []string
+}
+
+// traceResults are what was traced or counted while the command ran
+type traceResults struct {
+	timing      *strace.ExecveTiming
+	summary     *strace.SyscallSummary
+	counters    *perf.Counters
+	clockSkewed bool
+}
+
+// tracedCommand makes the command for the given iteration, run through strace
+// or perf unless neither is used, and starts reading the trace if it goes
+// through a fifo
+func (x *runOptions) tracedCommand(targetCmd []string, iter uint, opts strace.TraceOptions, keep io.Writer) (*exec.Cmd, *commandTrace, error) {
+	t := &commandTrace{
+		opts: opts,
+		iter: iter,
+		keep: keep,
+		done: make(chan bool, 1),
+	}
+	cmd, err := x.traceCommand(t, targetCmd)
+	if err != nil {
+		t.cleanup()
+		return nil, nil, err
+	}
+	return cmd, t, nil
+}
+
+// traceCommand makes the command, keeping in t where its trace goes
+func (x *runOptions) traceCommand(t *commandTrace, targetCmd []string) (*exec.Cmd, error) {
+	switch {
+	case !x.NoTrace && x.Remote != "":
+		// strace can't write to our fifo from another machine, so write the
+		// log to a file there and fetch it after the command exits
+		var err error
+		t.remoteLog, err = remote.TempFile(x.Remote)
+		if err != nil {
+			return nil, fmt.Errorf("cannot create strace log on remote host: %w", err)
+		}
+		return remote.Command(x.Remote, strace.RemoteTraceExecArgs(x.remoteUser, t.remoteLog, t.opts, targetCmd...)...), nil
+	case x.Remote != "":
+		return remote.Command(x.Remote, targetCmd...), nil
+	case x.StraceSummary:
+		// strace only writes the summary when the command exits, so it
+		// goes to a normal file rather than a fifo
+		f, err := ioutil.TempFile("", "strace-summary")
+		if err != nil {
+			return nil, err
+		}
+		f.Close()
+		t.summaryLog = f.Name()
+		t.tmp = append(t.tmp, t.summaryLog)
+
+		if x.TracerCmd != "" {
+			return strace.TemplateCommand(x.TracerCmd, t.summaryLog, t.opts, targetCmd...)
+		}
+		return strace.SummaryCommand(t.summaryLog, t.opts, targetCmd...)
+	case !x.NoTrace:
+		straceLog, err := x.straceLog(t)
+		if err != nil {
+			return nil, err
+		}
+		if x.TracerCmd != "" {
+			return strace.TemplateCommand(x.TracerCmd, straceLog, t.opts, targetCmd...)
+		}
+		return strace.TraceExecCommand(straceLog, t.opts, targetCmd...)
+	case x.PerfCounters:
+		// perf only writes the counts when the command exits
+		f, err := ioutil.TempFile("", "perf-stat")
+		if err != nil {
+			return nil, err
+		}
+		f.Close()
+		t.perfLog = f.Name()
+		t.tmp = append(t.tmp, t.perfLog)
+
+		return perf.StatCommand(t.perfLog, t.opts.Env, targetCmd...)
+	}
+
+	// Don't setup tracing, so just use exec.Command directly
+	// cmdArgs (and thus targetCmd) is guaranteed to be at least one
+	// element given that it is a required argument
+	prog := targetCmd[0]
+	var args []string
+	// setup args if there's more than 1
+	if len(targetCmd) > 1 {
+		args = targetCmd[1:]
+	}
+	cmd := exec.Command(prog, args...)
+	if len(t.opts.Env) != 0 {
+		cmd.Env = append(os.Environ(), t.opts.Env...)
+	}
+	return cmd, nil
+}
+
+// straceLog sets up where strace writes its log locally, reading it in the
+// background when it's a fifo
+func (x *runOptions) straceLog(t *commandTrace) (string, error) {
+	// the user's fifo is left for them to remove
+	straceLog := x.StraceFifo
+	if straceLog == "" {
+		// setup private tmp dir with strace fifo
+		straceTmp, err := ioutil.TempDir("", "exec-trace")
+		if err != nil {
+			return "", err
+		}
+		t.tmp = append(t.tmp, straceTmp)
+		if x.CloseMode == "none" {
+			// strace keeps tracing the command left running, so it
+			// writes to a file which is read once the window appeared,
+			// as it would block on a fifo no longer being read
+			t.straceFile = filepath.Join(straceTmp, "strace.log")
+			return t.straceFile, nil
+		}
+		straceLog = filepath.Join(straceTmp, "strace.fifo")
+		if err := syscall.Mkfifo(straceLog, 0640); err != nil {
+			return "", err
+		}
+	}
+
+	// ensure we have one writer on the fifo so that if strace
+	// fails nothing blocks
+	var err error
+	t.fw, err = os.OpenFile(straceLog, os.O_RDWR, 0640)
+	if err != nil {
+		return "", err
+	}
+
+	// read strace data from fifo async
+	go func() {
+		t.timing, t.err = x.readStraceLog(straceLog, t.iter, t.opts, t.keep)
+		close(t.done)
+	}()
+	return straceLog, nil
+}
+
+// cleanup closes the fifo and removes the temporary files
+func (t *commandTrace) cleanup() {
+	if t.fw != nil {
+		t.fw.Close()
+	}
+	for _, path := range t.tmp {
+		os.RemoveAll(path)
+	}
+}
+
+// readTrace reads what was traced or counted once the command has run,
+// displaying it with the plain output
+func (x *runOptions) readTrace(w io.Writer, t *commandTrace, proc *runningCommand) *traceResults {
+	res := &traceResults{}
+	if x.StraceSummary {
+		select {
+		case <-proc.exited:
+			summary, err := strace.ReadSyscallSummary(t.summaryLog)
+			if err != nil {
+				logError(fmt.Errorf("cannot read syscall summary: %w", err))
+			} else if x.plainOutput() {
+				wtab := tabWriterGeneric(w)
+				summary.Display(wtab)
+				wtab.Flush()
+			}
+			res.summary = summary
+		case <-time.After(x.ExitTimeout):
+			logError(fmt.Errorf("strace did not exit within %v to write the syscall summary", x.ExitTimeout))
+		}
+	} else if !x.NoTrace {
+		res.timing, res.clockSkewed = x.readExecveTiming(w, t, proc)
+	}
+
+	if x.PerfCounters {
+		select {
+		case <-proc.exited:
+			counters, err := perf.ReadCounters(t.perfLog)
+			if err != nil {
+				logError(fmt.Errorf("cannot read perf counters: %w", err))
+			} else if x.plainOutput() {
+				wtab := tabWriterGeneric(w)
+				counters.Display(wtab)
+				wtab.Flush()
+			}
+			res.counters = counters
+		case <-time.After(x.ExitTimeout):
+			logError(fmt.Errorf("perf did not exit within %v to write the counters", x.ExitTimeout))
+		}
+	}
+	return res
+}
+
+// readExecveTiming reads the strace log, which is nil if it can't be, and
+// whether the clock was changed while tracing
+func (x *runOptions) readExecveTiming(w io.Writer, t *commandTrace, proc *runningCommand) (*strace.ExecveTiming, bool) {
+	clockSkewed := false
+	if x.Remote != "" {
+		t.timing, t.err = x.fetchRemoteTrace(t.remoteLog, t.iter, t.opts, t.keep)
+	} else if t.straceFile != "" {
+		// strace is still tracing the command left running, so only
+		// read what it wrote so far
+		t.timing, t.err = x.readStraceLog(t.straceFile, t.iter, t.opts, t.keep)
+	} else {
+		// ensure we close the fifo here so that the
+		// strace.TraceExecCommand() helper gets a EOF from the fifo
+		// (i.e. all writers must be closed for this)
+		t.fw.Close()
+
+		// wait for strace reader
+		<-t.done
+
+		// the remote strace runs for a while longer than what can be
+		// measured here because of ssh, so this is only checked locally,
+		// against how long the command ran as strace stops with it
+		if t.err == nil {
+			select {
+			case <-proc.exited:
+				elapsed := proc.exitedAt.Sub(proc.start)
+				if clockStepped(t.timing.TotalTime, elapsed, x.ClockSkewLimit) {
+					clockSkewed = true
+					log.Printf("warning: strace measured %v while %v elapsed, the system clock may have been changed during the run and the traced timings can't be trusted", t.timing.TotalTime, elapsed)
+				}
+			case <-time.After(x.ExitTimeout):
+			}
+		}
+	}
+	if t.err != nil {
+		logError(fmt.Errorf("cannot extract runtime data: %w", t.err))
+		// don't keep a partial trace
+		return nil, false
+	}
+
+	if t.timing.SkippedLines != 0 {
+		log.Printf("warning: skipped %d strace lines in iteration %d which couldn't be parsed, the strace version may not be supported", t.timing.SkippedLines, t.iter+1)
+	}
+	// make a new tabwriter to stderr
+	if x.plainOutput() {
+		wtab := tabWriterGeneric(w)
+		t.timing.Display(wtab, x.displayOpts)
+	}
+	return t.timing, clockSkewed
+}
+
+// openSavedStraceLog creates the file to save the strace log of the given
+// iteration to with --strace-log-dir
+func (x *runOptions) openSavedStraceLog(iter uint) (io.WriteCloser, error) {
+	path := filepath.Join(x.StraceLogDir, fmt.Sprintf("%siter-%d.log", x.logPrefix, iter+1))
+	f, err := files.EnsureExistsAndOpen(x.compressedName(path), true)
+	if err != nil {
+		return nil, err
+	}
+	if x.Compress {
+		return files.NewGzipFile(f), nil
+	}
+	return f, nil
+}
+
+// readStraceLog parses the strace log from the fifo, also saving a copy of it
+// with --strace-log-dir and to keep if it isn't nil
+func (x *runOptions) readStraceLog(fifo string, iter uint, opts strace.TraceOptions, keep io.Writer) (*strace.ExecveTiming, error) {
+	if x.StraceLogDir == "" && keep == nil && x.StraceBufferSize == 0 {
+		return strace.TraceExecveTimings(fifo, -1, opts)
+	}
+
+	f, err := os.Open(fifo)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var slog io.Reader = f
+	if x.StraceBufferSize != 0 {
+		buffered := strace.NewBufferedReader(f, x.StraceBufferSize*1024*1024)
+		defer func() {
+			buffered.Close()
+			if stalls, stalled := buffered.Stalled(); stalls != 0 {
+				log.Printf("warning: parsing the trace fell behind in iteration %d, blocking strace and the command for %v, the timings may be inflated, try a bigger --strace-buffer-size", iter+1, stalled)
+			}
+		}()
+		slog = buffered
+	}
+
+	var copies []io.Writer
+	if keep != nil {
+		copies = append(copies, keep)
+	}
+	if x.StraceLogDir != "" {
+		saved, err := x.openSavedStraceLog(iter)
+		if err != nil {
+			// keep reading the fifo regardless so strace doesn't block
+			logError(fmt.Errorf("cannot save strace log: %w", err))
+		} else {
+			defer saved.Close()
+			copies = append(copies, saved)
+		}
+	}
+	if len(copies) == 0 {
+		return strace.ReadExecveTimings(slog, -1, opts)
+	}
+
+	return strace.ReadExecveTimings(io.TeeReader(slog, io.MultiWriter(copies...)), -1, opts)
+}
+
+// fetchRemoteTrace copies the strace log from the remote host and parses it
+func (x *runOptions) fetchRemoteTrace(remoteLog string, iter uint, opts strace.TraceOptions, keep io.Writer) (*strace.ExecveTiming, error) {
+	defer remote.Remove(x.Remote, remoteLog)
+
+	// the log is parsed from a local copy as the saved log may be compressed
+	f, err := ioutil.TempFile("", "exec-trace")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	copies := []io.Writer{f}
+	if x.StraceLogDir != "" {
+		saved, err := x.openSavedStraceLog(iter)
+		if err != nil {
+			return nil, err
+		}
+		defer saved.Close()
+		copies = append(copies, saved)
+	}
+	if keep != nil {
+		copies = append(copies, keep)
+	}
+	if err := remote.Fetch(x.Remote, remoteLog, io.MultiWriter(copies...)); err != nil {
+		return nil, fmt.Errorf("cannot fetch strace log from remote host: %w", err)
+	}
+	return strace.TraceExecveTimings(f.Name(), -1, opts)
+}
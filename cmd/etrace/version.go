@@ -0,0 +1,72 @@
+/*
+ * Copyright (C) 2019 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"runtime/debug"
+)
+
+// these are set at build time with:
+// go build -ldflags "-X main.version=... -X main.gitCommit=... -X main.buildDate=..."
+var (
+	version   = "unknown"
+	gitCommit = "unknown"
+	buildDate = "unknown"
+)
+
+// BuildInfo identifies the build of etrace which produced a result
+type BuildInfo struct {
+	Version   string
+	GitCommit string
+	BuildDate string
+}
+
+func currentBuildInfo() BuildInfo {
+	info := BuildInfo{
+		Version:   version,
+		GitCommit: gitCommit,
+		BuildDate: buildDate,
+	}
+	// fall back to the module version when installed with go get
+	if info.Version == "unknown" {
+		if bi, ok := debug.ReadBuildInfo(); ok && bi.Main.Version != "" && bi.Main.Version != "(devel)" {
+			info.Version = bi.Main.Version
+		}
+	}
+	return info
+}
+
+func printVersion() {
+	info := currentBuildInfo()
+	fmt.Printf("etrace %s\ncommit: %s\nbuilt: %s\n", info.Version, info.GitCommit, info.BuildDate)
+}
+
+type cmdVersion struct{}
+
+func (x *cmdVersion) Execute(args []string) error {
+	printVersion()
+	return nil
+}
+
+// versionFlag implements --version, which can be used without a command
+func versionFlag() {
+	printVersion()
+	os.Exit(0)
+}
@@ -0,0 +1,286 @@
+/*
+ * Copyright (C) 2019 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/anonymouse64/etrace/internal/dbus"
+	"github.com/anonymouse64/etrace/internal/files"
+	"github.com/anonymouse64/etrace/internal/profiling"
+	"github.com/anonymouse64/etrace/internal/xdotool"
+)
+
+// startupWait is what was found while waiting for the command to start up
+type startupWait struct {
+	wids    []string
+	windows []WindowResult
+	// daemons are the processes the command left running in the background
+	daemons        []int
+	timeToFile     time.Duration
+	overMaxStartup bool
+	// closeWindows is false if the windows can't be closed with xdotool
+	closeWindows bool
+}
+
+// windowUsage is what was read from the windows' processes before they were
+// closed
+type windowUsage struct {
+	pids                   []int
+	voluntary, involuntary int64
+	cpuTime                time.Duration
+	mappedFiles            []profiling.MappedFile
+}
+
+// waitForStartup waits until the command has started up, which is when it
+// prints the marker, creates the file, exits or shows its windows depending
+// on the options
+func (x *runOptions) waitForStartup(xtool xdotool.Xtooler, windowspec xdotool.Window, proc *runningCommand, markers *outputMarkers, fileWatch *files.FileWatch, start time.Time) *startupWait {
+	sw := &startupWait{closeWindows: true}
+	var err error
+	if markers != nil {
+		// the command is done starting up once it prints the marker,
+		// after which it's killed like when it takes too long
+		select {
+		case <-markers.readyCh:
+			x.abortCommand(proc.cmd, proc.exited)
+		case <-proc.exited:
+		}
+	} else if fileWatch != nil {
+		// the command is done starting up once it creates the file, which
+		// daemons often do after forking into the background, after which
+		// it's killed along with anything it left running
+		if appeared, err := fileWatch.Wait(windowWatchTimeout); err != nil {
+			logError(fmt.Errorf("waiting for %s: %w", x.WaitForFile, err))
+		} else {
+			sw.timeToFile = appeared.Sub(start)
+		}
+		x.abortCommand(proc.cmd, proc.exited)
+		if leftover := x.daemonizedChildren(proc.cmd); len(leftover) != 0 {
+			killPids(leftover, nil)
+			<-profiling.WaitPids(leftover)
+		}
+	} else if x.NoWindowWait {
+		// if we aren't waiting on the window class, then just wait for the
+		// command to return
+		<-proc.exited
+		// and for anything it left running in the background
+		if x.Remote == "" {
+			sw.daemons = x.daemonizedChildren(proc.cmd)
+			<-profiling.WaitPids(sw.daemons)
+		}
+	} else if x.AllWindows {
+		// wait for all of the windows to appear, noting when each does
+		var appeared []xdotool.WindowAppearance
+		watchTimeout := windowWatchTimeout
+		if x.MaxStartup != 0 && x.MaxStartup < watchTimeout {
+			watchTimeout = x.MaxStartup
+		}
+		appeared, err = xtool.WatchWindows(windowspec, x.WindowSettle, watchTimeout)
+		if x.MaxStartup != 0 && errors.Is(err, xdotool.ErrWindowTimeout) {
+			sw.overMaxStartup = true
+			sw.closeWindows = false
+		} else if err != nil {
+			logError(fmt.Errorf("waiting for windows to appear: %w", err))
+			sw.closeWindows = false
+		}
+		for _, window := range appeared {
+			sw.wids = append(sw.wids, window.ID)
+			sw.windows = append(sw.windows, WindowResult{
+				ID:            window.ID,
+				TimeToDisplay: window.Time.Sub(start),
+			})
+		}
+	} else {
+		// now wait until the window appears
+		if x.MaxStartup != 0 {
+			sw.wids, err = xtool.WaitForWindowTimeout(windowspec, x.MaxStartup)
+		} else {
+			sw.wids, err = xtool.WaitForWindow(windowspec)
+		}
+		if x.MaxStartup != 0 && errors.Is(err, xdotool.ErrWindowTimeout) {
+			sw.overMaxStartup = true
+			sw.closeWindows = false
+		} else if err != nil {
+			logError(fmt.Errorf("waiting for window appearance: %w", err))
+			// if we don't get the wid properly then we can't try closing
+			sw.closeWindows = false
+		}
+	}
+
+	if !x.NoWindowWait && x.Remote == "" {
+		sw.daemons = x.daemonizedChildren(proc.cmd)
+	}
+	return sw
+}
+
+// closeWindows reads what the windows' processes used and closes the windows
+// as set with --close-mode, killing anything which doesn't exit
+func (x *runOptions) closeWindows(xtool xdotool.Xtooler, sw *startupWait, proc *runningCommand, wmctrlCloses bool) *windowUsage {
+	usage := &windowUsage{}
+	tryWmctrl := false
+	// the windows' pids which had to be killed after closing them
+	killed := make(map[int]bool)
+	// now get the pids before closing the window so we can gracefully try
+	// closing the windows before forcibly killing them later
+	if sw.closeWindows {
+		usage.pids = make([]int, len(sw.wids))
+		for i, wid := range sw.wids {
+			pid, err := xtool.PidForWindowID(wid)
+			if err != nil {
+				logError(fmt.Errorf("getting pid for wid %s: %w", wid, err))
+				tryWmctrl = true
+				break
+			}
+			usage.pids[i] = pid
+			sw.windows[i].Pid = pid
+		}
+		usage.voluntary, usage.involuntary = windowCtxSwitches(usage.pids)
+		usage.cpuTime = windowCPUTime(usage.pids)
+		if x.MmapFaultAnalysis {
+			var err error
+			if usage.mappedFiles, err = profiling.MappedFiles(usage.pids); err != nil {
+				logError(fmt.Errorf("reading mapped files: %w", err))
+			}
+		}
+
+		// close the windows
+		for i, wid := range sw.wids {
+			var err error
+			sw.windows[i].CloseMethod, err = x.closeWindow(xtool, wid, wmctrlCloses)
+			if err != nil {
+				logError(fmt.Errorf("closing window: %w", err))
+				tryWmctrl = true
+			}
+		}
+
+		// kill the app pids in case x fails to close the window
+		if x.CloseMode == "kill" && killPids(usage.pids, killed) {
+			tryWmctrl = true
+		}
+	}
+	if x.CloseMode == "kill" && !x.NoWindowWait && len(sw.daemons) != 0 {
+		killPids(sw.daemons, nil)
+		// reap them now that they are our children
+		profiling.WaitPids(sw.daemons)
+	}
+
+	if tryWmctrl && wmctrlCloses {
+		for i, wid := range sw.wids {
+			if err := wmctrlCloseWindow(x.Display, wid); err != nil {
+				logError(fmt.Errorf("closing window with wmctrl: %w", err))
+			} else {
+				sw.windows[i].CloseMethod = "wmctrl"
+			}
+		}
+	}
+
+	if x.CloseMode == "graceful" && !x.NoWindowWait {
+		// give the app a chance to exit on its own now that its windows
+		// are closed, only killing it if it doesn't go away in time
+		allExited := make(chan struct{})
+		go func() {
+			<-proc.exited
+			<-profiling.WaitPids(sw.daemons)
+			close(allExited)
+		}()
+		select {
+		case <-allExited:
+		case <-time.After(x.ExitTimeout):
+			logError(fmt.Errorf("command did not exit within %v of closing its windows, killing it", x.ExitTimeout))
+			killPids(append(usage.pids, sw.daemons...), killed)
+			// without the windows' pids only the daemons were killed, and the
+			// trace can't be read while the command is still running
+			x.abortCommand(proc.cmd, proc.exited)
+		}
+	}
+	for i := range sw.windows {
+		sw.windows[i].Killed = sw.windows[i].Pid != 0 && killed[sw.windows[i].Pid]
+	}
+	return usage
+}
+
+// useWindow waits for the first window to become active and idle with
+// --wait-for-active and --wait-for-idle, returning how long after start each
+// took, then replays the events in it
+func (x *runOptions) useWindow(xtool xdotool.Xtooler, sw *startupWait, start time.Time) (timeToActive, timeToIdle time.Duration) {
+	if !sw.closeWindows || len(sw.wids) == 0 {
+		return 0, 0
+	}
+	if x.WaitForActive {
+		active, err := xtool.WaitForActive(sw.wids, activeWindowTimeout)
+		if err != nil {
+			logError(fmt.Errorf("waiting for the window to become active: %w", err))
+		} else {
+			timeToActive = active.Sub(start)
+		}
+	}
+
+	if x.WaitForIdle {
+		idle, err := waitForWindowIdle(xtool, sw.wids[0], x.IdleThreshold, x.IdlePeriod, x.IdleTimeout)
+		if err != nil {
+			logError(fmt.Errorf("waiting for idle: %w", err))
+		} else {
+			timeToIdle = idle.Sub(start)
+		}
+	}
+
+	if len(x.events) != 0 {
+		if err := xtool.ReplayEvents(sw.wids[0], x.events); err != nil {
+			logError(fmt.Errorf("replaying events: %w", err))
+		}
+	}
+	return timeToActive, timeToIdle
+}
+
+// dbusNameWatch looks for the D-Bus name in the background
+type dbusNameWatch struct {
+	name     string
+	appeared time.Time
+	err      error
+	done     chan struct{}
+}
+
+// watchDBusName starts looking for the name from --wait-for-dbus-name until
+// the command exits, nil without it
+func (x *runOptions) watchDBusName(exited <-chan struct{}) *dbusNameWatch {
+	if x.WaitForDBusName == "" {
+		return nil
+	}
+	d := &dbusNameWatch{
+		name: x.WaitForDBusName,
+		done: make(chan struct{}),
+	}
+	go func() {
+		d.appeared, d.err = dbus.WaitForName(x.DBusBus, d.name, windowWatchTimeout, exited)
+		close(d.done)
+	}()
+	return d
+}
+
+// wait returns how long after start the name appeared
+func (d *dbusNameWatch) wait(start time.Time) time.Duration {
+	<-d.done
+	if d.err != nil {
+		logError(fmt.Errorf("waiting for D-Bus name %s: %w", d.name, d.err))
+		return 0
+	}
+	return d.appeared.Sub(start)
+}
@@ -337,6 +337,11 @@ func (x *cmdRun) Execute(args []string) error {
 		json.NewEncoder(w).Encode(outRes)
 	} else {
 		fmt.Fprintln(w, "Total startup time:", startup)
+		if execFiles != nil {
+			wtab := tabWriterGeneric(w)
+			execFiles.DisplayReadBytes(wtab)
+			wtab.Flush()
+		}
 	}
 
 	return nil
@@ -0,0 +1,90 @@
+/*
+ * Copyright (C) 2019 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package dbus
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// how often to check whether the name has an owner
+const pollInterval = 20 * time.Millisecond
+
+// ErrTimeout is returned by WaitForName when the name didn't appear in time
+var ErrTimeout = errors.New("timed out waiting for the name to appear")
+
+var execCommandCombinedOutput = func(prog string, args ...string) ([]byte, error) {
+	return exec.Command(prog, args...).CombinedOutput()
+}
+
+// HasOwner returns whether name is currently owned by anything on the session
+// or system bus
+func HasOwner(bus, name string) (bool, error) {
+	out, err := execCommandCombinedOutput("dbus-send",
+		"--"+bus,
+		"--dest=org.freedesktop.DBus",
+		"--type=method_call",
+		"--print-reply",
+		"/org/freedesktop/DBus",
+		"org.freedesktop.DBus.NameHasOwner",
+		"string:"+name,
+	)
+	if err != nil {
+		log.Println(string(out))
+		return false, err
+	}
+	// the reply looks like:
+	// method return time=1580155329.401357 sender=org.freedesktop.DBus -> destination=:1.42 serial=3 reply_serial=2
+	//    boolean true
+	fields := strings.Fields(string(out))
+	if len(fields) < 2 || fields[len(fields)-2] != "boolean" {
+		return false, fmt.Errorf("cannot parse NameHasOwner reply %q", strings.TrimSpace(string(out)))
+	}
+	return fields[len(fields)-1] == "true", nil
+}
+
+// WaitForName waits until name is owned on the bus, returning when it was
+// first seen, giving up after timeout or once stop is closed
+func WaitForName(bus, name string, timeout time.Duration, stop <-chan struct{}) (time.Time, error) {
+	deadline := time.After(timeout)
+	for {
+		owned, err := HasOwner(bus, name)
+		now := time.Now()
+		if err != nil {
+			return time.Time{}, err
+		}
+		if owned {
+			return now, nil
+		}
+		select {
+		case <-stop:
+			// the command may have taken the name right before stopping
+			if owned, err := HasOwner(bus, name); err == nil && owned {
+				return time.Now(), nil
+			}
+			return time.Time{}, fmt.Errorf("%s was not acquired before the command exited", name)
+		case <-deadline:
+			return time.Time{}, ErrTimeout
+		case <-time.After(pollInterval):
+		}
+	}
+}
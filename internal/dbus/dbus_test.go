@@ -0,0 +1,67 @@
+/*
+ * Copyright (C) 2019 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package dbus_test
+
+import (
+	"fmt"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/anonymouse64/etrace/internal/dbus"
+
+	"gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) { check.TestingT(t) }
+
+type dbusTestSuite struct{}
+
+var _ = check.Suite(&dbusTestSuite{})
+
+const reply = `method return time=1580155329.401357 sender=org.freedesktop.DBus -> destination=:1.42 serial=3 reply_serial=2
+   boolean %s
+`
+
+func (s *dbusTestSuite) TestWaitForName(c *check.C) {
+	calls := 0
+	restore := dbus.MockExecCommand(func(prog string, args ...string) ([]byte, error) {
+		c.Check(prog, check.Equals, "dbus-send")
+		c.Check(args[0], check.Equals, "--session")
+		c.Check(args[len(args)-1], check.Equals, "string:org.gnome.Calculator")
+		calls++
+		return []byte(fmt.Sprintf(reply, strconv.FormatBool(calls == 3))), nil
+	})
+	defer restore()
+
+	start := time.Now()
+	appeared, err := dbus.WaitForName("session", "org.gnome.Calculator", time.Minute, nil)
+	c.Assert(err, check.IsNil)
+	c.Check(calls, check.Equals, 3)
+	c.Check(appeared.After(start), check.Equals, true)
+}
+
+func (s *dbusTestSuite) TestWaitForNameTimeout(c *check.C) {
+	restore := dbus.MockExecCommand(func(prog string, args ...string) ([]byte, error) {
+		return []byte(fmt.Sprintf(reply, "false")), nil
+	})
+	defer restore()
+
+	_, err := dbus.WaitForName("system", "org.example.Missing", 50*time.Millisecond, nil)
+	c.Check(err, check.Equals, dbus.ErrTimeout)
+}
@@ -0,0 +1,112 @@
+/*
+ * Copyright (C) 2019 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package desktop
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// dirs are where the .desktop files of installed apps are, along with
+// $XDG_DATA_HOME and $XDG_DATA_DIRS
+var dirs = []string{
+	"/var/lib/snapd/desktop/applications",
+	"/var/lib/flatpak/exports/share/applications",
+}
+
+func searchDirs() []string {
+	dataHome := os.Getenv("XDG_DATA_HOME")
+	if dataHome == "" {
+		dataHome = filepath.Join(os.Getenv("HOME"), ".local", "share")
+	}
+	dataDirs := os.Getenv("XDG_DATA_DIRS")
+	if dataDirs == "" {
+		dataDirs = "/usr/local/share:/usr/share"
+	}
+
+	var search []string
+	for _, dir := range append([]string{dataHome}, filepath.SplitList(dataDirs)...) {
+		search = append(search, filepath.Join(dir, "applications"))
+	}
+	return append(search, dirs...)
+}
+
+// Find returns the .desktop file of the app, which is either named after the
+// app, like flatpak app IDs, or after the snap and its app, like
+// chromium_chromium.desktop
+func Find(app string) (string, error) {
+	for _, dir := range searchDirs() {
+		for _, pattern := range []string{app + ".desktop", app + "_*.desktop"} {
+			matches, err := filepath.Glob(filepath.Join(dir, pattern))
+			if err != nil {
+				return "", err
+			}
+			if len(matches) != 0 {
+				return matches[0], nil
+			}
+		}
+	}
+	return "", fmt.Errorf("cannot find a .desktop file for %s", app)
+}
+
+// StartupWMClass returns the window class the app's windows have according to
+// its .desktop file
+func StartupWMClass(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	class, err := parseStartupWMClass(f)
+	if err != nil {
+		return "", fmt.Errorf("cannot read %s: %w", path, err)
+	}
+	return class, nil
+}
+
+// the key can be in any group but only the main group applies to the app's
+// windows, like:
+// [Desktop Entry]
+// Name=Calculator
+// StartupWMClass=gnome-calculator
+func parseStartupWMClass(r io.Reader) (string, error) {
+	group := ""
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			group = line
+			continue
+		}
+		if group != "[Desktop Entry]" {
+			continue
+		}
+		kv := strings.SplitN(line, "=", 2)
+		if len(kv) == 2 && strings.TrimSpace(kv[0]) == "StartupWMClass" {
+			return strings.TrimSpace(kv[1]), nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return "", err
+	}
+	return "", fmt.Errorf("no StartupWMClass in the Desktop Entry group")
+}
@@ -0,0 +1,76 @@
+/*
+ * Copyright (C) 2019 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package desktop_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/anonymouse64/etrace/internal/desktop"
+
+	"gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) { check.TestingT(t) }
+
+type desktopTestSuite struct{}
+
+var _ = check.Suite(&desktopTestSuite{})
+
+const sampleDesktop = `[Desktop Entry]
+Name=Chromium
+Exec=chromium %U
+StartupWMClass=chromium-browser
+
+[Desktop Action NewWindow]
+StartupWMClass=other
+`
+
+func (s *desktopTestSuite) TestParseStartupWMClass(c *check.C) {
+	class, err := desktop.ParseStartupWMClass(strings.NewReader(sampleDesktop))
+	c.Assert(err, check.IsNil)
+	c.Check(class, check.Equals, "chromium-browser")
+
+	_, err = desktop.ParseStartupWMClass(strings.NewReader("[Desktop Action NewWindow]\nStartupWMClass=other\n"))
+	c.Check(err, check.ErrorMatches, "no StartupWMClass in the Desktop Entry group")
+}
+
+func (s *desktopTestSuite) TestFindSnap(c *check.C) {
+	dir := c.MkDir()
+	restore := desktop.MockDirs([]string{dir})
+	defer restore()
+	os.Setenv("XDG_DATA_HOME", c.MkDir())
+	defer os.Unsetenv("XDG_DATA_HOME")
+
+	path := filepath.Join(dir, "chromium_chromium.desktop")
+	c.Assert(ioutil.WriteFile(path, []byte(sampleDesktop), 0644), check.IsNil)
+
+	found, err := desktop.Find("chromium")
+	c.Assert(err, check.IsNil)
+	c.Check(found, check.Equals, path)
+
+	class, err := desktop.StartupWMClass(found)
+	c.Assert(err, check.IsNil)
+	c.Check(class, check.Equals, "chromium-browser")
+
+	_, err = desktop.Find("missing")
+	c.Check(err, check.ErrorMatches, "cannot find a .desktop file for missing")
+}
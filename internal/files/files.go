@@ -17,7 +17,15 @@
 
 package files
 
-import "os"
+import (
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+var osRename = os.Rename
 
 func fileExistsQ(fname string) bool {
 	info, err := os.Stat(fname)
@@ -59,3 +67,100 @@ func EnsureFileIsDeleted(fname string) error {
 	}
 	return nil
 }
+
+// AtomicFile is a file which is written to a temporary file next to it, which
+// is only renamed into place when committed, so that the file is never seen
+// partially written
+type AtomicFile struct {
+	*os.File
+	target string
+	// gz compresses everything written when not nil
+	gz *gzip.Writer
+}
+
+// NewAtomicFile returns an AtomicFile which will replace fname when committed
+func NewAtomicFile(fname string) (*AtomicFile, error) {
+	// the temporary file must be in the same directory for the rename to be
+	// atomic
+	f, err := ioutil.TempFile(filepath.Dir(fname), "."+filepath.Base(fname)+".")
+	if err != nil {
+		return nil, err
+	}
+	// ioutil.TempFile creates the file only readable by us, use the same mode
+	// as os.Create would
+	if err := f.Chmod(0644); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return nil, err
+	}
+	return &AtomicFile{File: f, target: fname}, nil
+}
+
+// NewGzipAtomicFile is NewAtomicFile with everything written to the file gzip
+// compressed
+func NewGzipAtomicFile(fname string) (*AtomicFile, error) {
+	f, err := NewAtomicFile(fname)
+	if err != nil {
+		return nil, err
+	}
+	f.gz = gzip.NewWriter(f.File)
+	return f, nil
+}
+
+// Write implements io.Writer, compressing p if the file is gzipped
+func (f *AtomicFile) Write(p []byte) (int, error) {
+	if f.gz != nil {
+		return f.gz.Write(p)
+	}
+	return f.File.Write(p)
+}
+
+// Commit closes the file and moves it into place, replacing any existing file
+func (f *AtomicFile) Commit() error {
+	if f.gz != nil {
+		if err := f.gz.Close(); err != nil {
+			f.Cancel()
+			return err
+		}
+	}
+	if err := f.File.Sync(); err != nil {
+		f.Cancel()
+		return err
+	}
+	if err := f.File.Close(); err != nil {
+		os.Remove(f.File.Name())
+		return err
+	}
+	if err := osRename(f.File.Name(), f.target); err != nil {
+		os.Remove(f.File.Name())
+		return err
+	}
+	return nil
+}
+
+// Cancel closes and removes the temporary file, leaving any existing file
+// untouched
+func (f *AtomicFile) Cancel() error {
+	f.File.Close()
+	return os.Remove(f.File.Name())
+}
+
+type gzipFile struct {
+	*gzip.Writer
+	f *os.File
+}
+
+// Close flushes the compressed data and closes the file
+func (g *gzipFile) Close() error {
+	if err := g.Writer.Close(); err != nil {
+		g.f.Close()
+		return err
+	}
+	return g.f.Close()
+}
+
+// NewGzipFile returns a writer which gzip compresses everything written to f,
+// closing f when it's closed
+func NewGzipFile(f *os.File) io.WriteCloser {
+	return &gzipFile{Writer: gzip.NewWriter(f), f: f}
+}
@@ -0,0 +1,148 @@
+/*
+ * Copyright (C) 2019 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package files_test
+
+import (
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/anonymouse64/etrace/internal/files"
+
+	"gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) { check.TestingT(t) }
+
+type filesTestSuite struct {
+	dir string
+}
+
+var _ = check.Suite(&filesTestSuite{})
+
+func (s *filesTestSuite) SetUpTest(c *check.C) {
+	s.dir = c.MkDir()
+}
+
+func (s *filesTestSuite) dirContents(c *check.C) []string {
+	infos, err := ioutil.ReadDir(s.dir)
+	c.Assert(err, check.IsNil)
+	var names []string
+	for _, info := range infos {
+		names = append(names, info.Name())
+	}
+	return names
+}
+
+func (s *filesTestSuite) TestAtomicFileCommit(c *check.C) {
+	target := filepath.Join(s.dir, "out.json")
+	c.Assert(ioutil.WriteFile(target, []byte("old"), 0644), check.IsNil)
+
+	f, err := files.NewAtomicFile(target)
+	c.Assert(err, check.IsNil)
+	fmt.Fprint(f, "new")
+
+	// not replaced until committed
+	b, err := ioutil.ReadFile(target)
+	c.Assert(err, check.IsNil)
+	c.Check(string(b), check.Equals, "old")
+
+	c.Assert(f.Commit(), check.IsNil)
+	b, err = ioutil.ReadFile(target)
+	c.Assert(err, check.IsNil)
+	c.Check(string(b), check.Equals, "new")
+	c.Check(s.dirContents(c), check.DeepEquals, []string{"out.json"})
+
+	info, err := os.Stat(target)
+	c.Assert(err, check.IsNil)
+	c.Check(info.Mode().Perm(), check.Equals, os.FileMode(0644))
+}
+
+func (s *filesTestSuite) TestAtomicFileCancel(c *check.C) {
+	target := filepath.Join(s.dir, "out.json")
+	c.Assert(ioutil.WriteFile(target, []byte("old"), 0644), check.IsNil)
+
+	f, err := files.NewAtomicFile(target)
+	c.Assert(err, check.IsNil)
+	fmt.Fprint(f, "partial")
+	c.Assert(f.Cancel(), check.IsNil)
+
+	b, err := ioutil.ReadFile(target)
+	c.Assert(err, check.IsNil)
+	c.Check(string(b), check.Equals, "old")
+	c.Check(s.dirContents(c), check.DeepEquals, []string{"out.json"})
+}
+
+func (s *filesTestSuite) TestAtomicFileCommitRenameFails(c *check.C) {
+	restore := files.MockOsRename(func(string, string) error {
+		return errors.New("rename failed")
+	})
+	defer restore()
+
+	target := filepath.Join(s.dir, "out.json")
+	f, err := files.NewAtomicFile(target)
+	c.Assert(err, check.IsNil)
+	fmt.Fprint(f, "new")
+	c.Assert(f.Commit(), check.ErrorMatches, "rename failed")
+
+	// the temporary file is cleaned up and nothing is left half written
+	c.Check(s.dirContents(c), check.HasLen, 0)
+}
+
+func (s *filesTestSuite) TestGzipAtomicFile(c *check.C) {
+	target := filepath.Join(s.dir, "out.json.gz")
+	f, err := files.NewGzipAtomicFile(target)
+	c.Assert(err, check.IsNil)
+	fmt.Fprint(f, "compressed")
+	c.Assert(f.Commit(), check.IsNil)
+
+	r, err := os.Open(target)
+	c.Assert(err, check.IsNil)
+	defer r.Close()
+	gz, err := gzip.NewReader(r)
+	c.Assert(err, check.IsNil)
+	b, err := ioutil.ReadAll(gz)
+	c.Assert(err, check.IsNil)
+	c.Check(string(b), check.Equals, "compressed")
+}
+
+func (s *filesTestSuite) TestFileWatch(c *check.C) {
+	path := filepath.Join(s.dir, "ready")
+	fw := files.WatchFile(path)
+	_, err := fw.Wait(30 * time.Millisecond)
+	c.Check(err, check.Equals, files.ErrWatchTimeout)
+
+	c.Assert(ioutil.WriteFile(path, nil, 0644), check.IsNil)
+	_, err = fw.Wait(time.Second)
+	c.Check(err, check.IsNil)
+
+	// a file left over from before only counts once it's replaced
+	fw = files.WatchFile(path)
+	_, err = fw.Wait(30 * time.Millisecond)
+	c.Check(err, check.Equals, files.ErrWatchTimeout)
+	c.Assert(os.Remove(path), check.IsNil)
+	c.Assert(ioutil.WriteFile(path+".new", nil, 0644), check.IsNil)
+	c.Assert(os.Rename(path+".new", path), check.IsNil)
+	_, err = fw.Wait(time.Second)
+	c.Check(err, check.IsNil)
+}
@@ -0,0 +1,75 @@
+/*
+ * Copyright (C) 2019 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package files
+
+import (
+	"errors"
+	"os"
+	"time"
+)
+
+// how often a watched file is checked
+const watchInterval = 10 * time.Millisecond
+
+// ErrWatchTimeout is returned by FileWatch.Wait when the file didn't appear in
+// time
+var ErrWatchTimeout = errors.New("timed out waiting for the file to appear")
+
+// FileWatch waits for a file to be created, or replaced or modified if it
+// already exists, such as a stale pid file left by a previous run
+type FileWatch struct {
+	path   string
+	before os.FileInfo
+}
+
+// WatchFile starts watching path, which must be done before whatever creates
+// it is started
+func WatchFile(path string) *FileWatch {
+	before, err := os.Stat(path)
+	if err != nil {
+		before = nil
+	}
+	return &FileWatch{path: path, before: before}
+}
+
+// changed returns whether the file is there now and different to before
+func (fw *FileWatch) changed() bool {
+	now, err := os.Stat(fw.path)
+	if err != nil {
+		return false
+	}
+	if fw.before == nil {
+		return true
+	}
+	return !os.SameFile(fw.before, now) || !now.ModTime().Equal(fw.before.ModTime())
+}
+
+// Wait polls for the file to appear, returning when it was first seen
+func (fw *FileWatch) Wait(timeout time.Duration) (time.Time, error) {
+	deadline := time.Now().Add(timeout)
+	for {
+		now := time.Now()
+		if fw.changed() {
+			return now, nil
+		}
+		if now.After(deadline) {
+			return time.Time{}, ErrWatchTimeout
+		}
+		time.Sleep(watchInterval)
+	}
+}
@@ -0,0 +1,102 @@
+/*
+ * Copyright (C) 2019 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package flatpak
+
+import (
+	"fmt"
+	"log"
+	"os/exec"
+	"strings"
+)
+
+var execCommandCombinedOutput = func(prog string, args ...string) ([]byte, error) {
+	return exec.Command(prog, args...).CombinedOutput()
+}
+
+// Info is the version information of an installed flatpak
+type Info struct {
+	ID      string
+	Version string
+	Branch  string
+	Commit  string
+}
+
+// AppInfo returns the version, branch and commit of an installed flatpak as
+// reported by flatpak info
+func AppInfo(appID string) (*Info, error) {
+	out, err := execCommandCombinedOutput("flatpak", "info", appID)
+	if err != nil {
+		log.Println(string(out))
+		return nil, err
+	}
+	return parseFlatpakInfo(appID, string(out))
+}
+
+// output is a title line followed by right aligned keys, like:
+// ID: org.gnome.Calculator
+// Ref: app/org.gnome.Calculator/x86_64/stable
+// Arch: x86_64
+// Branch: stable
+// Version: 45.0.2
+// Commit: 0d5b4b3c6c1ab5f2a0c2b9c8e3f7a5d3e1b2c4d5e6f708192a3b4c5d6e7f8091
+func parseFlatpakInfo(appID, out string) (*Info, error) {
+	info := &Info{}
+	for _, line := range strings.Split(out, "\n") {
+		kv := strings.SplitN(line, ":", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		value := strings.TrimSpace(kv[1])
+		switch strings.TrimSpace(kv[0]) {
+		case "ID":
+			info.ID = value
+		case "Version":
+			info.Version = value
+		case "Branch":
+			info.Branch = value
+		case "Commit":
+			info.Commit = value
+		}
+	}
+	if info.ID == "" {
+		return nil, fmt.Errorf("flatpak %s not found in flatpak info output", appID)
+	}
+	return info, nil
+}
+
+// KillInstances stops any running instances of a flatpak so that the next run
+// has to set up its sandbox again, like discarding a snap's namespace
+func KillInstances(appID string) error {
+	out, err := execCommandCombinedOutput("flatpak", "ps", "--columns=instance,application")
+	if err != nil {
+		log.Println(string(out))
+		return err
+	}
+	for _, line := range strings.Split(strings.TrimSpace(string(out)), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 2 || fields[1] != appID {
+			continue
+		}
+		out, err := execCommandCombinedOutput("flatpak", "kill", fields[0])
+		if err != nil {
+			log.Println(string(out))
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,135 @@
+/*
+ * Copyright (C) 2019 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package flatpak_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/anonymouse64/etrace/internal/flatpak"
+
+	"gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) { check.TestingT(t) }
+
+type flatpakTestSuite struct{}
+
+var _ = check.Suite(&flatpakTestSuite{})
+
+const sampleInfo = `
+GNOME Calculator - Perform arithmetic, scientific or financial calculations
+
+          ID: org.gnome.Calculator
+         Ref: app/org.gnome.Calculator/x86_64/stable
+        Arch: x86_64
+      Branch: stable
+     Version: 45.0.2
+      Origin: flathub
+      Commit: 0d5b4b3c6c1ab5f2a0c2b9c8e3f7a5d3e1b2c4d5e6f708192a3b4c5d6e7f8091
+`
+
+func (s *flatpakTestSuite) TestParseFlatpakInfo(c *check.C) {
+	info, err := flatpak.ParseFlatpakInfo("org.gnome.Calculator", sampleInfo)
+	c.Assert(err, check.IsNil)
+	c.Check(info, check.DeepEquals, &flatpak.Info{
+		ID:      "org.gnome.Calculator",
+		Version: "45.0.2",
+		Branch:  "stable",
+		Commit:  "0d5b4b3c6c1ab5f2a0c2b9c8e3f7a5d3e1b2c4d5e6f708192a3b4c5d6e7f8091",
+	})
+
+	_, err = flatpak.ParseFlatpakInfo("org.gnome.Calculator", "error: something went wrong\n")
+	c.Check(err, check.ErrorMatches, "flatpak org.gnome.Calculator not found in flatpak info output")
+}
+
+func (s *flatpakTestSuite) TestAppInfo(c *check.C) {
+	var calls [][]string
+	restore := flatpak.MockExecCommand(func(prog string, args ...string) ([]byte, error) {
+		calls = append(calls, append([]string{prog}, args...))
+		return []byte(sampleInfo), nil
+	})
+	defer restore()
+
+	info, err := flatpak.AppInfo("org.gnome.Calculator")
+	c.Assert(err, check.IsNil)
+	c.Check(info.Version, check.Equals, "45.0.2")
+	c.Check(calls, check.DeepEquals, [][]string{
+		{"flatpak", "info", "org.gnome.Calculator"},
+	})
+}
+
+func (s *flatpakTestSuite) TestAppInfoNotInstalled(c *check.C) {
+	restore := flatpak.MockExecCommand(func(prog string, args ...string) ([]byte, error) {
+		return []byte("error: org.gnome.Calculator/*unspecified*/*unspecified* not installed\n"), errors.New("exit status 1")
+	})
+	defer restore()
+
+	info, err := flatpak.AppInfo("org.gnome.Calculator")
+	c.Check(err, check.ErrorMatches, "exit status 1")
+	c.Check(info, check.IsNil)
+}
+
+func (s *flatpakTestSuite) TestKillInstances(c *check.C) {
+	var calls [][]string
+	restore := flatpak.MockExecCommand(func(prog string, args ...string) ([]byte, error) {
+		calls = append(calls, append([]string{prog}, args...))
+		if args[0] == "ps" {
+			return []byte(`1234567890	org.gnome.Calculator
+2345678901	org.gnome.Calculator.Devel
+3456789012	org.gnome.Calculator
+`), nil
+		}
+		return nil, nil
+	})
+	defer restore()
+
+	c.Assert(flatpak.KillInstances("org.gnome.Calculator"), check.IsNil)
+	// only the instances of that exact app are killed
+	c.Check(calls, check.DeepEquals, [][]string{
+		{"flatpak", "ps", "--columns=instance,application"},
+		{"flatpak", "kill", "1234567890"},
+		{"flatpak", "kill", "3456789012"},
+	})
+}
+
+func (s *flatpakTestSuite) TestKillInstancesNoneRunning(c *check.C) {
+	var calls [][]string
+	restore := flatpak.MockExecCommand(func(prog string, args ...string) ([]byte, error) {
+		calls = append(calls, append([]string{prog}, args...))
+		return nil, nil
+	})
+	defer restore()
+
+	c.Assert(flatpak.KillInstances("org.gnome.Calculator"), check.IsNil)
+	c.Check(calls, check.DeepEquals, [][]string{
+		{"flatpak", "ps", "--columns=instance,application"},
+	})
+}
+
+func (s *flatpakTestSuite) TestKillInstancesError(c *check.C) {
+	restore := flatpak.MockExecCommand(func(prog string, args ...string) ([]byte, error) {
+		if args[0] == "ps" {
+			return []byte("1234567890	org.gnome.Calculator\n"), nil
+		}
+		return []byte("error: no such instance\n"), errors.New("exit status 1")
+	})
+	defer restore()
+
+	c.Check(flatpak.KillInstances("org.gnome.Calculator"), check.ErrorMatches, "exit status 1")
+}
@@ -0,0 +1,163 @@
+/*
+ * Copyright (C) 2019 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package perf
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// Events are the hardware events which are counted
+var Events = []string{
+	"instructions",
+	"cycles",
+	"cache-references",
+	"cache-misses",
+	"branches",
+	"branch-misses",
+}
+
+// Counters are the hardware performance counters of a command
+type Counters struct {
+	Instructions    int64
+	Cycles          int64
+	CacheReferences int64
+	CacheMisses     int64
+	Branches        int64
+	BranchMisses    int64
+	// NotCounted are the events which couldn't be counted, i.e. in virtual
+	// machines without access to the CPU's performance monitoring unit
+	NotCounted []string
+}
+
+// StatCommand returns an exec.Cmd which counts the hardware events of the
+// command, writing perf's counts to outputPath when the command exits
+func StatCommand(outputPath string, env []string, origCmd ...string) (*exec.Cmd, error) {
+	perfPath, err := exec.LookPath("perf")
+	if err != nil {
+		return nil, fmt.Errorf("cannot find an installed perf, please install it (i.e. apt install linux-tools-generic)")
+	}
+	args := []string{
+		perfPath,
+		"stat",
+		// machine readable output, separated by commas
+		"-x", ",",
+		"-o", outputPath,
+		"-e", strings.Join(Events, ","),
+		"--",
+	}
+	cmd := &exec.Cmd{
+		Path: perfPath,
+		Args: append(args, origCmd...),
+	}
+	if len(env) != 0 {
+		cmd.Env = append(os.Environ(), env...)
+	}
+	return cmd, nil
+}
+
+// ReadCounters parses the counts written by perf stat at path
+func ReadCounters(path string) (*Counters, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ParseCounters(f)
+}
+
+// ParseCounters parses the counts written by perf stat -x, which has a line
+// per event with the count first and the event name third, like:
+//
+//	1234567,,instructions:u,1000000,100.00,0.85,insn per cycle
+//	<not supported>,,cache-misses:u,0,100.00,,
+func ParseCounters(r io.Reader) (*Counters, error) {
+	counters := &Counters{}
+	fields := map[string]*int64{
+		"instructions":     &counters.Instructions,
+		"cycles":           &counters.Cycles,
+		"cache-references": &counters.CacheReferences,
+		"cache-misses":     &counters.CacheMisses,
+		"branches":         &counters.Branches,
+		"branch-misses":    &counters.BranchMisses,
+	}
+	found := false
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		cols := strings.Split(line, ",")
+		if len(cols) < 3 {
+			return nil, fmt.Errorf("cannot parse perf stat line %q", line)
+		}
+		// drop modifiers such as :u for only counting in user space
+		event := strings.SplitN(cols[2], ":", 2)[0]
+		field, ok := fields[event]
+		if !ok {
+			continue
+		}
+		found = true
+		if strings.HasPrefix(cols[0], "<") {
+			counters.NotCounted = append(counters.NotCounted, event)
+			continue
+		}
+		// counts are integers but may be printed with a fraction when scaled
+		count, err := strconv.ParseFloat(cols[0], 64)
+		if err != nil {
+			return nil, fmt.Errorf("cannot parse perf stat line %q: %w", line, err)
+		}
+		*field = int64(count)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, fmt.Errorf("cannot find any counted events")
+	}
+	return counters, nil
+}
+
+func percent(n, total int64) float64 {
+	if total == 0 {
+		return 0
+	}
+	return 100 * float64(n) / float64(total)
+}
+
+// Display shows the counts along with the rates derived from them
+func (c *Counters) Display(w io.Writer) {
+	fmt.Fprintf(w, "Hardware performance counters:\n")
+	ipc := 0.0
+	if c.Cycles != 0 {
+		ipc = float64(c.Instructions) / float64(c.Cycles)
+	}
+	fmt.Fprintf(w, "\tInstructions\t%d\t%.2f per cycle\n", c.Instructions, ipc)
+	fmt.Fprintf(w, "\tCycles\t%d\t\n", c.Cycles)
+	fmt.Fprintf(w, "\tCache misses\t%d\t%.2f%% of references\n", c.CacheMisses, percent(c.CacheMisses, c.CacheReferences))
+	fmt.Fprintf(w, "\tBranch misses\t%d\t%.2f%% of branches\n", c.BranchMisses, percent(c.BranchMisses, c.Branches))
+	if len(c.NotCounted) != 0 {
+		fmt.Fprintf(w, "\tNot counted\t%s\t\n", strings.Join(c.NotCounted, ", "))
+	}
+}
@@ -0,0 +1,61 @@
+/*
+ * Copyright (C) 2019 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package perf_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/anonymouse64/etrace/internal/perf"
+
+	"gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) { check.TestingT(t) }
+
+type perfTestSuite struct{}
+
+var _ = check.Suite(&perfTestSuite{})
+
+const sampleStat = `# started on Tue Jan 28 10:15:29 2020
+
+1234567,,instructions:u,1000000,100.00,0.82,insn per cycle
+1500000,,cycles:u,1000000,100.00,,
+20000,,cache-references:u,1000000,100.00,,
+<not supported>,,cache-misses:u,0,100.00,,
+300000,,branches:u,1000000,100.00,,
+1500.00,,branch-misses:u,1000000,100.00,0.50,of all branches
+`
+
+func (s *perfTestSuite) TestParseCounters(c *check.C) {
+	counters, err := perf.ParseCounters(strings.NewReader(sampleStat))
+	c.Assert(err, check.IsNil)
+	c.Check(counters, check.DeepEquals, &perf.Counters{
+		Instructions:    1234567,
+		Cycles:          1500000,
+		CacheReferences: 20000,
+		Branches:        300000,
+		BranchMisses:    1500,
+		NotCounted:      []string{"cache-misses"},
+	})
+}
+
+func (s *perfTestSuite) TestParseCountersMissing(c *check.C) {
+	_, err := perf.ParseCounters(strings.NewReader("# started on Tue Jan 28 10:15:29 2020\n"))
+	c.Check(err, check.ErrorMatches, "cannot find any counted events")
+}
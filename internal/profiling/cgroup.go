@@ -0,0 +1,184 @@
+/*
+ * Copyright (C) 2019 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package profiling
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// where the cgroup v2 hierarchy is mounted
+var cgroupRoot = "/sys/fs/cgroup"
+
+// MemoryCgroup is a cgroup v2 limiting how much memory the processes in it can
+// use. Like FreeCaches, setting it up is done with sudo.
+type MemoryCgroup struct {
+	path string
+	// release lets the wrapped command continue once it is in the cgroup,
+	// which waits on the other end of the pipe
+	release, wait *os.File
+}
+
+// memoryLimitRE matches the limits memory.max accepts: a number of bytes with
+// an optional K, M or G suffix, or max for no limit
+var memoryLimitRE = regexp.MustCompile(`^([0-9]+[KMGkmg]?|max)$`)
+
+// ValidateMemoryLimit returns an error if limit can't be given to
+// NewMemoryCgroup
+func ValidateMemoryLimit(limit string) error {
+	if !memoryLimitRE.MatchString(limit) {
+		return fmt.Errorf("invalid memory limit %q, it must be a number of bytes with an optional K, M or G suffix, or max", limit)
+	}
+	return nil
+}
+
+// NewMemoryCgroup creates a cgroup with the given name limited to limit, which
+// can have a K, M or G suffix
+func NewMemoryCgroup(name, limit string) (*MemoryCgroup, error) {
+	if err := ValidateMemoryLimit(limit); err != nil {
+		return nil, err
+	}
+	path := filepath.Join(cgroupRoot, name)
+	if out, err := execCommandCombinedOutput("sudo", "mkdir", path); err != nil {
+		return nil, fmt.Errorf("cannot create cgroup: %s: %w", strings.TrimSpace(string(out)), err)
+	}
+	c := &MemoryCgroup{path: path}
+	if err := c.write("memory.max", limit); err != nil {
+		c.Remove()
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *MemoryCgroup) write(file, value string) error {
+	// sudo can't redirect, so tee writes the value as root
+	if out, err := execCommandWithInput(value+"\n", "sudo", "tee", filepath.Join(c.path, file)); err != nil {
+		return fmt.Errorf("cannot write %s of cgroup: %s: %w", file, strings.TrimSpace(string(out)), err)
+	}
+	return nil
+}
+
+// Wrap makes cmd wait until Start has been called before executing, so that
+// it can be moved into the cgroup first and the limit applies to everything it
+// does. When cmd is a tracer running the command, such as sudo and strace, the
+// tracer is in the cgroup too and its memory counts against the limit. Start
+// must be called after cmd was started.
+func (c *MemoryCgroup) Wrap(cmd *exec.Cmd) error {
+	sh, err := exec.LookPath("sh")
+	if err != nil {
+		return err
+	}
+	r, w, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	c.release, c.wait = w, r
+	cmd.ExtraFiles = append(cmd.ExtraFiles, r)
+	fd := 2 + len(cmd.ExtraFiles)
+	script := fmt.Sprintf(`read _ <&%[1]d; exec %[1]d<&-; exec "$@"`, fd)
+	cmd.Args = append([]string{"sh", "-c", script, "sh", cmd.Path}, cmd.Args[1:]...)
+	cmd.Path = sh
+	return nil
+}
+
+// Start moves the wrapped command with the given pid into the cgroup and lets
+// it continue. The command continues even if it couldn't be moved.
+func (c *MemoryCgroup) Start(pid int) error {
+	c.wait.Close()
+	defer c.release.Close()
+	if err := c.write("cgroup.procs", strconv.Itoa(pid)); err != nil {
+		return err
+	}
+	_, err := c.release.Write([]byte("\n"))
+	return err
+}
+
+// MemoryEvents is what happened in a memory cgroup because of its limit
+type MemoryEvents struct {
+	// OOMKilled is whether any process was killed for running out of memory
+	OOMKilled bool
+	// Reclaims is how many times memory had to be reclaimed because the
+	// limit was reached
+	Reclaims int
+	// Stalls is how long processes were stalled waiting on memory
+	Stalls time.Duration
+}
+
+// Events returns the memory events of the cgroup so far
+func (c *MemoryCgroup) Events() (*MemoryEvents, error) {
+	b, err := ioutil.ReadFile(filepath.Join(c.path, "memory.events"))
+	if err != nil {
+		return nil, err
+	}
+	events := &MemoryEvents{}
+	for _, line := range strings.Split(string(b), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			continue
+		}
+		n, err := strconv.Atoi(fields[1])
+		if err != nil {
+			return nil, fmt.Errorf("cannot parse memory.events: %w", err)
+		}
+		switch fields[0] {
+		case "max":
+			events.Reclaims = n
+		case "oom_kill":
+			events.OOMKilled = n != 0
+		}
+	}
+
+	// memory.pressure only exists with CONFIG_PSI
+	b, err = ioutil.ReadFile(filepath.Join(c.path, "memory.pressure"))
+	if os.IsNotExist(err) {
+		return events, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	// lines look like:
+	// some avg10=0.00 avg60=0.00 avg300=0.00 total=1234
+	for _, line := range strings.Split(string(b), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 5 || fields[0] != "some" || !strings.HasPrefix(fields[4], "total=") {
+			continue
+		}
+		us, err := strconv.ParseInt(strings.TrimPrefix(fields[4], "total="), 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("cannot parse memory.pressure: %w", err)
+		}
+		events.Stalls = time.Duration(us) * time.Microsecond
+	}
+	return events, nil
+}
+
+// Remove removes the cgroup, which only works once all the processes in it
+// have exited
+func (c *MemoryCgroup) Remove() error {
+	if out, err := execCommandCombinedOutput("sudo", "rmdir", c.path); err != nil {
+		return fmt.Errorf("cannot remove cgroup: %s: %w", strings.TrimSpace(string(out)), err)
+	}
+	return nil
+}
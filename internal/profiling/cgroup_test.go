@@ -0,0 +1,85 @@
+/*
+ * Copyright (C) 2019 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package profiling_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/anonymouse64/etrace/internal/profiling"
+
+	"gopkg.in/check.v1"
+)
+
+type cgroupTestSuite struct{}
+
+var _ = check.Suite(&cgroupTestSuite{})
+
+func (s *cgroupTestSuite) TestMemoryCgroup(c *check.C) {
+	root := c.MkDir()
+	defer profiling.MockCgroupRoot(root)()
+	var calls [][]string
+	defer profiling.MockExecCommand(func(exec string, args ...string) ([]byte, error) {
+		calls = append(calls, append([]string{exec}, args...))
+		if len(args) == 2 && args[0] == "mkdir" {
+			return nil, os.Mkdir(args[1], 0755)
+		}
+		return nil, nil
+	})()
+	defer profiling.MockExecCommandWithInput(func(input, exec string, args ...string) ([]byte, error) {
+		calls = append(calls, append([]string{input, exec}, args...))
+		return nil, nil
+	})()
+
+	path := filepath.Join(root, "etrace-1")
+	cg, err := profiling.NewMemoryCgroup("etrace-1", "512M")
+	c.Assert(err, check.IsNil)
+	c.Check(calls, check.DeepEquals, [][]string{
+		{"sudo", "mkdir", path},
+		{"512M\n", "sudo", "tee", filepath.Join(path, "memory.max")},
+	})
+
+	err = ioutil.WriteFile(filepath.Join(path, "memory.events"), []byte("low 0\nhigh 0\nmax 12\noom 1\noom_kill 1\n"), 0644)
+	c.Assert(err, check.IsNil)
+	err = ioutil.WriteFile(filepath.Join(path, "memory.pressure"), []byte("some avg10=0.00 avg60=0.00 avg300=0.00 total=1500\nfull avg10=0.00 avg60=0.00 avg300=0.00 total=1000\n"), 0644)
+	c.Assert(err, check.IsNil)
+	events, err := cg.Events()
+	c.Assert(err, check.IsNil)
+	c.Check(events, check.DeepEquals, &profiling.MemoryEvents{
+		OOMKilled: true,
+		Reclaims:  12,
+		Stalls:    1500 * time.Microsecond,
+	})
+}
+
+func (s *cgroupTestSuite) TestMemoryCgroupInvalidLimit(c *check.C) {
+	defer profiling.MockExecCommand(func(exec string, args ...string) ([]byte, error) {
+		c.Fatalf("unexpected command %s %v", exec, args)
+		return nil, nil
+	})()
+
+	for _, limit := range []string{"", "1.5G", "512MB", "-1", "1G; rm -rf /", "max\n"} {
+		_, err := profiling.NewMemoryCgroup("etrace-1", limit)
+		c.Check(err, check.ErrorMatches, "invalid memory limit .*", check.Commentf("%q", limit))
+	}
+	for _, limit := range []string{"1073741824", "512M", "2g", "max"} {
+		c.Check(profiling.ValidateMemoryLimit(limit), check.IsNil)
+	}
+}
@@ -23,3 +23,56 @@ func MockExecCommand(mocked func(string, ...string) ([]byte, error)) func() {
 		execCommandCombinedOutput = old
 	}
 }
+
+func MockExecCommandWithInput(mocked func(string, string, ...string) ([]byte, error)) func() {
+	old := execCommandWithInput
+	execCommandWithInput = mocked
+	return func() {
+		execCommandWithInput = old
+	}
+}
+
+func MockCgroupRoot(new string) func() {
+	old := cgroupRoot
+	cgroupRoot = new
+	return func() {
+		cgroupRoot = old
+	}
+}
+
+func MockProcStatPath(new string) func() {
+	old := procStatPath
+	procStatPath = new
+	return func() {
+		procStatPath = old
+	}
+}
+
+func MockDirs(home, tmp string) func() {
+	oldHome, oldTmp := userHomeDir, tempDir
+	userHomeDir = func() (string, error) {
+		return home, nil
+	}
+	tempDir = func() string {
+		return tmp
+	}
+	return func() {
+		userHomeDir, tempDir = oldHome, oldTmp
+	}
+}
+
+func MockProcPath(new string) func() {
+	old := procPath
+	procPath = new
+	return func() {
+		procPath = old
+	}
+}
+
+func MockHostnamePath(new string) func() {
+	old := hostnamePath
+	hostnamePath = new
+	return func() {
+		hostnamePath = old
+	}
+}
@@ -0,0 +1,35 @@
+//go:build amd64 || arm64 || ppc64le || s390x || riscv64
+// +build amd64 arm64 ppc64le s390x riscv64
+
+/*
+ * Copyright (C) 2019 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package profiling
+
+import "syscall"
+
+// posixFadvDontNeed is POSIX_FADV_DONTNEED from <fcntl.h>
+const posixFadvDontNeed = 4
+
+func fadviseDontNeed(fd uintptr) error {
+	// an offset and length of 0 means the whole file
+	_, _, errno := syscall.Syscall6(syscall.SYS_FADVISE64, fd, 0, 0, posixFadvDontNeed, 0, 0)
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
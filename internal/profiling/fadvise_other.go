@@ -0,0 +1,29 @@
+//go:build !amd64 && !arm64 && !ppc64le && !s390x && !riscv64
+// +build !amd64,!arm64,!ppc64le,!s390x,!riscv64
+
+/*
+ * Copyright (C) 2019 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package profiling
+
+import "errors"
+
+// on 32-bit architectures the 64-bit offset and length are split across
+// registers differently for every architecture, so it's not supported there
+func fadviseDontNeed(fd uintptr) error {
+	return errors.New("evicting files from the page cache is not supported on this architecture")
+}
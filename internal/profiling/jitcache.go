@@ -0,0 +1,92 @@
+/*
+ * Copyright (C) 2019 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package profiling
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// jitCaches are where runtimes keep code they compiled in previous runs, as
+// globs where a leading ~ is the user's home directory and $TMPDIR is the
+// temporary directory
+var jitCaches = map[string][]string{
+	// OpenJ9's shared classes cache, HotSpot only uses archives it is
+	// explicitly given
+	"jvm": {"~/.cache/javasharedresources", "$TMPDIR/javasharedresources"},
+	// node's module compile cache and the code cache of every electron and
+	// chromium based app
+	"v8": {"$TMPDIR/node-compile-cache", "~/.config/*/Code Cache", "~/.cache/*/Code Cache"},
+	// .NET's tiered compilation keeps nothing between runs, but mono keeps
+	// the assemblies it compiled ahead of time
+	"mono": {"~/.cache/mono", "~/.mono/aot-cache"},
+	// compiled GPU shaders
+	"mesa": {"~/.cache/mesa_shader_cache", "~/.cache/mesa_shader_cache_db"},
+}
+
+var (
+	userHomeDir = os.UserHomeDir
+	tempDir     = os.TempDir
+)
+
+// JITCacheRuntimes returns the runtimes whose caches ClearJITCache knows of
+func JITCacheRuntimes() []string {
+	runtimes := make([]string, 0, len(jitCaches))
+	for runtime := range jitCaches {
+		runtimes = append(runtimes, runtime)
+	}
+	sort.Strings(runtimes)
+	return runtimes
+}
+
+// ClearJITCache removes the caches of compiled code kept by runtime, so that
+// the next run starts cold, returning the paths which were removed
+func ClearJITCache(runtime string) ([]string, error) {
+	globs, ok := jitCaches[runtime]
+	if !ok {
+		return nil, fmt.Errorf("unknown runtime %q, expected one of %s", runtime, strings.Join(JITCacheRuntimes(), ", "))
+	}
+	home, err := userHomeDir()
+	if err != nil {
+		return nil, err
+	}
+
+	var removed []string
+	for _, glob := range globs {
+		switch {
+		case strings.HasPrefix(glob, "~/"):
+			glob = filepath.Join(home, glob[2:])
+		case strings.HasPrefix(glob, "$TMPDIR/"):
+			glob = filepath.Join(tempDir(), glob[len("$TMPDIR/"):])
+		}
+		paths, err := filepath.Glob(glob)
+		if err != nil {
+			return removed, err
+		}
+		for _, path := range paths {
+			if err := os.RemoveAll(path); err != nil {
+				return removed, fmt.Errorf("cannot clear %s cache: %w", runtime, err)
+			}
+			removed = append(removed, path)
+		}
+	}
+	return removed, nil
+}
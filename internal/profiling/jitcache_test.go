@@ -0,0 +1,54 @@
+/*
+ * Copyright (C) 2019 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package profiling_test
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/anonymouse64/etrace/internal/profiling"
+
+	"gopkg.in/check.v1"
+)
+
+type jitCacheTestSuite struct{}
+
+var _ = check.Suite(&jitCacheTestSuite{})
+
+func (s *jitCacheTestSuite) TestClearJITCache(c *check.C) {
+	home := c.MkDir()
+	defer profiling.MockDirs(home, c.MkDir())()
+
+	cache := filepath.Join(home, ".config", "Code", "Code Cache")
+	kept := filepath.Join(home, ".config", "Code", "Cookies")
+	c.Assert(os.MkdirAll(filepath.Join(cache, "js"), 0755), check.IsNil)
+	c.Assert(os.MkdirAll(kept, 0755), check.IsNil)
+
+	removed, err := profiling.ClearJITCache("v8")
+	c.Assert(err, check.IsNil)
+	c.Check(removed, check.DeepEquals, []string{cache})
+	_, err = os.Stat(cache)
+	c.Check(os.IsNotExist(err), check.Equals, true)
+	_, err = os.Stat(kept)
+	c.Check(err, check.IsNil)
+}
+
+func (s *jitCacheTestSuite) TestClearJITCacheUnknown(c *check.C) {
+	_, err := profiling.ClearJITCache("cobol")
+	c.Check(err, check.ErrorMatches, `unknown runtime "cobol", expected one of jvm, mesa, mono, v8`)
+}
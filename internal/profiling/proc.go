@@ -0,0 +1,234 @@
+/*
+ * Copyright (C) 2019 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package profiling
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// clockTicks is sysconf(_SC_CLK_TCK), the unit of the times in
+// /proc/<pid>/stat, which is 100 on every architecture Linux supports
+const clockTicks = 100
+
+// readProcStat returns the fields of /proc/<pid>/stat after the command name,
+// such that field N from proc(5) is at index N-3
+func readProcStat(pid int) ([]string, error) {
+	b, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		return nil, err
+	}
+	// the command name is in parentheses and can contain spaces or
+	// parentheses itself, so split after the last closing parenthesis
+	stat := string(b)
+	i := strings.LastIndex(stat, ")")
+	if i < 0 {
+		return nil, fmt.Errorf("malformed stat for pid %d", pid)
+	}
+	fields := strings.Fields(stat[i+1:])
+	if len(fields) < 15 {
+		return nil, fmt.Errorf("malformed stat for pid %d", pid)
+	}
+	return fields, nil
+}
+
+func ticksToDuration(fields ...string) (time.Duration, error) {
+	var total time.Duration
+	for _, f := range fields {
+		ticks, err := strconv.ParseUint(f, 10, 64)
+		if err != nil {
+			return 0, err
+		}
+		total += time.Duration(ticks) * time.Second / clockTicks
+	}
+	return total, nil
+}
+
+// ProcessCPUTime returns the user and system CPU time pid has used so far
+func ProcessCPUTime(pid int) (time.Duration, error) {
+	fields, err := readProcStat(pid)
+	if err != nil {
+		return 0, err
+	}
+	// utime and stime
+	return ticksToDuration(fields[11], fields[12])
+}
+
+// WaitForIdle samples the CPU usage of pid every interval until it has stayed
+// below threshold percent of a single CPU for the settle period, returning the
+// time at which it went idle
+func WaitForIdle(pid int, threshold float64, settle, interval, timeout time.Duration) (time.Time, error) {
+	deadline := time.Now().Add(timeout)
+	lastCPU, err := ProcessCPUTime(pid)
+	if err != nil {
+		return time.Time{}, err
+	}
+	lastSample := time.Now()
+	idleSince := lastSample
+	for time.Now().Before(deadline) {
+		time.Sleep(interval)
+		cpu, err := ProcessCPUTime(pid)
+		if err != nil {
+			return time.Time{}, err
+		}
+		now := time.Now()
+
+		usage := 100 * float64(cpu-lastCPU) / float64(now.Sub(lastSample))
+		if usage > threshold {
+			// still busy, start over
+			idleSince = now
+		} else if now.Sub(idleSince) >= settle {
+			return idleSince, nil
+		}
+
+		lastCPU, lastSample = cpu, now
+	}
+	return time.Time{}, fmt.Errorf("pid %d did not go idle within %v", pid, timeout)
+}
+
+// prctl(2) option, which isn't defined by the syscall package
+const prSetChildSubreaper = 36
+
+// SetChildSubreaper makes the current process the reaper of its orphaned
+// descendants, so that processes which daemonize by forking and having their
+// parent exit are re-parented to us rather than init and can still be found
+// with Children
+func SetChildSubreaper() error {
+	_, _, errno := syscall.RawSyscall(syscall.SYS_PRCTL, prSetChildSubreaper, 1, 0)
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// Children returns the pids of all the direct children of pid
+func Children(pid int) ([]int, error) {
+	stats, err := filepath.Glob("/proc/[0-9]*/stat")
+	if err != nil {
+		return nil, err
+	}
+	var children []int
+	for _, stat := range stats {
+		child, err := strconv.Atoi(filepath.Base(filepath.Dir(stat)))
+		if err != nil {
+			continue
+		}
+		fields, err := readProcStat(child)
+		if err != nil {
+			// the process exited in the meantime
+			continue
+		}
+		// ppid
+		if fields[1] == strconv.Itoa(pid) {
+			children = append(children, child)
+		}
+	}
+	return children, nil
+}
+
+// Descendants returns the pids of the children of pid, their children and so
+// on
+func Descendants(pid int) ([]int, error) {
+	stats, err := filepath.Glob("/proc/[0-9]*/stat")
+	if err != nil {
+		return nil, err
+	}
+	children := make(map[int][]int)
+	for _, stat := range stats {
+		child, err := strconv.Atoi(filepath.Base(filepath.Dir(stat)))
+		if err != nil {
+			continue
+		}
+		fields, err := readProcStat(child)
+		if err != nil {
+			// the process exited in the meantime
+			continue
+		}
+		ppid, err := strconv.Atoi(fields[1])
+		if err != nil {
+			continue
+		}
+		children[ppid] = append(children[ppid], child)
+	}
+
+	var descendants []int
+	parents := []int{pid}
+	for len(parents) != 0 {
+		parent := parents[0]
+		parents = parents[1:]
+		descendants = append(descendants, children[parent]...)
+		parents = append(parents, children[parent]...)
+	}
+	return descendants, nil
+}
+
+// WaitPids waits for all of the given children of the current process to
+// exit, reaping them, and closes the returned channel once they have
+func WaitPids(pids []int) <-chan struct{} {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for _, pid := range pids {
+			var ws syscall.WaitStatus
+			for {
+				_, err := syscall.Wait4(pid, &ws, 0, nil)
+				if err != syscall.EINTR {
+					break
+				}
+			}
+		}
+	}()
+	return done
+}
+
+// ContextSwitches returns how many times pid has voluntarily given up the CPU,
+// i.e. to wait for I/O, and how many times it was preempted so far
+func ContextSwitches(pid int) (voluntary, involuntary int64, err error) {
+	b, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/status", pid))
+	if err != nil {
+		return 0, 0, err
+	}
+	// lines look like:
+	// voluntary_ctxt_switches:	150
+	// nonvoluntary_ctxt_switches:	545
+	for _, line := range strings.Split(string(b), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			continue
+		}
+		var dest *int64
+		switch fields[0] {
+		case "voluntary_ctxt_switches:":
+			dest = &voluntary
+		case "nonvoluntary_ctxt_switches:":
+			dest = &involuntary
+		default:
+			continue
+		}
+		*dest, err = strconv.ParseInt(fields[1], 10, 64)
+		if err != nil {
+			return 0, 0, fmt.Errorf("malformed status for pid %d: %w", pid, err)
+		}
+	}
+	return voluntary, involuntary, nil
+}
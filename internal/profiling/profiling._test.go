@@ -18,9 +18,11 @@ package profiling_test
 
 import (
 	"fmt"
+	"io/ioutil"
 	"os"
 	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/anonymouse64/etrace/internal/files"
 	"github.com/anonymouse64/etrace/internal/profiling"
@@ -123,11 +125,11 @@ func (p *profilingTestSuite) TestFreeCaches(c *check.C) {
 		c.Assert(exec, check.Equals, "sudo")
 		switch runs {
 		case 0:
-			c.Assert(args, check.DeepEquals, []string{"sysctl", "-q", "vm.drop_caches=\x01"})
+			c.Assert(args, check.DeepEquals, []string{"sysctl", "-q", "vm.drop_caches=1"})
 		case 1:
-			c.Assert(args, check.DeepEquals, []string{"sysctl", "-q", "vm.drop_caches=\x02"})
+			c.Assert(args, check.DeepEquals, []string{"sysctl", "-q", "vm.drop_caches=2"})
 		case 2:
-			c.Assert(args, check.DeepEquals, []string{"sysctl", "-q", "vm.drop_caches=\x03"})
+			c.Assert(args, check.DeepEquals, []string{"sysctl", "-q", "vm.drop_caches=3"})
 		default:
 			c.Fatalf(
 				"unexpected exec call of %v (on %d calls)",
@@ -144,3 +146,70 @@ func (p *profilingTestSuite) TestFreeCaches(c *check.C) {
 	err := profiling.FreeCaches()
 	c.Assert(err, check.IsNil)
 }
+
+func (p *profilingTestSuite) TestRunScriptOutput(c *check.C) {
+	r := MockCWD(c, p.tmpDir)
+	defer r()
+
+	r = profiling.MockExecCommand(func(exec string, args ...string) ([]byte, error) {
+		c.Assert(exec, check.Equals, p.script)
+		return []byte("some output\n"), nil
+	})
+	defer r()
+
+	out, err := profiling.RunScriptOutput(testScriptName, nil)
+	c.Assert(err, check.IsNil)
+	c.Check(string(out), check.Equals, "some output\n")
+}
+
+func (p *profilingTestSuite) TestContextSwitches(c *check.C) {
+	voluntary, involuntary, err := profiling.ContextSwitches(os.Getpid())
+	c.Assert(err, check.IsNil)
+	c.Check(voluntary >= 0, check.Equals, true)
+	c.Check(involuntary >= 0, check.Equals, true)
+
+	_, _, err = profiling.ContextSwitches(-1)
+	c.Check(err, check.NotNil)
+}
+
+func (p *profilingTestSuite) TestRunScriptWithTimeout(c *check.C) {
+	r := MockCWD(c, p.tmpDir)
+	defer r()
+	c.Assert(ioutil.WriteFile(p.script, []byte("#!/bin/sh\necho started\nsleep 10 &\nwait\n"), 0755), check.IsNil)
+
+	start := time.Now()
+	out, err := profiling.RunScriptWithOptions(testScriptName, nil, profiling.ScriptOptions{Timeout: 100 * time.Millisecond})
+	c.Check(err, check.ErrorMatches, "killed after running for longer than 100ms")
+	c.Check(string(out), check.Equals, "started\n")
+	// the background sleep was killed too rather than keeping the output open
+	c.Check(time.Since(start) < 5*time.Second, check.Equals, true)
+}
+
+func (p *profilingTestSuite) TestRunScriptWithEnv(c *check.C) {
+	r := MockCWD(c, p.tmpDir)
+	defer r()
+	c.Assert(ioutil.WriteFile(p.script, []byte("#!/bin/sh\necho \"$ETRACE_ITERATION $HOME\"\n"), 0755), check.IsNil)
+
+	out, err := profiling.RunScriptWithOptions(testScriptName, nil, profiling.ScriptOptions{Env: []string{"ETRACE_ITERATION=2"}})
+	c.Assert(err, check.IsNil)
+	// the rest of the environment is kept
+	c.Check(string(out), check.Equals, fmt.Sprintf("2 %s\n", os.Getenv("HOME")))
+}
+
+func (p *profilingTestSuite) TestSystemBusyness(c *check.C) {
+	stat := filepath.Join(p.tmpDir, "stat")
+	restore := profiling.MockProcStatPath(stat)
+	defer restore()
+
+	c.Assert(ioutil.WriteFile(stat, []byte("cpu  100 0 50 800 50 0 0 0 0 0\ncpu0 100 0 50 800 50 0 0 0 0 0\n"), 0644), check.IsNil)
+	before, err := profiling.ReadSystemCPUTimes()
+	c.Assert(err, check.IsNil)
+	c.Check(*before, check.Equals, profiling.SystemCPUTimes{Busy: 1500 * time.Millisecond, Idle: 8 * time.Second, IOWait: 500 * time.Millisecond})
+
+	c.Assert(ioutil.WriteFile(stat, []byte("cpu  200 10 90 850 100 0 0 0 0 0\n"), 0644), check.IsNil)
+	after, err := profiling.ReadSystemCPUTimes()
+	c.Assert(err, check.IsNil)
+
+	busyness := profiling.Busyness(before, after, 500*time.Millisecond)
+	c.Check(*busyness, check.Equals, profiling.SystemBusyness{Busy: 60, IOWait: 20, OtherCPUTime: time.Second})
+}
@@ -18,10 +18,17 @@
 package profiling
 
 import (
+	"bytes"
+	"fmt"
 	"log"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
 )
 
 // helper function to make testing easier
@@ -29,6 +36,17 @@ var execCommandCombinedOutput = func(prog string, args ...string) ([]byte, error
 	return exec.Command(prog, args...).CombinedOutput()
 }
 
+// execCommandWithInput runs the command with input as its stdin, returning
+// only what it printed on stderr
+var execCommandWithInput = func(input, prog string, args ...string) ([]byte, error) {
+	cmd := exec.Command(prog, args...)
+	cmd.Stdin = strings.NewReader(input)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+	return stderr.Bytes(), err
+}
+
 // FreeCaches will drop caches in the kernel for the most accurate measurements
 func FreeCaches() error {
 	// it would be nice to do this from pure Go, but then we have to become root
@@ -36,7 +54,7 @@ func FreeCaches() error {
 	// calling user, which means we need to do setuid or user priv dropping ...
 	// so just use sudo for now
 	for _, i := range []int{1, 2, 3} {
-		out, err := execCommandCombinedOutput("sudo", "sysctl", "-q", "vm.drop_caches="+string(i))
+		out, err := execCommandCombinedOutput("sudo", "sysctl", "-q", "vm.drop_caches="+strconv.Itoa(i))
 		if err != nil {
 			log.Println(string(out))
 			return err
@@ -48,20 +66,134 @@ func FreeCaches() error {
 	return nil
 }
 
+// CheckFreeCaches checks up front that FreeCaches will work, which needs root
+// through sudo, asking for the password now if sudo needs one rather than in
+// the middle of a run
+func CheckFreeCaches() error {
+	if os.Geteuid() == 0 {
+		f, err := os.OpenFile("/proc/sys/vm/drop_caches", os.O_WRONLY, 0)
+		if err != nil {
+			return fmt.Errorf("cannot drop caches: %w", err)
+		}
+		return f.Close()
+	}
+	if _, err := execCommandCombinedOutput("sudo", "-n", "true"); err == nil {
+		return nil
+	}
+	// sudo -v prompts for the password on the terminal
+	cmd := exec.Command("sudo", "-v")
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("cannot drop caches without root through sudo: %w", err)
+	}
+	return nil
+}
+
+// EvictFiles drops the cached pages of just the given files with
+// posix_fadvise(POSIX_FADV_DONTNEED), leaving the rest of the page cache alone.
+// All files are tried, and the first error encountered is returned.
+func EvictFiles(paths []string) error {
+	var firstErr error
+	for _, path := range paths {
+		if err := evictFile(path); err != nil && firstErr == nil {
+			firstErr = fmt.Errorf("cannot evict %s from the page cache: %w", path, err)
+		}
+	}
+	return firstErr
+}
+
+func evictFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return fadviseDontNeed(f.Fd())
+}
+
 // RunScript will run the specified script with args, trying both a script on
 // $PATH, as well as from the current working directory for easy
 // scripting/measurement from the command line without large paths as arguments
 func RunScript(fname string, args []string) error {
+	_, err := RunScriptOutput(fname, args)
+	return err
+}
+
+// RunScriptOutput is like RunScript, but also returns the combined stdout and
+// stderr of the script
+func RunScriptOutput(fname string, args []string) ([]byte, error) {
+	return RunScriptWithOptions(fname, args, ScriptOptions{})
+}
+
+// ScriptOptions are additional options for running a script
+type ScriptOptions struct {
+	// Timeout if not 0 is how long the script can run for before it and
+	// anything it started is killed
+	Timeout time.Duration
+	// Env are added to the script's environment, as KEY=value
+	Env []string
+}
+
+// RunScriptWithOptions is like RunScriptOutput, but runs the script with opts
+func RunScriptWithOptions(fname string, args []string, opts ScriptOptions) ([]byte, error) {
 	path, err := exec.LookPath(fname)
 	if err != nil {
 		// try the current directory
 		cwd, err := os.Getwd()
 		if err != nil {
-			return err
+			return nil, err
 		}
 		path = filepath.Join(cwd, fname)
 	}
 	// path is either the path found with LookPath, or cwd/fname
-	_, err = execCommandCombinedOutput(path, args...)
-	return err
+	if opts.Timeout == 0 && len(opts.Env) == 0 {
+		return execCommandCombinedOutput(path, args...)
+	}
+	return runWithOptions(opts, path, args...)
+}
+
+func runWithOptions(opts ScriptOptions, prog string, args ...string) ([]byte, error) {
+	var out bytes.Buffer
+	cmd := exec.Command(prog, args...)
+	cmd.Stdout = &out
+	cmd.Stderr = &out
+	if len(opts.Env) != 0 {
+		cmd.Env = append(os.Environ(), opts.Env...)
+	}
+	if opts.Timeout == 0 {
+		err := cmd.Run()
+		return out.Bytes(), err
+	}
+	// the script gets its own process group so that anything it started,
+	// which could otherwise keep the output open, is killed along with it
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	// the timer can still fire once the script has exited and the timer
+	// can't be stopped anymore, so it only kills the script if it hasn't
+	// already been reaped
+	var mu sync.Mutex
+	exited, killed := false, false
+	timer := time.AfterFunc(opts.Timeout, func() {
+		mu.Lock()
+		defer mu.Unlock()
+		if !exited {
+			killed = true
+			syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+		}
+	})
+	err := cmd.Wait()
+	if timer.Stop() {
+		return out.Bytes(), err
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	exited = true
+	if killed {
+		return out.Bytes(), fmt.Errorf("killed after running for longer than %v", opts.Timeout)
+	}
+	return out.Bytes(), err
 }
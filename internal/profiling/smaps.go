@@ -0,0 +1,121 @@
+/*
+ * Copyright (C) 2019 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package profiling
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// procPath is where /proc is mounted
+var procPath = "/proc"
+
+// MappedFile is how much of a file processes mapped into memory and how much
+// of that was actually faulted in, which is what startup needed from it
+type MappedFile struct {
+	Path string
+	// Mapped is the size of all the mappings of the file in bytes
+	Mapped uint64
+	// Faulted is how much of the mappings is resident in the processes'
+	// page tables in bytes, having been faulted in either on access or by
+	// read-ahead around an access
+	Faulted uint64
+}
+
+// MappedFiles returns the files mapped by the given pids from their
+// /proc/<pid>/smaps, with the files the most bytes were faulted in from first
+func MappedFiles(pids []int) ([]MappedFile, error) {
+	files := make(map[string]*MappedFile)
+	seen := make(map[int]bool)
+	for _, pid := range pids {
+		if pid == 0 || seen[pid] {
+			continue
+		}
+		seen[pid] = true
+		f, err := os.Open(fmt.Sprintf("%s/%d/smaps", procPath, pid))
+		if err != nil {
+			return nil, err
+		}
+		err = parseSmaps(f, files)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("cannot parse smaps of pid %d: %w", pid, err)
+		}
+	}
+
+	sorted := make([]MappedFile, 0, len(files))
+	for _, file := range files {
+		sorted = append(sorted, *file)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].Faulted == sorted[j].Faulted {
+			return sorted[i].Path < sorted[j].Path
+		}
+		return sorted[i].Faulted > sorted[j].Faulted
+	})
+	return sorted, nil
+}
+
+// parseSmaps adds the sizes of the file mappings in smaps to files
+func parseSmaps(r io.Reader, files map[string]*MappedFile) error {
+	// the mapping the following fields are for, or nil if it isn't of a file
+	var current *MappedFile
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		// mappings start with their address range, like:
+		// 7f3b7d600000-7f3b7d622000 r--p 00000000 08:02 1835 /usr/lib/libc.so.6
+		if strings.Contains(fields[0], "-") && !strings.HasSuffix(fields[0], ":") {
+			current = nil
+			// anonymous mappings have no path and the likes of [heap] and
+			// deleted files aren't files which can be read ahead
+			if len(fields) < 6 || !strings.HasPrefix(fields[5], "/") || fields[len(fields)-1] == "(deleted)" {
+				continue
+			}
+			path := strings.Join(fields[5:], " ")
+			current = files[path]
+			if current == nil {
+				current = &MappedFile{Path: path}
+				files[path] = current
+			}
+			continue
+		}
+		if current == nil || len(fields) != 3 || fields[2] != "kB" {
+			continue
+		}
+		kb, err := strconv.ParseUint(fields[1], 10, 64)
+		if err != nil {
+			return err
+		}
+		switch fields[0] {
+		case "Size:":
+			current.Mapped += kb * 1024
+		case "Rss:":
+			current.Faulted += kb * 1024
+		}
+	}
+	return scanner.Err()
+}
@@ -0,0 +1,71 @@
+/*
+ * Copyright (C) 2019 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package profiling_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/anonymouse64/etrace/internal/profiling"
+
+	"gopkg.in/check.v1"
+)
+
+type smapsTestSuite struct{}
+
+var _ = check.Suite(&smapsTestSuite{})
+
+const sampleSmaps = `55d0c5a00000-55d0c5a02000 r--p 00000000 08:02 1835                       /usr/bin/hello
+Size:                  8 kB
+Rss:                   8 kB
+VmFlags: rd mr mw me dw sd
+55d0c5c21000-55d0c5c42000 rw-p 00000000 00:00 0                          [heap]
+Size:                132 kB
+Rss:                  12 kB
+7f3b7d600000-7f3b7d628000 r--p 00000000 08:02 2042                       /usr/lib/x86_64-linux-gnu/libc.so.6
+Size:                160 kB
+Rss:                 160 kB
+7f3b7d628000-7f3b7d7bd000 r-xp 00028000 08:02 2042                       /usr/lib/x86_64-linux-gnu/libc.so.6
+Size:               1620 kB
+Rss:                 900 kB
+7f3b7d800000-7f3b7d900000 rw-p 00000000 00:00 0
+Size:               1024 kB
+Rss:                1024 kB
+7f3b7da00000-7f3b7da01000 r--p 00000000 08:02 3001                       /tmp/gone (deleted)
+Size:                  4 kB
+Rss:                   4 kB
+`
+
+func (s *smapsTestSuite) TestMappedFiles(c *check.C) {
+	root := c.MkDir()
+	defer profiling.MockProcPath(root)()
+	c.Assert(os.Mkdir(filepath.Join(root, "100"), 0755), check.IsNil)
+	c.Assert(ioutil.WriteFile(filepath.Join(root, "100", "smaps"), []byte(sampleSmaps), 0644), check.IsNil)
+
+	// the same pid is only counted once
+	files, err := profiling.MappedFiles([]int{100, 100, 0})
+	c.Assert(err, check.IsNil)
+	c.Check(files, check.DeepEquals, []profiling.MappedFile{
+		{Path: "/usr/lib/x86_64-linux-gnu/libc.so.6", Mapped: 1780 * 1024, Faulted: 1060 * 1024},
+		{Path: "/usr/bin/hello", Mapped: 8 * 1024, Faulted: 8 * 1024},
+	})
+
+	_, err = profiling.MappedFiles([]int{200})
+	c.Check(err, check.NotNil)
+}
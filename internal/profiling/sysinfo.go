@@ -0,0 +1,83 @@
+/*
+ * Copyright (C) 2019 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package profiling
+
+import (
+	"bufio"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var hostnamePath = "/etc/hostname"
+
+// SystemInfo identifies the machine results came from, so that results from
+// several machines can be told apart once merged
+type SystemInfo struct {
+	Hostname string
+	// Kernel is the kernel release, as shown by uname -r
+	Kernel string
+	// CPUModel is the model name of the first CPU, where the architecture
+	// has one
+	CPUModel string `json:",omitempty"`
+}
+
+// ReadSystemInfo reads the hostname, kernel release and CPU model of this
+// machine
+func ReadSystemInfo() (*SystemInfo, error) {
+	info := &SystemInfo{}
+	hostname, err := ioutil.ReadFile(hostnamePath)
+	if err == nil {
+		info.Hostname = strings.TrimSpace(string(hostname))
+	}
+	// containers and some distros have no /etc/hostname
+	if info.Hostname == "" {
+		if info.Hostname, err = os.Hostname(); err != nil {
+			return nil, err
+		}
+	}
+	kernel, err := ioutil.ReadFile(filepath.Join(procPath, "sys/kernel/osrelease"))
+	if err != nil {
+		return nil, err
+	}
+	info.Kernel = strings.TrimSpace(string(kernel))
+	if info.CPUModel, err = cpuModel(); err != nil {
+		return nil, err
+	}
+	return info, nil
+}
+
+// cpuModel returns the first model name in /proc/cpuinfo, which looks like:
+// model name	: Intel(R) Core(TM) i7-8550U CPU @ 1.80GHz
+func cpuModel() (string, error) {
+	f, err := os.Open(filepath.Join(procPath, "cpuinfo"))
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		kv := strings.SplitN(scanner.Text(), ":", 2)
+		if len(kv) == 2 && strings.TrimSpace(kv[0]) == "model name" {
+			return strings.TrimSpace(kv[1]), nil
+		}
+	}
+	return "", scanner.Err()
+}
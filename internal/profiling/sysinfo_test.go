@@ -0,0 +1,50 @@
+/*
+ * Copyright (C) 2019 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package profiling_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/anonymouse64/etrace/internal/profiling"
+
+	"gopkg.in/check.v1"
+)
+
+type sysInfoTestSuite struct{}
+
+var _ = check.Suite(&sysInfoTestSuite{})
+
+func (s *sysInfoTestSuite) TestReadSystemInfo(c *check.C) {
+	root := c.MkDir()
+	defer profiling.MockProcPath(root)()
+	defer profiling.MockHostnamePath(filepath.Join(root, "hostname"))()
+	c.Assert(os.MkdirAll(filepath.Join(root, "sys", "kernel"), 0755), check.IsNil)
+	c.Assert(ioutil.WriteFile(filepath.Join(root, "sys", "kernel", "osrelease"), []byte("5.4.0-26-generic\n"), 0644), check.IsNil)
+	c.Assert(ioutil.WriteFile(filepath.Join(root, "cpuinfo"), []byte("processor\t: 0\nvendor_id\t: GenuineIntel\nmodel name\t: Intel(R) Core(TM) i7-8550U CPU @ 1.80GHz\n\nprocessor\t: 1\nmodel name\t: other\n"), 0644), check.IsNil)
+	c.Assert(ioutil.WriteFile(filepath.Join(root, "hostname"), []byte("bench-1\n"), 0644), check.IsNil)
+
+	info, err := profiling.ReadSystemInfo()
+	c.Assert(err, check.IsNil)
+	c.Check(info, check.DeepEquals, &profiling.SystemInfo{
+		Hostname: "bench-1",
+		Kernel:   "5.4.0-26-generic",
+		CPUModel: "Intel(R) Core(TM) i7-8550U CPU @ 1.80GHz",
+	})
+}
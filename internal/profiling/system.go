@@ -0,0 +1,97 @@
+/*
+ * Copyright (C) 2019 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package profiling
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+var procStatPath = "/proc/stat"
+
+// SystemCPUTimes are the times spent by all the CPUs together since boot
+type SystemCPUTimes struct {
+	Busy   time.Duration
+	Idle   time.Duration
+	IOWait time.Duration
+}
+
+// ReadSystemCPUTimes reads the CPU times of the whole system from the cpu line
+// of /proc/stat, which looks like:
+// cpu  user nice system idle iowait irq softirq steal guest guest_nice
+func ReadSystemCPUTimes() (*SystemCPUTimes, error) {
+	f, err := os.Open(procStatPath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 9 || fields[0] != "cpu" {
+			continue
+		}
+		times := &SystemCPUTimes{}
+		// guest time is already included in user time
+		if times.Busy, err = ticksToDuration(fields[1], fields[2], fields[3], fields[6], fields[7], fields[8]); err != nil {
+			return nil, fmt.Errorf("cannot parse %s: %w", procStatPath, err)
+		}
+		if times.Idle, err = ticksToDuration(fields[4]); err != nil {
+			return nil, fmt.Errorf("cannot parse %s: %w", procStatPath, err)
+		}
+		if times.IOWait, err = ticksToDuration(fields[5]); err != nil {
+			return nil, fmt.Errorf("cannot parse %s: %w", procStatPath, err)
+		}
+		return times, nil
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return nil, fmt.Errorf("cannot find the cpu line in %s", procStatPath)
+}
+
+// SystemBusyness is how busy the whole system was while the command ran
+type SystemBusyness struct {
+	// Busy and IOWait are percentages of the time of all the CPUs
+	Busy   float64
+	IOWait float64
+	// OtherCPUTime is the CPU time used by everything but the command
+	OtherCPUTime time.Duration
+}
+
+// Busyness compares the system's CPU times before and after the command ran,
+// during which the command itself used commandCPU
+func Busyness(before, after *SystemCPUTimes, commandCPU time.Duration) *SystemBusyness {
+	busy := after.Busy - before.Busy
+	idle := after.Idle - before.Idle
+	iowait := after.IOWait - before.IOWait
+	b := &SystemBusyness{}
+	if total := busy + idle + iowait; total > 0 {
+		b.Busy = 100 * float64(busy) / float64(total)
+		b.IOWait = 100 * float64(iowait) / float64(total)
+	}
+	// the times only have a resolution of clock ticks
+	if busy > commandCPU {
+		b.OtherCPUTime = busy - commandCPU
+	}
+	return b
+}
@@ -0,0 +1,98 @@
+/*
+ * Copyright (C) 2019 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package remote
+
+import (
+	"bytes"
+	"io"
+	"log"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// quote quotes s so that the remote shell sees it as a single word
+func quote(s string) string {
+	return "'" + strings.Replace(s, "'", `'"'"'`, -1) + "'"
+}
+
+// Command returns an exec.Cmd which runs args on host over ssh, the args are
+// quoted so that they reach the remote command unmodified
+func Command(host string, args ...string) *exec.Cmd {
+	quoted := make([]string, len(args))
+	for i, arg := range args {
+		quoted[i] = quote(arg)
+	}
+	return exec.Command("ssh", "--", host, strings.Join(quoted, " "))
+}
+
+// Output runs args on host and returns the stdout of the command
+func Output(host string, args ...string) (string, error) {
+	var stderr bytes.Buffer
+	cmd := Command(host, args...)
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		log.Println(stderr.String())
+		return "", err
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// User returns the user that commands are run as on host
+func User(host string) (string, error) {
+	if i := strings.Index(host, "@"); i >= 0 {
+		return host[:i], nil
+	}
+	return Output(host, "id", "-un")
+}
+
+// TempFile creates a new temporary file on host and returns its path
+func TempFile(host string) (string, error) {
+	return Output(host, "mktemp", "--tmpdir", "etrace.XXXXXXXX")
+}
+
+// Fetch copies the contents of path on host to w
+func Fetch(host, path string, w io.Writer) error {
+	var stderr bytes.Buffer
+	cmd := Command(host, "cat", path)
+	cmd.Stdout = w
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		log.Println(stderr.String())
+		return err
+	}
+	return nil
+}
+
+// Remove deletes path on host
+func Remove(host, path string) error {
+	_, err := Output(host, "rm", "-f", path)
+	return err
+}
+
+// FreeCaches drops the kernel caches on host, the same as
+// profiling.FreeCaches does locally
+func FreeCaches(host string) error {
+	for _, i := range []int{1, 2, 3} {
+		if _, err := Output(host, "sudo", "sysctl", "-q", "vm.drop_caches="+strconv.Itoa(i)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
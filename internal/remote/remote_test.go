@@ -0,0 +1,104 @@
+/*
+ * Copyright (C) 2019 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package remote_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/anonymouse64/etrace/internal/remote"
+
+	"gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) { check.TestingT(t) }
+
+type remoteTestSuite struct {
+	oldPath string
+}
+
+var _ = check.Suite(&remoteTestSuite{})
+
+// a fake ssh which runs the remote command line with the local shell
+const fakeSSH = `#!/bin/sh
+[ "$1" = -- ] || exit 255
+exec sh -c "$3"
+`
+
+func (s *remoteTestSuite) SetUpTest(c *check.C) {
+	dir := c.MkDir()
+	err := ioutil.WriteFile(filepath.Join(dir, "ssh"), []byte(fakeSSH), 0755)
+	c.Assert(err, check.IsNil)
+	s.oldPath = os.Getenv("PATH")
+	os.Setenv("PATH", dir+":"+s.oldPath)
+}
+
+func (s *remoteTestSuite) TearDownTest(c *check.C) {
+	os.Setenv("PATH", s.oldPath)
+}
+
+func (s *remoteTestSuite) TestCommandQuotesArgs(c *check.C) {
+	cmd := remote.Command("user@host", "echo", "it's", "a b", "$HOME", "")
+	c.Check(cmd.Args, check.DeepEquals, []string{
+		"ssh", "--", "user@host", `'echo' 'it'"'"'s' 'a b' '$HOME' ''`,
+	})
+}
+
+func (s *remoteTestSuite) TestOutputArgsUnmodified(c *check.C) {
+	args := []string{"it's", "a  b", "$HOME", "`id`", `"\n"`, "*", ";", ""}
+	out, err := remote.Output("host", append([]string{"printf", "[%s]"}, args...)...)
+	c.Assert(err, check.IsNil)
+	c.Check(out, check.Equals, "[it's][a  b][$HOME][`id`][\"\\n\"][*][;][]")
+}
+
+func (s *remoteTestSuite) TestOutputError(c *check.C) {
+	_, err := remote.Output("host", "false")
+	c.Check(err, check.ErrorMatches, "exit status 1")
+}
+
+func (s *remoteTestSuite) TestUser(c *check.C) {
+	user, err := remote.User("someone@host")
+	c.Assert(err, check.IsNil)
+	c.Check(user, check.Equals, "someone")
+
+	// without a user in the host, it is asked for
+	user, err = remote.User("host")
+	c.Assert(err, check.IsNil)
+	out, err := remote.Output("host", "id", "-un")
+	c.Assert(err, check.IsNil)
+	c.Check(user, check.Equals, out)
+}
+
+func (s *remoteTestSuite) TestFetch(c *check.C) {
+	path := filepath.Join(c.MkDir(), "trace log")
+	err := ioutil.WriteFile(path, []byte("1234 execve(...) = 0\n"), 0644)
+	c.Assert(err, check.IsNil)
+
+	var buf bytes.Buffer
+	c.Assert(remote.Fetch("host", path, &buf), check.IsNil)
+	c.Check(buf.String(), check.Equals, "1234 execve(...) = 0\n")
+
+	c.Assert(remote.Remove("host", path), check.IsNil)
+	_, err = os.Stat(path)
+	c.Check(os.IsNotExist(err), check.Equals, true)
+
+	c.Check(remote.Fetch("host", path, &buf), check.ErrorMatches, "exit status 1")
+}
@@ -0,0 +1,124 @@
+/*
+ * Copyright (C) 2019 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package snaps
+
+import (
+	"fmt"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Denial is an AppArmor or seccomp denial logged by the kernel
+type Denial struct {
+	Time time.Time
+	// Kind is apparmor or seccomp
+	Kind string
+	// Profile is the AppArmor profile, or label for seccomp, of the process,
+	// such as snap.hello.hello
+	Profile string
+	Pid     int
+	Comm    string
+	// Operation is what AppArmor denied, such as open, or the number of the
+	// syscall seccomp denied
+	Operation string
+	// Name is the path AppArmor denied access to, if any
+	Name string `json:",omitempty"`
+}
+
+// lines look like:
+// audit: type=1400 audit(1580155329.123:456): apparmor="DENIED" operation="open" profile="snap.hello.hello" name="/etc/foo" pid=1234 comm="hello" requested_mask="r" denied_mask="r" fsuid=1000 ouid=0
+// audit: type=1326 audit(1580155329.456:457): auid=1000 uid=1000 gid=1000 ses=2 subj=snap.hello.hello pid=1234 comm="hello" exe="/snap/hello/x1/bin/hello" sig=0 arch=c000003e syscall=165 compat=0 ip=0x7f0 code=0x50000
+var auditRE = regexp.MustCompile(`type=(1400|1326) audit\(([0-9.]+):[0-9]+\): (.*)`)
+
+var auditFieldRE = regexp.MustCompile(`(\w+)=("[^"]*"|\S+)`)
+
+// journalctl is mocked in tests
+var journalctl = func(since time.Time) ([]byte, error) {
+	// the kernel log is only readable by root or the adm group
+	return exec.Command("sudo", "journalctl", "-k", "-q", "-o", "cat", "--no-pager", fmt.Sprintf("--since=@%d", since.Unix())).Output()
+}
+
+// Denials returns the AppArmor and seccomp denials the kernel logged since
+// the given time for any of pids, or for processes confined by the given
+// snap if it's not empty
+func Denials(since time.Time, pids []int, snap string) ([]Denial, error) {
+	out, err := journalctl(since)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read the kernel log: %w", err)
+	}
+	return parseDenials(string(out), since, pids, snap), nil
+}
+
+// auditTime parses the seconds.milliseconds time of an audit message
+func auditTime(s string) (time.Time, error) {
+	parts := strings.SplitN(s, ".", 2)
+	secs, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil || len(parts) == 1 {
+		return time.Unix(secs, 0), err
+	}
+	frac := (parts[1] + "000000000")[:9]
+	nsecs, err := strconv.ParseInt(frac, 10, 64)
+	return time.Unix(secs, nsecs), err
+}
+
+func parseDenials(log string, since time.Time, pids []int, snap string) []Denial {
+	wanted := make(map[int]bool, len(pids))
+	for _, pid := range pids {
+		wanted[pid] = true
+	}
+	// the snap's apps and hooks, not other snaps whose names it starts with
+	snap = strings.SplitN(snap, ".", 2)[0]
+	var denials []Denial
+	for _, line := range strings.Split(log, "\n") {
+		match := auditRE.FindStringSubmatch(line)
+		if match == nil {
+			continue
+		}
+		t, err := auditTime(match[2])
+		if err != nil {
+			continue
+		}
+		// the journal is only filtered to the second
+		if t.Before(since) {
+			continue
+		}
+		fields := make(map[string]string)
+		for _, kv := range auditFieldRE.FindAllStringSubmatch(match[3], -1) {
+			fields[kv[1]] = strings.Trim(kv[2], `"`)
+		}
+		d := Denial{Time: t, Comm: fields["comm"]}
+		d.Pid, _ = strconv.Atoi(fields["pid"])
+		if match[1] == "1400" {
+			// AppArmor in complain mode logs what it allowed too
+			if fields["apparmor"] != "DENIED" {
+				continue
+			}
+			d.Kind, d.Profile, d.Operation, d.Name = "apparmor", fields["profile"], fields["operation"], fields["name"]
+		} else {
+			d.Kind, d.Profile, d.Operation = "seccomp", fields["subj"], fields["syscall"]
+		}
+		if !wanted[d.Pid] && (snap == "" || !strings.HasPrefix(d.Profile, "snap."+snap+".")) {
+			continue
+		}
+		denials = append(denials, d)
+	}
+	return denials
+}
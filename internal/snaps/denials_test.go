@@ -0,0 +1,68 @@
+/*
+ * Copyright (C) 2019 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package snaps_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/anonymouse64/etrace/internal/snaps"
+
+	"gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) { check.TestingT(t) }
+
+type denialsTestSuite struct{}
+
+var _ = check.Suite(&denialsTestSuite{})
+
+const sampleKernelLog = `audit: type=1400 audit(1580155328.900:455): apparmor="DENIED" operation="open" profile="snap.hello.hello" name="/etc/old" pid=1200 comm="hello" requested_mask="r" denied_mask="r" fsuid=1000 ouid=0
+audit: type=1400 audit(1580155329.123:456): apparmor="DENIED" operation="open" profile="snap.hello.hello" name="/etc/foo" pid=1234 comm="hello" requested_mask="r" denied_mask="r" fsuid=1000 ouid=0
+audit: type=1400 audit(1580155329.200:457): apparmor="ALLOWED" operation="open" profile="snap.hello.hello" name="/etc/bar" pid=1234 comm="hello" requested_mask="r" denied_mask="r" fsuid=1000 ouid=0
+usb 1-1: new high-speed USB device number 3 using xhci_hcd
+audit: type=1326 audit(1580155329.456:458): auid=1000 uid=1000 gid=1000 ses=2 subj=snap.hello.hello pid=1235 comm="hello" exe="/snap/hello/x1/bin/hello" sig=0 arch=c000003e syscall=165 compat=0 ip=0x7f0 code=0x50000
+audit: type=1400 audit(1580155329.500:459): apparmor="DENIED" operation="open" profile="snap.hello-world.hello-world" name="/etc/foo" pid=999 comm="hello-world" requested_mask="r" denied_mask="r" fsuid=1000 ouid=0
+audit: type=1400 audit(1580155329.600:460): apparmor="DENIED" operation="mkdir" profile="/usr/bin/traced" name="/var/lib/x/" pid=2000 comm="traced" requested_mask="c" denied_mask="c" fsuid=1000 ouid=0
+`
+
+func (s *denialsTestSuite) TestDenials(c *check.C) {
+	since := time.Unix(1580155329, 0)
+	defer snaps.MockJournalctl(func(t time.Time) ([]byte, error) {
+		c.Check(t, check.Equals, since)
+		return []byte(sampleKernelLog), nil
+	})()
+
+	// only denials after since for the snap or the pids count, and not ones
+	// for other snaps or which were only logged in complain mode
+	denials, err := snaps.Denials(since, []int{2000}, "hello.hello")
+	c.Assert(err, check.IsNil)
+	c.Assert(denials, check.HasLen, 3)
+	c.Check(denials[0], check.DeepEquals, snaps.Denial{
+		Time:      time.Unix(1580155329, 123000000),
+		Kind:      "apparmor",
+		Profile:   "snap.hello.hello",
+		Pid:       1234,
+		Comm:      "hello",
+		Operation: "open",
+		Name:      "/etc/foo",
+	})
+	c.Check(denials[1].Kind, check.Equals, "seccomp")
+	c.Check(denials[1].Operation, check.Equals, "165")
+	c.Check(denials[2].Profile, check.Equals, "/usr/bin/traced")
+}
@@ -18,11 +18,20 @@
 package snaps
 
 import (
+	"fmt"
 	"log"
 	"os/exec"
 	"strings"
 )
 
+// Info is the version information of an installed snap
+type Info struct {
+	Name     string
+	Version  string
+	Revision string
+	Channel  string
+}
+
 // DiscardSnapNs runs snap-discard-ns on a snap to get an accurate startup time
 // of setting up that snap's namespace
 func DiscardSnapNs(snap string) error {
@@ -41,3 +50,36 @@ func Revision(snap string) (string, error) {
 	}
 	return strings.TrimSpace(string(out)), err
 }
+
+// SnapInfo returns the version, revision and tracked channel of an installed
+// snap as reported by snap list, snap can also be a snap.app name as used with
+// snap run
+func SnapInfo(snap string) (*Info, error) {
+	snap = strings.SplitN(snap, ".", 2)[0]
+	out, err := exec.Command("snap", "list", snap).CombinedOutput()
+	if err != nil {
+		log.Println(string(out))
+		return nil, err
+	}
+	return parseSnapList(snap, string(out))
+}
+
+// output looks like:
+// Name      Version        Rev   Tracking       Publisher   Notes
+// chromium  79.0.3945.130  1032  latest/stable  canonical✓  -
+func parseSnapList(snap, out string) (*Info, error) {
+	for _, line := range strings.Split(strings.TrimSpace(out), "\n")[1:] {
+		fields := strings.Fields(line)
+		if len(fields) < 4 || fields[0] != snap {
+			continue
+		}
+		return &Info{
+			Name:     fields[0],
+			Version:  fields[1],
+			Revision: fields[2],
+			// locally installed snaps don't track a channel and show "-"
+			Channel: fields[3],
+		}, nil
+	}
+	return nil, fmt.Errorf("snap %s not found in snap list output", snap)
+}
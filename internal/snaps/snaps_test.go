@@ -0,0 +1,89 @@
+/*
+ * Copyright (C) 2019 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package snaps_test
+
+import (
+	"github.com/anonymouse64/etrace/internal/snaps"
+
+	"gopkg.in/check.v1"
+)
+
+type snapsTestSuite struct{}
+
+var _ = check.Suite(&snapsTestSuite{})
+
+const snapListHeader = "Name      Version        Rev   Tracking       Publisher   Notes\n"
+
+func (s *snapsTestSuite) TestParseSnapList(c *check.C) {
+	tt := []struct {
+		comment string
+		snap    string
+		out     string
+		info    *snaps.Info
+		err     string
+	}{
+		{
+			comment: "store snap",
+			snap:    "chromium",
+			out:     snapListHeader + "chromium  79.0.3945.130  1032  latest/stable  canonical✓  -\n",
+			info:    &snaps.Info{Name: "chromium", Version: "79.0.3945.130", Revision: "1032", Channel: "latest/stable"},
+		},
+		{
+			comment: "locally installed snap",
+			snap:    "hello",
+			out:     snapListHeader + "hello     2.10           x1    -              -           -\n",
+			info:    &snaps.Info{Name: "hello", Version: "2.10", Revision: "x1", Channel: "-"},
+		},
+		{
+			comment: "other snaps listed",
+			snap:    "hello",
+			out: snapListHeader +
+				"hello-world  6.4   29    latest/stable  canonical✓  -\n" +
+				"hello        2.10  38    latest/edge    canonical✓  -\n",
+			info: &snaps.Info{Name: "hello", Version: "2.10", Revision: "38", Channel: "latest/edge"},
+		},
+		{
+			comment: "short line",
+			snap:    "hello",
+			out:     snapListHeader + "hello 2.10\n",
+			err:     "snap hello not found in snap list output",
+		},
+		{
+			comment: "header only",
+			snap:    "hello",
+			out:     snapListHeader,
+			err:     "snap hello not found in snap list output",
+		},
+		{
+			comment: "no output",
+			snap:    "hello",
+			err:     "snap hello not found in snap list output",
+		},
+	}
+	for _, t := range tt {
+		comment := check.Commentf(t.comment)
+		info, err := snaps.ParseSnapList(t.snap, t.out)
+		if t.err != "" {
+			c.Check(err, check.ErrorMatches, t.err, comment)
+			c.Check(info, check.IsNil, comment)
+			continue
+		}
+		c.Assert(err, check.IsNil, comment)
+		c.Check(info, check.DeepEquals, t.info, comment)
+	}
+}
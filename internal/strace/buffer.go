@@ -0,0 +1,120 @@
+/*
+ * Copyright (C) 2019 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package strace
+
+import (
+	"bytes"
+	"io"
+	"sync"
+	"time"
+)
+
+// BufferedReader reads everything from a reader in the background as fast as
+// it can, keeping up to a fixed number of bytes in memory until they are read
+// from it. Reading a fifo through it means the writer, strace, is only held
+// up once parsing the trace falls behind by a whole buffer, rather than by
+// the kernel's much smaller pipe buffer.
+type BufferedReader struct {
+	mu   sync.Mutex
+	cond *sync.Cond
+	buf  bytes.Buffer
+	size int
+	// err is the error reading stopped with, io.EOF included
+	err error
+	// closed is whether nothing more will be read, after which whatever
+	// is left is discarded
+	closed  bool
+	stalls  int
+	stalled time.Duration
+}
+
+// NewBufferedReader returns a BufferedReader reading r with a buffer of size
+// bytes
+func NewBufferedReader(r io.Reader, size int) *BufferedReader {
+	b := &BufferedReader{size: size}
+	b.cond = sync.NewCond(&b.mu)
+	go b.fill(r)
+	return b
+}
+
+func (b *BufferedReader) fill(r io.Reader) {
+	chunk := make([]byte, 64*1024)
+	for {
+		n, err := r.Read(chunk)
+
+		b.mu.Lock()
+		if n != 0 && b.buf.Len()+n > b.size && !b.closed {
+			// the writer is held up until there's room again
+			start := time.Now()
+			b.stalls++
+			for b.buf.Len() != 0 && b.buf.Len()+n > b.size && !b.closed {
+				b.cond.Wait()
+			}
+			b.stalled += time.Since(start)
+		}
+		if !b.closed {
+			b.buf.Write(chunk[:n])
+		}
+		if err != nil {
+			b.err = err
+		}
+		b.cond.Broadcast()
+		b.mu.Unlock()
+
+		if err != nil {
+			return
+		}
+	}
+}
+
+// Read reads what has been buffered, waiting for more if nothing has
+func (b *BufferedReader) Read(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for b.buf.Len() == 0 && b.err == nil && !b.closed {
+		b.cond.Wait()
+	}
+	if b.closed {
+		return 0, io.EOF
+	}
+	if b.buf.Len() == 0 {
+		return 0, b.err
+	}
+	n, _ := b.buf.Read(p)
+	b.cond.Broadcast()
+	return n, nil
+}
+
+// Close stops buffering, anything still read in the background is discarded
+// so that the writer isn't blocked when what's left isn't needed
+func (b *BufferedReader) Close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.closed = true
+	b.buf.Reset()
+	b.cond.Broadcast()
+	return nil
+}
+
+// Stalled returns how many times reading in the background waited for room in
+// the full buffer and for how long in total
+func (b *BufferedReader) Stalled() (int, time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.stalls, b.stalled
+}
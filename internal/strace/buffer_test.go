@@ -0,0 +1,75 @@
+/*
+ * Copyright (C) 2019 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package strace_test
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"time"
+
+	"github.com/anonymouse64/etrace/internal/strace"
+
+	"gopkg.in/check.v1"
+)
+
+type bufferTestSuite struct{}
+
+var _ = check.Suite(&bufferTestSuite{})
+
+func (s *bufferTestSuite) TestBufferedReader(c *check.C) {
+	data := bytes.Repeat([]byte("0123456789abcdef"), 64*1024)
+	pr, pw := io.Pipe()
+	go func() {
+		pw.Write(data)
+		pw.Close()
+	}()
+
+	b := strace.NewBufferedReader(pr, 256*1024)
+	// fall behind so that the buffer fills up
+	time.Sleep(50 * time.Millisecond)
+	read, err := ioutil.ReadAll(b)
+	c.Assert(err, check.IsNil)
+	c.Check(read, check.DeepEquals, data)
+	stalls, stalled := b.Stalled()
+	c.Check(stalls > 0, check.Equals, true)
+	c.Check(stalled > 0, check.Equals, true)
+}
+
+func (s *bufferTestSuite) TestBufferedReaderClose(c *check.C) {
+	pr, pw := io.Pipe()
+	written := make(chan error)
+	go func() {
+		_, err := pw.Write(make([]byte, 1024*1024))
+		pw.Close()
+		written <- err
+	}()
+
+	b := strace.NewBufferedReader(pr, 1024)
+	c.Assert(b.Close(), check.IsNil)
+	// the rest is discarded rather than blocking the writer
+	select {
+	case err := <-written:
+		c.Check(err, check.IsNil)
+	case <-time.After(5 * time.Second):
+		c.Fatal("writer blocked after closing the reader")
+	}
+	n, err := b.Read(make([]byte, 10))
+	c.Check(n, check.Equals, 0)
+	c.Check(err, check.Equals, io.EOF)
+}
@@ -19,8 +19,12 @@ package strace
 
 import (
 	"fmt"
+	"os"
 	"os/exec"
 	"os/user"
+	"strconv"
+	"strings"
+	"time"
 )
 
 // These syscalls are excluded because they make strace hang on all or
@@ -44,29 +48,158 @@ func straceCommand(extraStraceOpts []string, traceeCmd ...string) (*exec.Cmd, er
 		return nil, fmt.Errorf("cannot find an installed strace, please try 'snap install strace-static'")
 	}
 
+	args := straceArgs(sudoPath, stracePath, current.Username, extraStraceOpts, traceeCmd...)
+
+	return &exec.Cmd{
+		Path: sudoPath,
+		Args: args,
+	}, nil
+}
+
+// straceArgs returns the full argv to run strace with sudo as username
+func straceArgs(sudoPath, stracePath, username string, extraStraceOpts []string, traceeCmd ...string) []string {
 	args := []string{
 		sudoPath,
 		"-E",
 		stracePath,
-		"-u", current.Username,
+		"-u", username,
 		"-f",
 		"-e", excludedSyscalls,
 	}
 	args = append(args, extraStraceOpts...)
-	args = append(args, traceeCmd...)
+	return append(args, traceeCmd...)
+}
 
-	return &exec.Cmd{
-		Path: sudoPath,
-		Args: args,
-	}, nil
+// TraceOptions are additional options for tracing a command
+type TraceOptions struct {
+	// Env is extra environment variables in the form VAR=value to set for
+	// the traced command, these are set by strace itself so that sudo can't
+	// reset them
+	Env []string
+	// FailedOpens also traces file syscalls to collect the paths which
+	// failed to be accessed because they don't exist
+	FailedOpens bool
+	// MappedFiles also traces mmap() to collect the files which were
+	// executed or mapped into memory by the command
+	MappedFiles bool
+	// Futexes also traces futex() to report how long threads waited on locks
+	Futexes bool
+	// Processes also traces clone() and the other process management
+	// syscalls to count the threads created and know which process created
+	// every other one
+	Processes bool
+	// FirstDraw also traces connect() and writes to estimate when the
+	// command first drew something on the X server
+	FirstDraw bool
+	// Network also traces socket() and connect() to collect the connections
+	// to other hosts the command attempted
+	Network bool
+	// MaxEvents if not 0 is how many exec events and failed paths are kept
+	// when reading the trace, any after that are only counted, and how many
+	// of the slowest syscalls are kept
+	MaxEvents int
+	// StringLimit if not 0 is the longest string argument strace prints
+	// before truncating it, instead of strace's default of 32
+	StringLimit int
+	// MinSyscallDuration if not 0 traces every syscall, apart from the
+	// excluded ones, to collect the ones which took at least this long
+	MinSyscallDuration time.Duration
+}
+
+// syscalls returns the set of syscalls to trace for opts
+func (opts TraceOptions) syscalls() string {
+	// any syscall could be a slow one
+	if opts.MinSyscallDuration != 0 {
+		return "trace=" + excludedSyscalls
+	}
+	syscalls := []string{"execve", "execveat"}
+	if opts.Processes {
+		// %process is execve{,at} along with clone{,3} and the rest of the
+		// process management syscalls, it's used rather than listing clone3
+		// as older strace versions don't know it
+		syscalls = []string{"%process"}
+	}
+	if opts.FailedOpens {
+		syscalls = append(syscalls, "%file")
+	}
+	if opts.MappedFiles {
+		syscalls = append(syscalls, "mmap", "mmap2")
+	}
+	if opts.Futexes {
+		syscalls = append(syscalls, "futex")
+	}
+	if opts.FirstDraw {
+		syscalls = append(syscalls, "connect", "write", "writev", "sendmsg")
+	}
+	if opts.Network {
+		syscalls = append(syscalls, "socket", "connect")
+	}
+	return "trace=" + strings.Join(syscalls, ",")
+}
+
+func traceExecOpts(straceLogPath string, opts TraceOptions) []string {
+	extraStraceOpts := []string{"-ttt", "-e", opts.syscalls(), "-o", fmt.Sprintf("%s", straceLogPath)}
+	if opts.MappedFiles {
+		// show the paths of the fds being mapped
+		extraStraceOpts = append(extraStraceOpts, "-y")
+	}
+	if opts.Futexes || opts.Network || opts.MinSyscallDuration != 0 {
+		// show the time spent in each syscall
+		extraStraceOpts = append(extraStraceOpts, "-T")
+	}
+	if opts.StringLimit != 0 {
+		extraStraceOpts = append(extraStraceOpts, "-s", strconv.Itoa(opts.StringLimit))
+	}
+	for _, env := range opts.Env {
+		extraStraceOpts = append(extraStraceOpts, "-E", env)
+	}
+	return extraStraceOpts
 }
 
 // TraceExecCommand returns an exec.Cmd suitable for tracking timings of
 // execve{,at}() calls
-func TraceExecCommand(straceLogPath string, origCmd ...string) (*exec.Cmd, error) {
-	extraStraceOpts := []string{"-ttt", "-e", "trace=execve,execveat", "-o", fmt.Sprintf("%s", straceLogPath)}
+func TraceExecCommand(straceLogPath string, opts TraceOptions, origCmd ...string) (*exec.Cmd, error) {
+	return straceCommand(traceExecOpts(straceLogPath, opts), origCmd...)
+}
 
-	return straceCommand(extraStraceOpts, origCmd...)
+// TemplateCommand returns an exec.Cmd from a user provided tracer command
+// template, which is split on whitespace. {fifo} is replaced with the path the
+// tracer should write its output to and a {cmd} argument with the command to
+// trace. The environment in opts is set for the tracer, which is expected to
+// pass it on to the command.
+func TemplateCommand(template, outputPath string, opts TraceOptions, origCmd ...string) (*exec.Cmd, error) {
+	var args []string
+	foundCmd := false
+	for _, arg := range strings.Fields(template) {
+		if arg == "{cmd}" {
+			args = append(args, origCmd...)
+			foundCmd = true
+			continue
+		}
+		args = append(args, strings.Replace(arg, "{fifo}", outputPath, -1))
+	}
+	if !foundCmd {
+		return nil, fmt.Errorf("tracer command template %q has no {cmd} argument", template)
+	}
+	tracerPath, err := exec.LookPath(args[0])
+	if err != nil {
+		return nil, fmt.Errorf("cannot find tracer: %w", err)
+	}
+	cmd := &exec.Cmd{
+		Path: tracerPath,
+		Args: args,
+	}
+	if len(opts.Env) != 0 {
+		cmd.Env = append(os.Environ(), opts.Env...)
+	}
+	return cmd, nil
+}
+
+// RemoteTraceExecArgs returns the argv to track timings of execve{,at}() calls
+// as username on another machine, the strace log is written to straceLogPath on
+// that machine and sudo and strace are expected to be on its $PATH
+func RemoteTraceExecArgs(username, straceLogPath string, opts TraceOptions, origCmd ...string) []string {
+	return straceArgs("sudo", "strace", username, traceExecOpts(straceLogPath, opts), origCmd...)
 }
 
 // TraceFilesCommand returns an exec.Cmd suitable for tracking files opened/used
@@ -99,3 +232,18 @@ func TraceFilesCommand(straceLogPattern string, origCmd ...string) (*exec.Cmd, e
 
 	return straceCommand(extraStraceOpts, origCmd...)
 }
+
+// Version returns the version string of the installed strace
+func Version() (string, error) {
+	stracePath, err := exec.LookPath("strace")
+	if err != nil {
+		return "", fmt.Errorf("cannot find an installed strace, please try 'snap install strace-static'")
+	}
+	out, err := exec.Command(stracePath, "-V").CombinedOutput()
+	if err != nil {
+		return "", err
+	}
+	// the first line looks like:
+	// strace -- version 5.5
+	return strings.SplitN(strings.TrimSpace(string(out)), "\n", 2)[0], nil
+}
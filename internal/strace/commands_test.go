@@ -0,0 +1,66 @@
+/*
+ * Copyright (C) 2019 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package strace_test
+
+import (
+	"os"
+	"time"
+
+	"github.com/anonymouse64/etrace/internal/strace"
+
+	"gopkg.in/check.v1"
+)
+
+type commandsTestSuite struct{}
+
+var _ = check.Suite(&commandsTestSuite{})
+
+func (s *commandsTestSuite) TestTemplateCommand(c *check.C) {
+	opts := strace.TraceOptions{Env: []string{"DISPLAY=:1"}}
+	cmd, err := strace.TemplateCommand("sh -c true -o={fifo} {cmd}", "/tmp/log", opts, "hello", "--world")
+	c.Assert(err, check.IsNil)
+	c.Check(cmd.Args, check.DeepEquals, []string{"sh", "-c", "true", "-o=/tmp/log", "hello", "--world"})
+	c.Check(cmd.Env, check.DeepEquals, append(os.Environ(), "DISPLAY=:1"))
+
+	_, err = strace.TemplateCommand("sh -o {fifo}", "/tmp/log", strace.TraceOptions{}, "hello")
+	c.Check(err, check.ErrorMatches, `tracer command template "sh -o {fifo}" has no {cmd} argument`)
+}
+
+func (s *commandsTestSuite) TestRemoteTraceExecArgsStringLimit(c *check.C) {
+	args := strace.RemoteTraceExecArgs("user", "/tmp/log", strace.TraceOptions{StringLimit: 4096}, "hello")
+	c.Check(args[len(args)-4:], check.DeepEquals, []string{"/tmp/log", "-s", "4096", "hello"})
+
+	args = strace.RemoteTraceExecArgs("user", "/tmp/log", strace.TraceOptions{}, "hello")
+	c.Check(args[len(args)-2:], check.DeepEquals, []string{"/tmp/log", "hello"})
+}
+
+func (s *commandsTestSuite) TestRemoteTraceExecArgsMinSyscallDuration(c *check.C) {
+	// every syscall but the ones which make strace hang is traced, with the
+	// time spent in each
+	args := strace.RemoteTraceExecArgs("user", "/tmp/log", strace.TraceOptions{MinSyscallDuration: time.Millisecond, FailedOpens: true}, "hello")
+	c.Check(args[len(args)-6:], check.DeepEquals, []string{"-e", "trace=!select,pselect6,_newselect,clock_gettime,sigaltstack,gettid,gettimeofday,nanosleep", "-o", "/tmp/log", "-T", "hello"})
+}
+
+func (s *commandsTestSuite) TestRemoteTraceExecArgsProcesses(c *check.C) {
+	// only the execs are traced by default
+	args := strace.RemoteTraceExecArgs("user", "/tmp/log", strace.TraceOptions{}, "hello")
+	c.Check(args[len(args)-5:], check.DeepEquals, []string{"-e", "trace=execve,execveat", "-o", "/tmp/log", "hello"})
+
+	args = strace.RemoteTraceExecArgs("user", "/tmp/log", strace.TraceOptions{Processes: true, Futexes: true}, "hello")
+	c.Check(args[len(args)-6:], check.DeepEquals, []string{"-e", "trace=%process,futex", "-o", "/tmp/log", "-T", "hello"})
+}
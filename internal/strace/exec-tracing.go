@@ -23,16 +23,23 @@ import (
 	"io"
 	"math"
 	"os"
+	"path/filepath"
 	"regexp"
 	"sort"
 	"strconv"
+	"strings"
 	"time"
 )
 
 // ExeRuntime is the runtime of an individual executable
 type ExeRuntime struct {
-	Start    time.Time
-	Exe      string
+	Start time.Time
+	Exe   string
+	// Syscall is the syscall used to execute Exe, execve or execveat
+	Syscall string
+	// Argv is the arguments Exe was executed with as strace shows them, so
+	// long arguments may be cut short with ... appended
+	Argv     []string `json:",omitempty"`
 	TotalSec time.Duration
 	pid      string
 }
@@ -42,11 +49,40 @@ type ExeRuntime struct {
 type ExecveTiming struct {
 	TotalTime   time.Duration
 	ExeRuntimes []ExeRuntime
-	indent      string
-
-	// pidChildren *pidChildTracker
+	// DroppedExeRuntimes is how many execs weren't kept because of
+	// TraceOptions.MaxEvents
+	DroppedExeRuntimes int
+	// SkippedLines is how many lines of the strace log couldn't be parsed,
+	// such as ones from a different strace version, and were ignored
+	SkippedLines int `json:",omitempty"`
+	// Slowest is the exec which took the longest
+	Slowest *ExeRuntime
+	// FailedOpens is only collected with TraceOptions.FailedOpens
+	FailedOpens *FailedOpens
+	// Futexes is only collected with TraceOptions.Futexes
+	Futexes *FutexStats
+	// Network is only collected with TraceOptions.Network
+	Network *NetworkActivity
+	// SlowSyscalls are only collected with TraceOptions.MinSyscallDuration,
+	// slowest first
+	SlowSyscalls []SlowSyscall `json:",omitempty"`
+	// TimeToFirstDraw is when the first drawing request was sent to the X
+	// server, only estimated with TraceOptions.FirstDraw
+	TimeToFirstDraw time.Duration
+	// MappedFiles is only collected with TraceOptions.MappedFiles
+	MappedFiles []string
+	// ThreadsCreated is the number of threads created with clone() and
+	// PeakThreads the most of them running at the same time
+	ThreadsCreated int
+	PeakThreads    int
+	indent         string
+
+	// parents maps every process created during the trace to the process
+	// which created it
+	parents map[string]string
 
 	nSlowestSamples int
+	maxEvents       int
 
 	*pidTracker
 }
@@ -55,7 +91,7 @@ type execveTimingTracer interface {
 	addExeRuntime(start float64, exe string, totalSec float64, pid string)
 
 	getPid(pid string) (startTime float64, exe string)
-	addPid(pid string, startTime float64, exe string)
+	addPid(pid string, startTime float64, exe string, syscall string)
 	deletePid(pid string)
 }
 
@@ -72,16 +108,25 @@ func unixFloatSecondsToTime(t float64) time.Time {
 // newExecveTiming returns a new ExecveTiming struct that keeps
 // the given amount of the slowest exec samples.
 // if nSlowestSamples is equal to 0, all exec samples are kept
-func newExecveTiming(nSlowestSamples int) *ExecveTiming {
-	e := &ExecveTiming{nSlowestSamples: nSlowestSamples}
+func newExecveTiming(nSlowestSamples int, opts TraceOptions) *ExecveTiming {
+	e := &ExecveTiming{nSlowestSamples: nSlowestSamples, maxEvents: opts.MaxEvents}
 	e.pidTracker = newpidTracker()
+	if opts.FailedOpens {
+		e.FailedOpens = newFailedOpens(opts.MaxEvents)
+	}
 	return e
 }
 
 func (stt *ExecveTiming) addExeRuntime(start float64, exe string, totalSec float64, pid string) {
+	if stt.maxEvents > 0 && len(stt.ExeRuntimes) >= stt.maxEvents {
+		stt.DroppedExeRuntimes++
+		return
+	}
 	stt.ExeRuntimes = append(stt.ExeRuntimes, ExeRuntime{
 		Start:    unixFloatSecondsToTime(start),
 		Exe:      exe,
+		Syscall:  stt.getSyscall(pid),
+		Argv:     stt.getArgv(pid),
 		TotalSec: time.Duration(totalSec * float64(time.Second)),
 		pid:      pid,
 	})
@@ -90,6 +135,18 @@ func (stt *ExecveTiming) addExeRuntime(start float64, exe string, totalSec float
 	}
 }
 
+// findSlowest sets Slowest to a copy of the longest running exec, it's a copy
+// since Display re-orders ExeRuntimes
+func (stt *ExecveTiming) findSlowest() {
+	stt.Slowest = nil
+	for _, rt := range stt.ExeRuntimes {
+		if stt.Slowest == nil || rt.TotalSec > stt.Slowest.TotalSec {
+			slowest := rt
+			stt.Slowest = &slowest
+		}
+	}
+}
+
 // prune() ensures the number of ExeRuntimes stays with the nSlowestSamples
 // limit
 func (stt *ExecveTiming) prune() {
@@ -105,37 +162,208 @@ func (stt *ExecveTiming) prune() {
 	}
 }
 
+// DefaultColumns are the columns shown by Display when none are specified
+var DefaultColumns = []string{"start", "stop", "elapsed", "exec"}
+
+var columnTitles = map[string]string{
+	"start":   "Start",
+	"stop":    "Stop",
+	"elapsed": "Elapsed",
+	"exec":    "Exec",
+	"argv":    "Command line",
+}
+
+// ParseColumns parses a comma separated list of column names for Display
+func ParseColumns(spec string) ([]string, error) {
+	var columns []string
+	for _, col := range strings.Split(spec, ",") {
+		col = strings.ToLower(strings.TrimSpace(col))
+		if _, ok := columnTitles[col]; !ok {
+			return nil, fmt.Errorf("unknown column %q", col)
+		}
+		columns = append(columns, col)
+	}
+	return columns, nil
+}
+
+// DisplayOptions controls what Display shows
+type DisplayOptions struct {
+	// Columns are shown in the given order, DefaultColumns is used if empty
+	Columns []string
+	// Syscalls if not empty only shows events from one of these syscalls
+	Syscalls []string
+	// PathGlob if not empty only shows events for paths matching this glob
+	PathGlob string
+	// Precision if not zero rounds durations to this many significant digits
+	Precision int
+	// Tree shows the execs indented under the exec which led to them instead
+	// of the columns, it ignores the filters
+	Tree bool
+}
+
+// RoundDuration rounds d to the given number of significant digits, or leaves
+// it as is if digits is zero
+func RoundDuration(d time.Duration, digits int) time.Duration {
+	if digits <= 0 {
+		return d
+	}
+	limit := int64(1)
+	for i := 0; i < digits && limit < math.MaxInt64/10; i++ {
+		limit *= 10
+	}
+	n := int64(d)
+	if n < 0 {
+		n = -n
+	}
+	unit := time.Duration(1)
+	for ; n >= limit; n /= 10 {
+		unit *= 10
+	}
+	return d.Round(unit)
+}
+
+// filtered returns whether any events are hidden by the options
+func (opts DisplayOptions) filtered() bool {
+	return len(opts.Syscalls) != 0 || opts.PathGlob != ""
+}
+
+// matches returns whether an event from syscall for path should be shown
+func (opts DisplayOptions) matches(syscalls []string, path string) bool {
+	if len(opts.Syscalls) != 0 {
+		found := false
+		for _, want := range opts.Syscalls {
+			for _, syscall := range syscalls {
+				if syscall == want {
+					found = true
+				}
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if opts.PathGlob != "" {
+		// the glob is checked when parsing options
+		if ok, _ := filepath.Match(opts.PathGlob, path); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+func columnValue(col string, rt ExeRuntime, relativeStart time.Duration, precision int) string {
+	switch col {
+	case "start":
+		return strconv.FormatInt(int64(relativeStart/time.Microsecond), 10)
+	case "stop":
+		return strconv.FormatInt(int64((relativeStart+rt.TotalSec)/time.Microsecond), 10)
+	case "elapsed":
+		return RoundDuration(rt.TotalSec, precision).String()
+	case "exec":
+		return rt.Exe
+	case "argv":
+		return commandLine(rt.Argv)
+	}
+	return ""
+}
+
+// commandLine joins argv into a command line, quoting the arguments which
+// would otherwise be ambiguous
+func commandLine(argv []string) string {
+	quoted := make([]string, len(argv))
+	for i, arg := range argv {
+		if arg == "" || strings.ContainsAny(arg, " \t\n\"'\\") {
+			arg = strconv.Quote(arg)
+		}
+		quoted[i] = arg
+	}
+	return strings.Join(quoted, " ")
+}
+
 // Display shows the final exec timing output
-func (stt *ExecveTiming) Display(w io.Writer) {
+func (stt *ExecveTiming) Display(w io.Writer, opts DisplayOptions) {
 	if len(stt.ExeRuntimes) == 0 {
 		return
 	}
 
-	fmt.Fprintf(w, "%d exec calls during snap run:\n", len(stt.ExeRuntimes))
-	fmt.Fprintf(w, "\tStart\tStop\tElapsed\tExec\n")
+	columns := opts.Columns
+	if len(columns) == 0 {
+		columns = DefaultColumns
+	}
 
 	sort.Slice(stt.ExeRuntimes, func(i, j int) bool {
 		return stt.ExeRuntimes[i].Start.Before(stt.ExeRuntimes[j].Start)
 	})
 
+	var shown []ExeRuntime
+	for _, rt := range stt.ExeRuntimes {
+		if opts.matches([]string{rt.Syscall}, rt.Exe) {
+			shown = append(shown, rt)
+		}
+	}
+
+	if opts.filtered() {
+		fmt.Fprintf(w, "%d of %d exec calls during snap run match the filter:\n", len(shown), len(stt.ExeRuntimes))
+	} else {
+		fmt.Fprintf(w, "%d exec calls during snap run:\n", len(stt.ExeRuntimes))
+	}
+	if stt.DroppedExeRuntimes != 0 {
+		fmt.Fprintf(w, "(%d more exec calls were dropped because of the event limit)\n", stt.DroppedExeRuntimes)
+	}
+	if opts.Tree {
+		stt.displayTree(w, opts.Precision)
+		stt.displaySummary(w, opts)
+		return
+	}
+	for _, col := range columns {
+		fmt.Fprintf(w, "\t%s", columnTitles[col])
+	}
+	fmt.Fprintln(w)
+
 	// TODO: this shows processes linearly, when really I think we want a
 	// tree/forest style output showing forked processes indented underneath the
 	// parent, with exec'd processes lined up with their previous executable
 	// but note that doing so in the most generic case isn't neat since you can
 	// have processes that are forked much later than others and will be aligned
 	// with previous executables much earlier in the output
-	for _, rt := range stt.ExeRuntimes {
+	for _, rt := range shown {
+		// times are still relative to the very first exec
 		relativeStart := rt.Start.Sub(stt.ExeRuntimes[0].Start)
-		fmt.Fprintf(w,
-			"\t%d\t%d\t%v\t%s\n",
-			int64(relativeStart/time.Microsecond),
-			int64((relativeStart+rt.TotalSec)/time.Microsecond),
-			rt.TotalSec,
-			rt.Exe,
-		)
+		for _, col := range columns {
+			fmt.Fprintf(w, "\t%s", columnValue(col, rt, relativeStart, opts.Precision))
+		}
+		fmt.Fprintln(w)
 	}
+	stt.displaySummary(w, opts)
+}
 
-	fmt.Fprintln(w, "Total time: ", stt.TotalTime)
+// displaySummary shows what Display shows after the execs
+func (stt *ExecveTiming) displaySummary(w io.Writer, opts DisplayOptions) {
+	if stt.Slowest != nil {
+		fmt.Fprintf(w, "Slowest exec: %s (%v)\n", stt.Slowest.Exe, RoundDuration(stt.Slowest.TotalSec, opts.Precision))
+	}
+	if stt.ThreadsCreated != 0 {
+		fmt.Fprintf(w, "Threads created: %d (at most %d running at once)\n", stt.ThreadsCreated, stt.PeakThreads)
+	}
+	if stt.Futexes != nil {
+		stt.Futexes.Display(w, stt.TotalTime)
+	}
+	if stt.TimeToFirstDraw != 0 {
+		fmt.Fprintln(w, "Time to first draw: ", RoundDuration(stt.TimeToFirstDraw, opts.Precision))
+	}
+	if stt.FailedOpens != nil {
+		stt.FailedOpens.Display(w, opts)
+	}
+	if stt.Network != nil {
+		stt.Network.Display(w, opts)
+	}
+	if len(stt.SlowSyscalls) != 0 {
+		displaySlowSyscalls(w, stt.SlowSyscalls, opts)
+	}
+	if stt.SkippedLines != 0 {
+		fmt.Fprintf(w, "Skipped strace lines: %d couldn't be parsed\n", stt.SkippedLines)
+	}
+	fmt.Fprintln(w, "Total time: ", RoundDuration(stt.TotalTime, opts.Precision))
 }
 
 // TODO: can execve calls be "interrupted" like clone() below?
@@ -159,6 +387,49 @@ var sigChldTermRE = regexp.MustCompile(`[0-9]+\ +([0-9.]+).*SIG(CHLD|TERM)\ {.*s
 // 20882 1573257274.988650 +++ killed by SIGKILL +++
 var sigkillRE = regexp.MustCompile(`([0-9]+)\ +([0-9.]+) \+\+\+ killed by SIGKILL \+\+\+`)
 
+// execArgv returns the arguments of the exec on line, where strace shows
+// arguments it cut short followed by ... and arguments it left out as ...
+func execArgv(line string) []string {
+	i := strings.Index(line, ", [")
+	if i < 0 {
+		return nil
+	}
+	s := line[i+len(", ["):]
+	var argv []string
+	for {
+		switch {
+		case strings.HasPrefix(s, `"`):
+			// find the closing quote, skipping escaped characters
+			end := 1
+			for end < len(s) && s[end] != '"' {
+				if s[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			if end >= len(s) {
+				return argv
+			}
+			arg := string(unescapeStraceString(s[1:end]))
+			s = s[end+1:]
+			if strings.HasPrefix(s, "...") {
+				arg += "..."
+				s = s[len("..."):]
+			}
+			argv = append(argv, arg)
+		case strings.HasPrefix(s, "..."):
+			argv = append(argv, "...")
+			s = s[len("..."):]
+		default:
+			return argv
+		}
+		if !strings.HasPrefix(s, ", ") {
+			return argv
+		}
+		s = s[len(", "):]
+	}
+}
+
 // this is a silly function but de-duplicates the code
 func parsePIDAndReturnOthers(match []string) (string, float64, string, error) {
 	execStart, err := strconv.ParseFloat(match[2], 64)
@@ -171,7 +442,7 @@ func parsePIDAndReturnOthers(match []string) (string, float64, string, error) {
 	return match[1], execStart, match[3], nil
 }
 
-func handleExecMatch(trace execveTimingTracer, match []string) error {
+func handleExecMatch(trace execveTimingTracer, syscall string, match []string) error {
 	if len(match) == 0 {
 		return nil
 	}
@@ -185,7 +456,7 @@ func handleExecMatch(trace execveTimingTracer, match []string) error {
 	if start, exe := trace.getPid(pid); exe != "" {
 		trace.addExeRuntime(start, exe, execStart-start, pid)
 	}
-	trace.addPid(pid, execStart, exe)
+	trace.addPid(pid, execStart, exe, syscall)
 	return nil
 }
 
@@ -223,48 +494,70 @@ func handleSigkillMatch(trace execveTimingTracer, match []string) error {
 	return nil
 }
 
-// func handleCloneMatch(trace *ExecveTiming, pct *pidChildTracker, match []string) error {
-// 	if len(match) == 0 {
-// 		return nil
-// 	}
-// 	// the pid of the parent process clone()ing a new child
-// 	ppid := match[1]
-
-// 	// the time the child was created
-// 	execStart, err := strconv.ParseFloat(match[2], 64)
-// 	if err != nil {
-// 		return err
-// 	}
-
-// 	// the pid of the new child
-// 	pid := match[3]
-// 	pct.Add(ppid, pid, execStart)
-// 	return nil
-// }
+// maxLineLen is the longest strace log line which is parsed, longer ones are an
+// error
+const maxLineLen = 16 * 1024 * 1024
 
 // TraceExecveTimings will read an strace log and produce a timing report of the
 // n slowest exec's
-func TraceExecveTimings(straceLog string, nSlowest int) (*ExecveTiming, error) {
+func TraceExecveTimings(straceLog string, nSlowest int, opts TraceOptions) (*ExecveTiming, error) {
 	slog, err := os.Open(straceLog)
 	if err != nil {
 		return nil, err
 	}
 	defer slog.Close()
 
-	// pidChildTracker := newPidChildTracker()
+	return ReadExecveTimings(slog, nSlowest, opts)
+}
 
+// ReadExecveTimings is like TraceExecveTimings, but reads the strace log from
+// slog
+func ReadExecveTimings(slog io.Reader, nSlowest int, opts TraceOptions) (*ExecveTiming, error) {
 	var line string
 	var start, end float64
 	var startPID, endPID int
-	trace := newExecveTiming(nSlowest)
+	trace := newExecveTiming(nSlowest, opts)
+	threads := newThreadTracker()
+	procs := newProcessTree()
+	var mapped mappedFiles
+	var futexes *futexTracker
+	if opts.Futexes {
+		futexes = newFutexTracker()
+	}
+	var network *networkTracker
+	if opts.Network {
+		network = newNetworkTracker()
+	}
+	var slow *slowSyscallTracker
+	if opts.MinSyscallDuration != 0 {
+		slow = newSlowSyscallTracker(opts.MinSyscallDuration)
+	}
+	var draws *firstDrawTracker
+	if opts.FirstDraw {
+		draws = newFirstDrawTracker()
+	}
+	if opts.MappedFiles {
+		mapped = make(mappedFiles)
+	}
 	r := bufio.NewScanner(slog)
+	// long string arguments with --strace-string-limit can make for lines
+	// longer than the default limit
+	r.Buffer(nil, maxLineLen)
 	for r.Scan() {
 		line = r.Text()
+		// every line should start with the pid and time, anything else is
+		// from a strace version we don't know about and is skipped rather
+		// than losing the whole trace
+		var pid int
+		var t float64
+		if _, err := fmt.Sscanf(line, "%d %f ", &pid, &t); err != nil {
+			trace.SkippedLines++
+			continue
+		}
 		if start == 0.0 {
-			if _, err := fmt.Sscanf(line, "%d %f ", &startPID, &start); err != nil {
-				return nil, fmt.Errorf("cannot parse start of exec profile: %s", err)
-			}
+			start, startPID = t, pid
 		}
+		end, endPID = t, pid
 		// handleExecMatch looks for execve{,at}() calls and
 		// uses the pidTracker to keep track of execution of
 		// things. Because of fork() we may see many pids and
@@ -278,12 +571,20 @@ func TraceExecveTimings(straceLog string, nSlowest int) (*ExecveTiming, error) {
 		//    pid 20817 execve("/bin/sh")
 		//    pid 2023  execve("/bin/true")
 		match := execveRE.FindStringSubmatch(line)
-		if err := handleExecMatch(trace, match); err != nil {
-			return nil, err
+		if err := handleExecMatch(trace, "execve", match); err != nil {
+			trace.SkippedLines++
+			continue
+		}
+		if match != nil {
+			trace.setArgv(match[1], execArgv(line))
 		}
 		match = execveatRE.FindStringSubmatch(line)
-		if err := handleExecMatch(trace, match); err != nil {
-			return nil, err
+		if err := handleExecMatch(trace, "execveat", match); err != nil {
+			trace.SkippedLines++
+			continue
+		}
+		if match != nil {
+			trace.setArgv(match[1], execArgv(line))
 		}
 		// handleSignalMatch looks for SIG{CHLD,TERM} signals and
 		// maps them via the pidTracker to the execve{,at}() calls
@@ -291,7 +592,8 @@ func TraceExecveTimings(straceLog string, nSlowest int) (*ExecveTiming, error) {
 		// an execve{,at}() call.
 		match = sigChldTermRE.FindStringSubmatch(line)
 		if err := handleSignalMatch(trace, match); err != nil {
-			return nil, err
+			trace.SkippedLines++
+			continue
 		}
 
 		// handleSignalMatch looks for SIGKILL signals for processes and uses
@@ -299,11 +601,50 @@ func TraceExecveTimings(straceLog string, nSlowest int) (*ExecveTiming, error) {
 		// execve{,at}() call.
 		match = sigkillRE.FindStringSubmatch(line)
 		if err := handleSigkillMatch(trace, match); err != nil {
-			return nil, err
+			trace.SkippedLines++
+			continue
+		}
+
+		if trace.FailedOpens != nil {
+			match = failedOpenRE.FindStringSubmatch(line)
+			trace.FailedOpens.handleMatch(match)
+		}
+
+		threads.handleLine(line)
+		procs.handleLine(line)
+		if mapped != nil {
+			mapped.handleLine(line)
+		}
+		if futexes != nil {
+			futexes.handleLine(line)
+		}
+		if draws != nil {
+			draws.handleLine(line)
+		}
+		if network != nil {
+			network.handleLine(line, start)
+		}
+		if slow != nil {
+			slow.handleLine(line, start)
 		}
 	}
-	if _, err := fmt.Sscanf(line, "%v %f", &endPID, &end); err != nil {
-		return nil, fmt.Errorf("cannot parse end of exec profile: %s", err)
+	if mapped != nil {
+		trace.MappedFiles = mapped.sorted()
+	}
+	if futexes != nil {
+		trace.Futexes = &futexes.stats
+	}
+	if network != nil {
+		trace.Network = &network.activity
+	}
+	if slow != nil {
+		trace.SlowSyscalls = slow.slowest(opts.MaxEvents)
+	}
+	trace.ThreadsCreated = threads.created
+	trace.parents = procs.parents
+	trace.PeakThreads = threads.peak
+	if start == 0.0 {
+		return nil, fmt.Errorf("cannot parse exec profile: none of its %d lines start with a pid and time", trace.SkippedLines)
 	}
 
 	// handle processes which don't execve{,at} at all
@@ -315,6 +656,13 @@ func TraceExecveTimings(straceLog string, nSlowest int) (*ExecveTiming, error) {
 		}
 	}
 	trace.TotalTime = unixFloatSecondsToTime(end).Sub(unixFloatSecondsToTime(start))
+	if draws != nil && draws.drawAt != 0 {
+		trace.TimeToFirstDraw = unixFloatSecondsToTime(draws.drawAt).Sub(unixFloatSecondsToTime(start))
+	}
+	trace.findSlowest()
+	if trace.FailedOpens != nil {
+		trace.FailedOpens.sortPaths()
+	}
 
 	if r.Err() != nil {
 		return nil, r.Err()
@@ -0,0 +1,370 @@
+/*
+ * Copyright (C) 2019 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package strace_test
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/anonymouse64/etrace/internal/strace"
+
+	"gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) { check.TestingT(t) }
+
+type execTracingTestSuite struct{}
+
+var _ = check.Suite(&execTracingTestSuite{})
+
+const sampleExecLog = `20817 1580155329.000000 execve("/usr/bin/snap", ["snap", "run", "hello"], 0x7ffd2a1c8a50 /* 69 vars */) = 0
+20817 1580155329.100000 execve("/usr/lib/snapd/snap-confine", ["snap-confine", "hello"], 0x561bce4ee880 /* 70 vars */) = 0
+20817 1580155329.150000 openat(AT_FDCWD, "/etc/ld.so.preload", O_RDONLY|O_CLOEXEC) = -1 ENOENT (No such file or directory)
+20817 1580155329.160000 openat(AT_FDCWD, "/lib/tls/libc.so.6", O_RDONLY|O_CLOEXEC) = -1 ENOENT (No such file or directory)
+20817 1580155329.170000 stat("/lib/tls/libc.so.6", 0x7ffd2a1c8a50) = -1 ENOENT (No such file or directory)
+20817 1580155329.180000 openat(AT_FDCWD, "/lib/libc.so.6", O_RDONLY|O_CLOEXEC) = 3
+20817 1580155329.400000 execve("/snap/hello/20/bin/hello", ["hello"], 0x561bce4ee880 /* 70 vars */) = 0
+20817 1580155330.000000 +++ exited with 0 +++
+`
+
+func (s *execTracingTestSuite) TestReadExecveTimings(c *check.C) {
+	trace, err := strace.ReadExecveTimings(strings.NewReader(sampleExecLog), -1, strace.TraceOptions{})
+	c.Assert(err, check.IsNil)
+	c.Assert(trace.ExeRuntimes, check.HasLen, 3)
+	c.Check(trace.TotalTime, check.Equals, time.Second)
+	c.Check(trace.ExeRuntimes[0].Exe, check.Equals, "/usr/bin/snap")
+	c.Check(trace.ExeRuntimes[1].Exe, check.Equals, "/usr/lib/snapd/snap-confine")
+	c.Check(trace.ExeRuntimes[2].Exe, check.Equals, "/snap/hello/20/bin/hello")
+	c.Check(trace.ExeRuntimes[0].Argv, check.DeepEquals, []string{"snap", "run", "hello"})
+	c.Check(trace.ExeRuntimes[1].Argv, check.DeepEquals, []string{"snap-confine", "hello"})
+
+	c.Assert(trace.Slowest, check.NotNil)
+	c.Check(trace.Slowest.Exe, check.Equals, "/snap/hello/20/bin/hello")
+
+	// not collected unless asked for
+	c.Check(trace.FailedOpens, check.IsNil)
+}
+
+func (s *execTracingTestSuite) TestDisplayArgv(c *check.C) {
+	log := `100 1580155329.000000 execve("/bin/sh", ["sh", "-c", "echo \"hi\" > /dev/null"], 0x7ffd2a1c8a50 /* 69 vars */) = 0
+100 1580155329.100000 execve("/usr/bin/hello", ["hello", "--a-very-long-argument-strace-cu"..., ""], 0x561bce4ee880 /* 70 vars */) = 0
+100 1580155329.200000 execve("/usr/bin/many", ["many", "1", ...], 0x561bce4ee880 /* 70 vars */) = 0
+100 1580155330.000000 +++ exited with 0 +++
+`
+	trace, err := strace.ReadExecveTimings(strings.NewReader(log), -1, strace.TraceOptions{})
+	c.Assert(err, check.IsNil)
+	c.Assert(trace.ExeRuntimes, check.HasLen, 3)
+	c.Check(trace.ExeRuntimes[0].Argv, check.DeepEquals, []string{"sh", "-c", `echo "hi" > /dev/null`})
+	c.Check(trace.ExeRuntimes[1].Argv, check.DeepEquals, []string{"hello", "--a-very-long-argument-strace-cu...", ""})
+	c.Check(trace.ExeRuntimes[2].Argv, check.DeepEquals, []string{"many", "1", "..."})
+
+	buf := &bytes.Buffer{}
+	trace.Display(buf, strace.DisplayOptions{Columns: []string{"argv"}, Precision: 3})
+	c.Check(buf.String(), check.Equals, `3 exec calls during snap run:
+	Command line
+	sh -c "echo \"hi\" > /dev/null"
+	hello --a-very-long-argument-strace-cu... ""
+	many 1 ...
+Slowest exec: /usr/bin/many (800ms)
+Total time:  1s
+`)
+}
+
+func (s *execTracingTestSuite) TestReadExecveTimingsFailedOpens(c *check.C) {
+	trace, err := strace.ReadExecveTimings(strings.NewReader(sampleExecLog), -1, strace.TraceOptions{FailedOpens: true})
+	c.Assert(err, check.IsNil)
+	c.Assert(trace.FailedOpens, check.NotNil)
+	c.Check(trace.FailedOpens.Total, check.Equals, 3)
+	c.Assert(trace.FailedOpens.Paths, check.HasLen, 2)
+	c.Check(trace.FailedOpens.Paths[0].Path, check.Equals, "/lib/tls/libc.so.6")
+	c.Check(trace.FailedOpens.Paths[0].Count, check.Equals, 2)
+	c.Check(trace.FailedOpens.Paths[0].Syscalls, check.DeepEquals, map[string]int{"openat": 1, "stat": 1})
+	c.Check(trace.FailedOpens.Paths[1].Path, check.Equals, "/etc/ld.so.preload")
+}
+
+func (s *execTracingTestSuite) TestDisplayFiltered(c *check.C) {
+	trace, err := strace.ReadExecveTimings(strings.NewReader(sampleExecLog), -1, strace.TraceOptions{FailedOpens: true})
+	c.Assert(err, check.IsNil)
+	c.Check(trace.ExeRuntimes[0].Syscall, check.Equals, "execve")
+
+	buf := &bytes.Buffer{}
+	trace.Display(buf, strace.DisplayOptions{
+		Columns:  []string{"exec"},
+		Syscalls: []string{"execve", "stat"},
+		PathGlob: "/snap/hello/*/bin/*",
+	})
+	c.Check(buf.String(), check.Matches, `(?s)1 of 3 exec calls during snap run match the filter:
+	Exec
+	/snap/hello/20/bin/hello
+Slowest exec: .*
+3 file accesses failed with ENOENT, most missed paths matching the filter:
+	Count	Path
+Total time: .*`)
+
+	buf.Reset()
+	trace.Display(buf, strace.DisplayOptions{
+		Columns:  []string{"exec"},
+		Syscalls: []string{"stat"},
+	})
+	c.Check(buf.String(), check.Matches, `(?s)0 of 3 exec calls during snap run match the filter:
+	Exec
+Slowest exec: .*
+	Count	Path
+	2	/lib/tls/libc.so.6
+Total time: .*`)
+}
+
+const sampleThreadsLog = `100 1580155329.000000 execve("/usr/bin/app", ["app"], 0x7ffd2a1c8a50 /* 69 vars */) = 0
+100 1580155329.100000 clone(child_stack=0x7f3b7d7fdfb0, flags=CLONE_VM|CLONE_FS|CLONE_FILES|CLONE_SIGHAND|CLONE_THREAD|CLONE_SYSVSEM|CLONE_SETTLS|CLONE_PARENT_SETTID|CLONE_CHILD_CLEARTID, parent_tid=[101], tls=0x7f3b7d7fe700, child_tidptr=0x7f3b7d7fe9d0) = 101
+100 1580155329.200000 clone3({flags=CLONE_VM|CLONE_FS|CLONE_FILES|CLONE_SIGHAND|CLONE_THREAD|CLONE_SYSVSEM|CLONE_SETTLS|CLONE_PARENT_SETTID|CLONE_CHILD_CLEARTID, child_tid=0x7f3b7d7fe9d0, parent_tid=0x7f3b7d7fe9d0, exit_signal=0, stack=0x7f3b7cffe000, stack_size=0x7fff00, tls=0x7f3b7d7fe6c0} <unfinished ...>
+101 1580155329.250000 +++ exited with 0 +++
+100 1580155329.300000 <... clone3 resumed> => {parent_tid=[102]}, 88) = 102
+100 1580155329.400000 clone(child_stack=NULL, flags=CLONE_CHILD_CLEARTID|CLONE_CHILD_SETTID|SIGCHLD, child_tidptr=0x7f3b7d7fe9d0) = 103
+100 1580155329.500000 clone(child_stack=0x7f3b7d7fdfb0, flags=CLONE_VM|CLONE_FS|CLONE_FILES|CLONE_SIGHAND|CLONE_THREAD|CLONE_SYSVSEM|CLONE_SETTLS|CLONE_PARENT_SETTID|CLONE_CHILD_CLEARTID, parent_tid=[104], tls=0x7f3b7d7fe700, child_tidptr=0x7f3b7d7fe9d0) = 104
+100 1580155330.000000 +++ exited with 0 +++
+`
+
+func (s *execTracingTestSuite) TestReadExecveTimingsThreads(c *check.C) {
+	trace, err := strace.ReadExecveTimings(strings.NewReader(sampleThreadsLog), -1, strace.TraceOptions{})
+	c.Assert(err, check.IsNil)
+	// the fork of 103 isn't a thread
+	c.Check(trace.ThreadsCreated, check.Equals, 3)
+	// 101 exited before 102 was created
+	c.Check(trace.PeakThreads, check.Equals, 2)
+}
+
+func (s *execTracingTestSuite) TestReadExecveTimingsMaxEvents(c *check.C) {
+	trace, err := strace.ReadExecveTimings(strings.NewReader(sampleExecLog), -1, strace.TraceOptions{FailedOpens: true, MaxEvents: 1})
+	c.Assert(err, check.IsNil)
+	c.Assert(trace.ExeRuntimes, check.HasLen, 1)
+	c.Check(trace.ExeRuntimes[0].Exe, check.Equals, "/usr/bin/snap")
+	c.Check(trace.DroppedExeRuntimes, check.Equals, 2)
+	// the total time is still for the whole trace
+	c.Check(trace.TotalTime, check.Equals, time.Second)
+
+	c.Check(trace.FailedOpens.Total, check.Equals, 3)
+	c.Assert(trace.FailedOpens.Paths, check.HasLen, 1)
+	c.Check(trace.FailedOpens.Paths[0].Path, check.Equals, "/etc/ld.so.preload")
+	c.Check(trace.FailedOpens.DroppedAccesses, check.Equals, 2)
+}
+
+const sampleMmapLog = `20817 1580155329.000000 execve("/usr/bin/hello", ["hello"], 0x7ffd2a1c8a50 /* 69 vars */) = 0
+20817 1580155329.100000 mmap(NULL, 2037344, PROT_READ, MAP_PRIVATE|MAP_DENYWRITE, 3</usr/lib/x86_64-linux-gnu/libc-2.31.so>, 0) = 0x7f3b7d5fe000
+20817 1580155329.110000 mmap(NULL, 8192, PROT_READ|PROT_WRITE, MAP_PRIVATE|MAP_ANONYMOUS, -1, 0) = 0x7f3b7d7fc000
+20817 1580155329.120000 mmap(0x7f3b7d620000, 1540096, PROT_READ|PROT_EXEC, MAP_PRIVATE|MAP_FIXED|MAP_DENYWRITE, 3</usr/lib/x86_64-linux-gnu/libc-2.31.so>, 0x22000) = 0x7f3b7d620000
+20817 1580155329.130000 mmap(NULL, 4096, PROT_READ, MAP_SHARED, 4</usr/share/hello/data.bin>, 0) = -1 EACCES (Permission denied)
+20817 1580155330.000000 +++ exited with 0 +++
+`
+
+func (s *execTracingTestSuite) TestReadExecveTimingsMappedFiles(c *check.C) {
+	trace, err := strace.ReadExecveTimings(strings.NewReader(sampleMmapLog), -1, strace.TraceOptions{MappedFiles: true})
+	c.Assert(err, check.IsNil)
+	// anonymous and failed mappings are skipped
+	c.Check(trace.MappedFiles, check.DeepEquals, []string{
+		"/usr/bin/hello",
+		"/usr/lib/x86_64-linux-gnu/libc-2.31.so",
+	})
+}
+
+const sampleFutexLog = `100 1580155329.000000 execve("/usr/bin/hello", ["hello"], 0x7ffd2a1c8a50 /* 69 vars */) = 0 <0.000300>
+100 1580155329.100000 futex(0x7f3b7d7fe9d0, FUTEX_WAKE_PRIVATE, 1) = 0 <0.000010>
+100 1580155329.110000 futex(0x7f3b7d7fe9d0, FUTEX_WAIT_PRIVATE, 2, NULL) = 0 <0.250000>
+101 1580155329.120000 futex(0x7f3b7d7fe9e0, FUTEX_WAIT_BITSET_PRIVATE|FUTEX_CLOCK_REALTIME, 0, NULL, FUTEX_BITSET_MATCH_ANY <unfinished ...>
+100 1580155329.130000 futex(0x7f3b7d7fe9e0, FUTEX_WAKE_PRIVATE, 1 <unfinished ...>
+100 1580155329.130100 <... futex resumed>) = 1 <0.000100>
+101 1580155329.500000 <... futex resumed>) = 0 <0.380000>
+100 1580155330.000000 +++ exited with 0 +++
+`
+
+func (s *execTracingTestSuite) TestReadExecveTimingsFutexes(c *check.C) {
+	trace, err := strace.ReadExecveTimings(strings.NewReader(sampleFutexLog), -1, strace.TraceOptions{Futexes: true})
+	c.Assert(err, check.IsNil)
+	c.Assert(trace.ExeRuntimes, check.HasLen, 1)
+	c.Assert(trace.Futexes, check.NotNil)
+	c.Check(trace.Futexes.Calls, check.Equals, 4)
+	c.Check(trace.Futexes.Waits, check.Equals, 2)
+	c.Check(trace.Futexes.WaitTime, check.Equals, 630*time.Millisecond)
+
+	buf := &bytes.Buffer{}
+	trace.Futexes.Display(buf, trace.TotalTime)
+	c.Check(buf.String(), check.Equals, `Futex calls: 4 (2 waits blocked for 630ms over all threads)
+Waiting on locks took 63% of the total time, startup may be limited by lock contention
+`)
+}
+
+const sampleFirstDrawLog = `100 1580155329.000000 execve("/usr/bin/hello", ["hello"], 0x7ffd2a1c8a50 /* 69 vars */) = 0 <0.000300>
+100 1580155329.100000 connect(3, {sa_family=AF_UNIX, sun_path=@"/tmp/.X11-unix/X0"}, 20) = 0 <0.000050>
+100 1580155329.110000 writev(3, [{iov_base="l\0\v\0\0\0\0\0\0\0\0\0", iov_len=12}], 1) = 12 <0.000020>
+100 1580155329.120000 write(4, "\10\0\2\0\1\0\240\3", 8) = 8 <0.000010>
+100 1580155329.150000 writev(3, [{iov_base="\202\0\2\0\1\0\240\3", iov_len=8}], 1) = 8 <0.000020>
+100 1580155329.200000 writev(3, [{iov_base="\10\0\2\0\1\0\240\3", iov_len=8}], 1) = 8 <0.000020>
+100 1580155329.300000 writev(3, [{iov_base="\202\0\2\0\1\0\240\3=\0\4\0\1\0\240\3", iov_len=16}], 1) = 16 <0.000020>
+100 1580155329.400000 writev(3, [{iov_base="=\0\4\0\1\0\240\3\0\0\0\0\0\0\0\0", iov_len=16}], 1) = 16 <0.000020>
+100 1580155330.000000 +++ exited with 0 +++
+`
+
+func (s *execTracingTestSuite) TestReadExecveTimingsFirstDraw(c *check.C) {
+	trace, err := strace.ReadExecveTimings(strings.NewReader(sampleFirstDrawLog), -1, strace.TraceOptions{FirstDraw: true})
+	c.Assert(err, check.IsNil)
+	// the setup, writes to other fds and extension requests before a window
+	// is mapped aren't draws
+	c.Check(trace.TimeToFirstDraw.Round(time.Millisecond), check.Equals, 300*time.Millisecond)
+
+	trace, err = strace.ReadExecveTimings(strings.NewReader(sampleFirstDrawLog), -1, strace.TraceOptions{})
+	c.Assert(err, check.IsNil)
+	c.Check(trace.TimeToFirstDraw, check.Equals, time.Duration(0))
+}
+
+const sampleNetworkLog = `100 1580155329.000000 execve("/usr/bin/hello", ["hello"], 0x7ffd2a1c8a50 /* 69 vars */) = 0 <0.000300>
+100 1580155329.100000 socket(AF_UNIX, SOCK_STREAM|SOCK_CLOEXEC, 0) = 3 <0.000010>
+100 1580155329.100100 connect(3, {sa_family=AF_UNIX, sun_path="/run/user/1000/bus"}, 110) = 0 <0.000050>
+100 1580155329.200000 socket(AF_INET, SOCK_DGRAM|SOCK_CLOEXEC|SOCK_NONBLOCK, IPPROTO_IP) = 4 <0.000010>
+100 1580155329.200100 connect(4, {sa_family=AF_INET, sin_port=htons(53), sin_addr=inet_addr("127.0.0.53")}, 16) = 0 <0.000020>
+101 1580155329.300000 socket(AF_INET6, SOCK_STREAM|SOCK_CLOEXEC, IPPROTO_TCP) = 5 <0.000010>
+101 1580155329.300100 connect(5, {sa_family=AF_INET6, sin6_port=htons(443), sin6_flowinfo=htonl(0), inet_pton(AF_INET6, "2606:2800:220:1::1", &sin6_addr), sin6_scope_id=0}, 28 <unfinished ...>
+100 1580155329.400000 connect(6, {sa_family=AF_INET, sin_port=htons(443), sin_addr=inet_addr("93.184.216.34")}, 16) = -1 EINPROGRESS (Operation now in progress) <0.000050>
+101 1580155330.300100 <... connect resumed>) = -1 ETIMEDOUT (Connection timed out) <1.000000>
+100 1580155331.000000 +++ exited with 0 +++
+`
+
+func (s *execTracingTestSuite) TestReadExecveTimingsNetwork(c *check.C) {
+	trace, err := strace.ReadExecveTimings(strings.NewReader(sampleNetworkLog), -1, strace.TraceOptions{Network: true})
+	c.Assert(err, check.IsNil)
+	c.Assert(trace.Network, check.NotNil)
+	// local connections over unix sockets aren't network activity
+	conns := trace.Network.Connections
+	c.Assert(conns, check.HasLen, 3)
+	c.Check(conns[0].Address, check.Equals, "127.0.0.53:53")
+	c.Check(conns[0].Protocol, check.Equals, "udp")
+	c.Check(conns[0].Result, check.Equals, "ok")
+	c.Check(conns[1].Pid, check.Equals, 101)
+	c.Check(conns[1].Address, check.Equals, "[2606:2800:220:1::1]:443")
+	c.Check(conns[1].Protocol, check.Equals, "tcp")
+	c.Check(conns[1].Result, check.Equals, "ETIMEDOUT")
+	c.Check(conns[1].Duration, check.Equals, time.Second)
+	c.Check(conns[2].Address, check.Equals, "93.184.216.34:443")
+	c.Check(conns[2].Protocol, check.Equals, "")
+	c.Check(conns[2].Result, check.Equals, "EINPROGRESS")
+
+	buf := &bytes.Buffer{}
+	trace.Network.Display(buf, strace.DisplayOptions{Precision: 3})
+	c.Check(buf.String(), check.Equals, `3 network connections were attempted:
+	Start	Duration	Pid	Address	Result
+	200ms	20µs	100	127.0.0.53:53/udp	ok
+	300ms	1s	101	[2606:2800:220:1::1]:443/tcp	ETIMEDOUT
+	400ms	50µs	100	93.184.216.34:443	EINPROGRESS
+`)
+}
+
+const sampleUnknownLinesLog = `strace: Process 100 attached
+100 1580155329.000000 execve("/usr/bin/hello", ["hello"], 0x7ffd2a1c8a50 /* 69 vars */) = 0
+[pid 101] some other format
+100 1580155329.500000 --- SIGCHLD {si_signo=SIGCHLD, si_code=CLD_EXITED, si_pid=101, si_uid=1000, si_status=0} ---
+100 1580155330
+strace: something went wrong
+`
+
+func (s *execTracingTestSuite) TestReadExecveTimingsSkipsUnknownLines(c *check.C) {
+	trace, err := strace.ReadExecveTimings(strings.NewReader(sampleUnknownLinesLog), -1, strace.TraceOptions{})
+	c.Assert(err, check.IsNil)
+	c.Check(trace.SkippedLines, check.Equals, 3)
+	c.Assert(trace.ExeRuntimes, check.HasLen, 1)
+	c.Check(trace.ExeRuntimes[0].Exe, check.Equals, "/usr/bin/hello")
+	// the last line which could be parsed is the end of the trace
+	c.Check(trace.TotalTime.Round(time.Millisecond), check.Equals, time.Second)
+
+	_, err = strace.ReadExecveTimings(strings.NewReader("strace: nothing\n"), -1, strace.TraceOptions{})
+	c.Check(err, check.ErrorMatches, "cannot parse exec profile: none of its 1 lines start with a pid and time")
+}
+
+const sampleSlowSyscallsLog = `100 1580155329.000000 execve("/usr/bin/hello", ["hello"], 0x7ffd2a1c8a50 /* 69 vars */) = 0 <0.000300>
+100 1580155329.100000 openat(AT_FDCWD, "/etc/ld.so.cache", O_RDONLY|O_CLOEXEC) = 3 <0.000010>
+100 1580155329.200000 read(3, "\177ELF\2\1\1\0"..., 832) = 832 <0.020000>
+101 1580155329.300000 poll([{fd=4, events=POLLIN}], 1, -1 <unfinished ...>
+100 1580155329.400000 fsync(5) = 0 <0.050000>
+101 1580155329.800000 <... poll resumed>) = 1 ([{fd=4, revents=POLLIN}]) <0.500000>
+100 1580155330.000000 +++ exited with 0 +++
+`
+
+func (s *execTracingTestSuite) TestReadExecveTimingsSlowSyscalls(c *check.C) {
+	trace, err := strace.ReadExecveTimings(strings.NewReader(sampleSlowSyscallsLog), -1, strace.TraceOptions{MinSyscallDuration: 10 * time.Millisecond})
+	c.Assert(err, check.IsNil)
+	c.Assert(trace.SlowSyscalls, check.HasLen, 3)
+	for i := range trace.SlowSyscalls {
+		trace.SlowSyscalls[i].Start = trace.SlowSyscalls[i].Start.Round(time.Millisecond)
+		trace.SlowSyscalls[i].Duration = trace.SlowSyscalls[i].Duration.Round(time.Millisecond)
+	}
+	// a resumed syscall started before the line it returned on
+	c.Check(trace.SlowSyscalls, check.DeepEquals, []strace.SlowSyscall{
+		{Pid: 101, Syscall: "poll", Start: 300 * time.Millisecond, Duration: 500 * time.Millisecond},
+		{Pid: 100, Syscall: "fsync", Start: 400 * time.Millisecond, Duration: 50 * time.Millisecond},
+		{Pid: 100, Syscall: "read", Start: 200 * time.Millisecond, Duration: 20 * time.Millisecond},
+	})
+
+	trace, err = strace.ReadExecveTimings(strings.NewReader(sampleSlowSyscallsLog), -1, strace.TraceOptions{MinSyscallDuration: 10 * time.Millisecond, MaxEvents: 1})
+	c.Assert(err, check.IsNil)
+	c.Assert(trace.SlowSyscalls, check.HasLen, 1)
+	c.Check(trace.SlowSyscalls[0].Syscall, check.Equals, "poll")
+}
+
+func (s *execTracingTestSuite) TestRoundDuration(c *check.C) {
+	for _, t := range []struct {
+		d        time.Duration
+		digits   int
+		expected time.Duration
+	}{
+		{1234567891, 0, 1234567891},
+		{1234567891, 3, 1230 * time.Millisecond},
+		{123456789, 3, 123 * time.Millisecond},
+		{62345 * time.Millisecond, 3, 62300 * time.Millisecond},
+		{999600 * time.Microsecond, 3, time.Second},
+		{42, 3, 42},
+	} {
+		c.Check(strace.RoundDuration(t.d, t.digits), check.Equals, t.expected, check.Commentf("%v to %d digits", t.d, t.digits))
+	}
+}
+
+const sampleForkLog = `100 1580155329.000000 execve("/usr/bin/snap", ["snap", "run", "app"], 0x7ffd2a1c8a50 /* 69 vars */) = 0
+100 1580155329.100000 execve("/usr/lib/snapd/snap-confine", ["snap-confine", "app"], 0x561bce4ee880 /* 70 vars */) = 0
+100 1580155329.200000 execve("/snap/app/1/bin/launcher", ["launcher"], 0x561bce4ee880 /* 70 vars */) = 0
+100 1580155329.300000 clone(child_stack=NULL, flags=CLONE_CHILD_CLEARTID|CLONE_CHILD_SETTID|SIGCHLD <unfinished ...>
+101 1580155329.350000 execve("/usr/bin/xdg-user-dirs-update", ["xdg-user-dirs-update"], 0x561bce4ee880 /* 70 vars */) = 0
+100 1580155329.360000 <... clone resumed>, child_tidptr=0x7f3b7d7fe9d0) = 101
+101 1580155329.400000 +++ exited with 0 +++
+100 1580155329.400000 --- SIGCHLD {si_signo=SIGCHLD, si_code=CLD_EXITED, si_pid=101, si_uid=1000, si_status=0, si_utime=0, si_stime=0} ---
+100 1580155329.500000 execve("/snap/app/1/bin/app", ["app"], 0x561bce4ee880 /* 70 vars */) = 0
+100 1580155330.000000 +++ exited with 0 +++
+`
+
+func (s *execTracingTestSuite) TestDisplayTree(c *check.C) {
+	trace, err := strace.ReadExecveTimings(strings.NewReader(sampleForkLog), -1, strace.TraceOptions{})
+	c.Assert(err, check.IsNil)
+
+	buf := &bytes.Buffer{}
+	trace.Display(buf, strace.DisplayOptions{Tree: true, Precision: 3})
+	c.Check(buf.String(), check.Matches, `(?s)5 exec calls during snap run:
+Exec	Elapsed	With children
+/usr/bin/snap	100ms	1s
+└─ /usr/lib/snapd/snap-confine	100ms	900ms
+   └─ /snap/app/1/bin/launcher	300ms	800ms
+      ├─ /usr/bin/xdg-user-dirs-update	50ms	50ms
+      └─ /snap/app/1/bin/app	500ms	500ms
+Slowest exec: .*`)
+}
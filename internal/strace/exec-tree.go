@@ -0,0 +1,163 @@
+/*
+ * Copyright (C) 2019 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package strace
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// lines look like:
+// PID   TIME              SYSCALL
+// 20817 1580155329.401357 clone(child_stack=NULL, flags=CLONE_CHILD_CLEARTID|CLONE_CHILD_SETTID|SIGCHLD, child_tidptr=0x7f3b7d7fe9d0) = 20818
+// 20817 1580155329.401357 vfork() = 20818
+var processCreateRE = regexp.MustCompile(`^([0-9]+)\ +[0-9.]+ (?:clone3?|v?fork)\((.*)\) = ([0-9]+)`)
+
+// like threads, the new process may only be known once the call resumes:
+// 20817 1580155329.401357 clone(child_stack=NULL, flags=CLONE_CHILD_CLEARTID|CLONE_CHILD_SETTID|SIGCHLD <unfinished ...>
+// 20817 1580155329.401400 <... clone resumed>, child_tidptr=0x7f3b7d7fe9d0) = 20818
+var processCreateUnfinishedRE = regexp.MustCompile(`^([0-9]+)\ +[0-9.]+ (?:clone3?|v?fork)\((.*)<unfinished \.\.\.>`)
+var processCreateResumedRE = regexp.MustCompile(`^([0-9]+)\ +[0-9.]+ <\.\.\. (?:clone3?|v?fork) resumed>.* = ([0-9]+)`)
+
+// processTree records which process created every other process
+type processTree struct {
+	parents map[string]string
+	// pids with a process creating call which hasn't returned yet
+	unfinished map[string]bool
+}
+
+func newProcessTree() *processTree {
+	return &processTree{
+		parents:    make(map[string]string),
+		unfinished: make(map[string]bool),
+	}
+}
+
+func (p *processTree) handleLine(line string) {
+	if match := processCreateRE.FindStringSubmatch(line); match != nil {
+		if !strings.Contains(match[2], "CLONE_THREAD") {
+			p.parents[match[3]] = match[1]
+		}
+		return
+	}
+	if match := processCreateUnfinishedRE.FindStringSubmatch(line); match != nil {
+		if !strings.Contains(match[2], "CLONE_THREAD") {
+			p.unfinished[match[1]] = true
+		}
+		return
+	}
+	if match := processCreateResumedRE.FindStringSubmatch(line); match != nil {
+		if p.unfinished[match[1]] {
+			delete(p.unfinished, match[1])
+			p.parents[match[2]] = match[1]
+		}
+	}
+}
+
+// execNode is an exec along with the execs it led to, either by exec'ing
+// again or in the processes it created
+type execNode struct {
+	rt       ExeRuntime
+	children []*execNode
+	// end is when this exec and every exec under it had finished
+	end time.Time
+}
+
+// execTree arranges execs, sorted by when they started, into trees where the
+// parent of an exec is the previous exec of the same process or otherwise the
+// exec running in the closest ancestor process when it started
+func execTree(runtimes []ExeRuntime, parents map[string]string) []*execNode {
+	nodes := make([]*execNode, len(runtimes))
+	// the nodes of every pid, latest last
+	byPid := make(map[string][]*execNode)
+	var roots []*execNode
+	for i, rt := range runtimes {
+		node := &execNode{rt: rt, end: rt.Start.Add(rt.TotalSec)}
+		nodes[i] = node
+
+		var parent *execNode
+		for pid := rt.pid; pid != "" && parent == nil; pid = parents[pid] {
+			if execs := byPid[pid]; len(execs) != 0 {
+				parent = execs[len(execs)-1]
+			}
+		}
+		if parent != nil {
+			parent.children = append(parent.children, node)
+		} else {
+			roots = append(roots, node)
+		}
+		byPid[rt.pid] = append(byPid[rt.pid], node)
+	}
+
+	// children always start after their parents, so going backwards sees
+	// every child before its parent
+	for i := len(nodes) - 1; i >= 0; i-- {
+		for _, child := range nodes[i].children {
+			if child.end.After(nodes[i].end) {
+				nodes[i].end = child.end
+			}
+		}
+	}
+	return roots
+}
+
+// displayTree shows the execs indented under the exec that led to them, with
+// how long each one ran and how long until everything under it finished
+func (stt *ExecveTiming) displayTree(w io.Writer, precision int) {
+	fmt.Fprintln(w, "Exec\tElapsed\tWith children")
+	var show func(node *execNode, indent, branch string)
+	show = func(node *execNode, indent, branch string) {
+		total := node.end.Sub(node.rt.Start)
+		fmt.Fprintf(w, "%s%s%s\t%v\t%v\n", indent, branch, node.rt.Exe, RoundDuration(node.rt.TotalSec, precision), RoundDuration(total, precision))
+		// children line up under their parent's exe rather than its branch
+		indent += strings.Repeat(" ", len([]rune(branch)))
+		for i, child := range node.children {
+			if i == len(node.children)-1 {
+				show(child, indent, "└─ ")
+			} else {
+				show(child, indent, "├─ ")
+			}
+		}
+	}
+	for _, root := range execTree(stt.ExeRuntimes, stt.parents) {
+		show(root, "", "")
+	}
+}
+
+// Pids returns every process which was created or created others during the
+// trace, in no particular order
+func (stt *ExecveTiming) Pids() []int {
+	seen := make(map[string]bool)
+	var pids []int
+	for child, parent := range stt.parents {
+		for _, pid := range []string{child, parent} {
+			if seen[pid] {
+				continue
+			}
+			seen[pid] = true
+			if n, err := strconv.Atoi(pid); err == nil {
+				pids = append(pids, n)
+			}
+		}
+	}
+	return pids
+}
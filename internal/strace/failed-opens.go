@@ -0,0 +1,122 @@
+/*
+ * Copyright (C) 2019 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package strace
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+)
+
+// lines look like:
+// PID   TIME              SYSCALL
+// 21097 1580155329.401357 openat(AT_FDCWD, "/usr/lib/x86_64-linux-gnu/tls/libc.so.6", O_RDONLY|O_CLOEXEC) = -1 ENOENT (No such file or directory)
+// 21097 1580155329.402466 stat("/home/ijohnson/.config/gtk-3.0/settings.ini", 0x7ffd2a1c8a50) = -1 ENOENT (No such file or directory)
+var failedOpenRE = regexp.MustCompile(`^[0-9]+\ +[0-9.]+ ([a-z0-9_]+)\([^"]*"([^"]*)".*\) = -1 ENOENT`)
+
+// how many of the most missed paths are shown by Display
+const displayedMissingPaths = 10
+
+// MissingPath is a path which was accessed but didn't exist
+type MissingPath struct {
+	Path     string
+	Syscalls map[string]int
+	Count    int
+}
+
+// FailedOpens are the file accesses which failed because the file didn't exist,
+// which is usually from searching through a list of paths for a file
+type FailedOpens struct {
+	Total int
+	// Paths is sorted by the most frequently missed paths first
+	Paths []MissingPath
+	// DroppedAccesses is how many accesses were to paths which weren't kept
+	// because of TraceOptions.MaxEvents, they are still in Total
+	DroppedAccesses int
+
+	paths    map[string]*MissingPath
+	maxPaths int
+}
+
+func newFailedOpens(maxPaths int) *FailedOpens {
+	return &FailedOpens{
+		paths:    make(map[string]*MissingPath),
+		maxPaths: maxPaths,
+	}
+}
+
+func (f *FailedOpens) handleMatch(match []string) {
+	if len(match) == 0 {
+		return
+	}
+	syscall, path := match[1], match[2]
+
+	f.Total++
+	missing, ok := f.paths[path]
+	if !ok && f.maxPaths > 0 && len(f.paths) >= f.maxPaths {
+		f.DroppedAccesses++
+		return
+	}
+	if !ok {
+		missing = &MissingPath{Path: path, Syscalls: make(map[string]int)}
+		f.paths[path] = missing
+	}
+	missing.Syscalls[syscall]++
+	missing.Count++
+}
+
+// sortPaths fills in Paths from all the paths seen
+func (f *FailedOpens) sortPaths() {
+	f.Paths = make([]MissingPath, 0, len(f.paths))
+	for _, missing := range f.paths {
+		f.Paths = append(f.Paths, *missing)
+	}
+	sort.Slice(f.Paths, func(i, j int) bool {
+		if f.Paths[i].Count == f.Paths[j].Count {
+			return f.Paths[i].Path < f.Paths[j].Path
+		}
+		return f.Paths[i].Count > f.Paths[j].Count
+	})
+}
+
+// Display shows the number of failed accesses and the most missed paths which
+// match the filter in opts
+func (f *FailedOpens) Display(w io.Writer, opts DisplayOptions) {
+	if opts.filtered() {
+		fmt.Fprintf(w, "%d file accesses failed with ENOENT, most missed paths matching the filter:\n", f.Total)
+	} else {
+		fmt.Fprintf(w, "%d file accesses failed with ENOENT, most missed paths:\n", f.Total)
+	}
+	fmt.Fprintf(w, "\tCount\tPath\n")
+	shown := 0
+	for _, missing := range f.Paths {
+		if shown == displayedMissingPaths {
+			break
+		}
+		syscalls := make([]string, 0, len(missing.Syscalls))
+		for syscall := range missing.Syscalls {
+			syscalls = append(syscalls, syscall)
+		}
+		if !opts.matches(syscalls, missing.Path) {
+			continue
+		}
+		fmt.Fprintf(w, "\t%d\t%s\n", missing.Count, missing.Path)
+		shown++
+	}
+}
@@ -112,12 +112,15 @@ type ExecvePaths struct {
 	AllFiles  []string
 	Processes []ProcessRuntime
 	TotalTime time.Duration
+	// ReadBytes is sorted by the files with the most bytes read first
+	ReadBytes []FileReads
 
 	*pidTracker
 
 	persistentPidTracker *pidTracker
 	allFilesSet          map[string]bool
 	pathProcesses        []PathAccess
+	readBytes            *readBytes
 }
 
 type execvePathsTracer interface {
@@ -133,6 +136,7 @@ func newExecveFiles() *ExecvePaths {
 	e := &ExecvePaths{
 		allFilesSet: make(map[string]bool),
 		pidTracker:  newpidTracker(),
+		readBytes:   newReadBytes(),
 	}
 	return e
 }
@@ -312,6 +316,7 @@ func TraceExecveWithFiles(straceLogPattern, snapName, snapRevision string) (*Exe
 				return nil, fmt.Errorf("cannot parse start of exec profile: %s", err)
 			}
 		}
+		trace.readBytes.handleLine(line)
 		// handleExecMatch looks for execve{,at}() calls and
 		// uses the pidTracker to keep track of execution of
 		// things. Because of fork() we may see many pids and
@@ -325,11 +330,11 @@ func TraceExecveWithFiles(straceLogPattern, snapName, snapRevision string) (*Exe
 		//    pid 20817 execve("/bin/sh")
 		//    pid 2023  execve("/bin/true")
 		match := execveRE.FindStringSubmatch(line)
-		if err := handleExecMatch(trace, match); err != nil {
+		if err := handleExecMatch(trace, "execve", match); err != nil {
 			return nil, err
 		}
 		match = execveatRE.FindStringSubmatch(line)
-		if err := handleExecMatch(trace, match); err != nil {
+		if err := handleExecMatch(trace, "execveat", match); err != nil {
 			return nil, err
 		}
 		// handleSignalMatch looks for SIG{CHLD,TERM} signals and
@@ -434,5 +439,8 @@ func TraceExecveWithFiles(straceLogPattern, snapName, snapRevision string) (*Exe
 	// free up path file set memory
 	trace.allFilesSet = nil
 
+	trace.ReadBytes = trace.readBytes.sorted()
+	trace.readBytes = nil
+
 	return trace, nil
 }
@@ -0,0 +1,161 @@
+/*
+ * Copyright (C) 2019 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package strace
+
+import (
+	"regexp"
+	"strconv"
+)
+
+// lines look like:
+// PID   TIME              SYSCALL
+// 20817 1580155329.401357 connect(3, {sa_family=AF_UNIX, sun_path=@"/tmp/.X11-unix/X0"}, 20) = 0
+// 20817 1580155329.401357 connect(3, {sa_family=AF_INET, sin_port=htons(6000), sin_addr=inet_addr("127.0.0.1")}, 16) = 0
+var xConnectRE = regexp.MustCompile(`^([0-9]+)\ +[0-9.]+ connect\(([0-9]+)(?:<[^>]*>)?, \{sa_family=AF_(?:UNIX, sun_path=@?"[^"]*\.X11-unix/X[0-9]+"|INET6?, sin6?_port=htons\(60[0-9][0-9]\)).* = 0`)
+
+// lines look like:
+// 20817 1580155329.401357 writev(3, [{iov_base="\10\0\2\0\1\0\240\3", iov_len=8}], 1) = 8
+// 20817 1580155329.401357 write(3, "F\0\3\0\2\0\240\3\1\0\240\3", 12) = 12
+var xWriteRE = regexp.MustCompile(`^([0-9]+)\ +([0-9.]+) (?:write|writev|sendmsg)\(([0-9]+)(?:<[^>]*>)?, [^"]*"((?:[^"\\]|\\.)*)".* = [1-9]`)
+
+// X request opcodes, the core ones which draw go from ClearArea to
+// ImageText16 except for GetImage
+const (
+	xClearArea   = 61
+	xImageText16 = 77
+	xGetImage    = 73
+	xMapWindow   = 8
+	xMapSubwins  = 9
+	// requests to extensions, such as RENDER and Present, have dynamically
+	// assigned opcodes from here on
+	xFirstExtension = 128
+)
+
+// xConnection is a connection to the X server made by a traced process
+type xConnection struct {
+	setUp bool
+	// mapped is whether the client has asked for a window to be mapped
+	mapped bool
+}
+
+// firstDrawTracker looks for the first request to draw sent to the X server.
+// Only the start of what is written is in the trace, so this is an estimate:
+// a write counts as a draw if one of the requests at its start is a core
+// drawing request, or a request to an extension after a window was mapped,
+// which is how RENDER, Present and GLX clients draw.
+type firstDrawTracker struct {
+	conns  map[string]*xConnection
+	drawAt float64
+}
+
+func newFirstDrawTracker() *firstDrawTracker {
+	return &firstDrawTracker{conns: make(map[string]*xConnection)}
+}
+
+func (t *firstDrawTracker) handleLine(line string) {
+	if t.drawAt != 0 {
+		return
+	}
+	if match := xConnectRE.FindStringSubmatch(line); match != nil {
+		t.conns[match[1]+":"+match[2]] = &xConnection{}
+		return
+	}
+	match := xWriteRE.FindStringSubmatch(line)
+	if match == nil {
+		return
+	}
+	conn := t.conns[match[1]+":"+match[3]]
+	if conn == nil {
+		return
+	}
+	if !conn.setUp {
+		// the first thing sent is the connection setup, not a request
+		conn.setUp = true
+		return
+	}
+
+	data := unescapeStraceString(match[4])
+	for len(data) >= 4 {
+		opcode := data[0]
+		switch {
+		case opcode == xMapWindow || opcode == xMapSubwins:
+			conn.mapped = true
+		case opcode >= xClearArea && opcode <= xImageText16 && opcode != xGetImage,
+			opcode >= xFirstExtension && conn.mapped:
+			drawAt, err := strconv.ParseFloat(match[2], 64)
+			if err == nil {
+				t.drawAt = drawAt
+			}
+			return
+		}
+		// the length is in 4 byte units, assuming a little endian client
+		length := (int(data[2]) | int(data[3])<<8) * 4
+		if length == 0 {
+			return
+		}
+		if length > len(data) {
+			break
+		}
+		data = data[length:]
+	}
+}
+
+// unescapeStraceString returns the bytes of a string as strace prints it,
+// with C style escapes
+func unescapeStraceString(s string) []byte {
+	var b []byte
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' || i+1 == len(s) {
+			b = append(b, s[i])
+			continue
+		}
+		i++
+		switch c := s[i]; {
+		case c >= '0' && c <= '7':
+			// up to 3 octal digits
+			n := 0
+			j := i
+			for ; j < len(s) && j < i+3 && s[j] >= '0' && s[j] <= '7'; j++ {
+				n = n*8 + int(s[j]-'0')
+			}
+			b = append(b, byte(n))
+			i = j - 1
+		case c == 'x' && i+2 < len(s):
+			n, err := strconv.ParseUint(s[i+1:i+3], 16, 8)
+			if err != nil {
+				b = append(b, c)
+				continue
+			}
+			b = append(b, byte(n))
+			i += 2
+		case c == 'n':
+			b = append(b, '\n')
+		case c == 't':
+			b = append(b, '\t')
+		case c == 'r':
+			b = append(b, '\r')
+		case c == 'v':
+			b = append(b, '\v')
+		case c == 'f':
+			b = append(b, '\f')
+		default:
+			b = append(b, c)
+		}
+	}
+	return b
+}
@@ -0,0 +1,105 @@
+/*
+ * Copyright (C) 2019 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package strace
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// lines look like, with -T adding the time spent in the syscall at the end:
+// PID   TIME              SYSCALL
+// 21097 1580155329.401357 futex(0x7f3b7d7fe9d0, FUTEX_WAIT_PRIVATE, 2, NULL) = 0 <0.001234>
+var futexRE = regexp.MustCompile(`^([0-9]+)\ +[0-9.]+ futex\([^,]+, (FUTEX_[A-Z_]+)`)
+
+// with other threads running, a wait is usually split over two lines:
+// 21097 1580155329.401357 futex(0x7f3b7d7fe9d0, FUTEX_WAIT_PRIVATE, 2, NULL <unfinished ...>
+// 21097 1580155329.902591 <... futex resumed>) = 0 <0.501234>
+var futexResumedRE = regexp.MustCompile(`^([0-9]+)\ +[0-9.]+ <\.\.\. futex resumed>`)
+
+var syscallTimeRE = regexp.MustCompile(`<([0-9.]+)>$`)
+
+// FutexStats is how much futex() was used, which is how threads wait on locks
+type FutexStats struct {
+	Calls int
+	// Waits is how many of the calls blocked waiting for a lock
+	Waits int
+	// WaitTime is the time spent in those waits, summed over all threads
+	WaitTime time.Duration
+}
+
+// Display shows the futex usage, pointing out when a lot of time was spent
+// waiting compared to the total time
+func (f *FutexStats) Display(w io.Writer, total time.Duration) {
+	fmt.Fprintf(w, "Futex calls: %d (%d waits blocked for %v over all threads)\n", f.Calls, f.Waits, f.WaitTime)
+	if total > 0 && f.WaitTime > total/10 {
+		fmt.Fprintf(w, "Waiting on locks took %.0f%% of the total time, startup may be limited by lock contention\n", 100*f.WaitTime.Seconds()/total.Seconds())
+	}
+}
+
+// futexTracker collects FutexStats from the trace
+type futexTracker struct {
+	stats FutexStats
+	// pids with a waiting futex() which hasn't returned yet
+	unfinished map[string]bool
+}
+
+func newFutexTracker() *futexTracker {
+	return &futexTracker{
+		unfinished: make(map[string]bool),
+	}
+}
+
+func isFutexWait(op string) bool {
+	return strings.HasPrefix(op, "FUTEX_WAIT") || strings.HasPrefix(op, "FUTEX_LOCK_PI")
+}
+
+func (f *futexTracker) addWait(line string) {
+	f.stats.Waits++
+	if match := syscallTimeRE.FindStringSubmatch(line); match != nil {
+		secs, err := strconv.ParseFloat(match[1], 64)
+		if err == nil {
+			f.stats.WaitTime += time.Duration(secs * float64(time.Second))
+		}
+	}
+}
+
+func (f *futexTracker) handleLine(line string) {
+	if match := futexRE.FindStringSubmatch(line); match != nil {
+		f.stats.Calls++
+		if !isFutexWait(match[2]) {
+			return
+		}
+		if strings.HasSuffix(line, "<unfinished ...>") {
+			f.unfinished[match[1]] = true
+			return
+		}
+		f.addWait(line)
+		return
+	}
+	if match := futexResumedRE.FindStringSubmatch(line); match != nil {
+		if f.unfinished[match[1]] {
+			delete(f.unfinished, match[1])
+			f.addWait(line)
+		}
+	}
+}
@@ -0,0 +1,51 @@
+/*
+ * Copyright (C) 2019 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package strace
+
+import (
+	"path/filepath"
+	"regexp"
+	"sort"
+)
+
+// with -y the fd being mapped is followed by its path, lines look like:
+// PID   TIME              SYSCALL
+// 21097 1580155329.401357 mmap(NULL, 2037344, PROT_READ, MAP_PRIVATE|MAP_DENYWRITE, 3</usr/lib/x86_64-linux-gnu/libc-2.31.so>, 0) = 0x7f3b7d5fe000
+var mmapFileRE = regexp.MustCompile(`^[0-9]+\ +[0-9.]+ mmap2?\(.*, [0-9]+<([^>]+)>, [^,]+\) = 0x`)
+
+// mappedFiles collects the files which were executed or mapped into memory
+type mappedFiles map[string]bool
+
+func (m mappedFiles) handleLine(line string) {
+	if match := mmapFileRE.FindStringSubmatch(line); match != nil {
+		m[match[1]] = true
+	}
+	// executables are mapped by the kernel rather than with mmap()
+	if match := execveRE.FindStringSubmatch(line); match != nil && filepath.IsAbs(match[3]) {
+		m[match[3]] = true
+	}
+}
+
+func (m mappedFiles) sorted() []string {
+	files := make([]string, 0, len(m))
+	for f := range m {
+		files = append(files, f)
+	}
+	sort.Strings(files)
+	return files
+}
@@ -0,0 +1,161 @@
+/*
+ * Copyright (C) 2019 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package strace
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// lines look like:
+// PID   TIME              SYSCALL
+// 21097 1580155329.401357 socket(AF_INET, SOCK_STREAM|SOCK_CLOEXEC|SOCK_NONBLOCK, IPPROTO_IP) = 5 <0.000020>
+var socketRE = regexp.MustCompile(`^([0-9]+)\ +[0-9.]+ socket\(AF_INET6?, (SOCK_[A-Z]+)[^)]*\) = ([0-9]+)`)
+
+// 21097 1580155329.401357 connect(5, {sa_family=AF_INET, sin_port=htons(443), sin_addr=inet_addr("93.184.216.34")}, 16) = -1 EINPROGRESS (Operation now in progress) <0.000050>
+// 21097 1580155329.401357 connect(5, {sa_family=AF_INET6, sin6_port=htons(443), sin6_flowinfo=htonl(0), inet_pton(AF_INET6, "2606:2800:220:1::1", &sin6_addr), sin6_scope_id=0}, 28) = 0 <0.000050>
+var connectRE = regexp.MustCompile(`^([0-9]+)\ +([0-9.]+) connect\(([0-9]+)(?:<[^>]*>)?, \{sa_family=AF_INET6?, sin6?_port=htons\(([0-9]+)\), .*"([0-9a-fA-F:.]+)"`)
+
+// a connect() which blocks is split over two lines when other threads run:
+// 21097 1580155329.401357 connect(5, {...}, 16 <unfinished ...>
+// 21097 1580155330.402591 <... connect resumed>) = -1 ETIMEDOUT (Connection timed out) <1.001234>
+var connectResumedRE = regexp.MustCompile(`^([0-9]+)\ +[0-9.]+ <\.\.\. connect resumed>`)
+
+var syscallResultRE = regexp.MustCompile(`\) = (-?[0-9]+)(?: ([A-Z]+))?`)
+
+// NetworkConnection is an attempt by the command to connect to another host
+type NetworkConnection struct {
+	Pid     int
+	Address string
+	// Protocol is tcp or udp, or empty if the socket wasn't seen being
+	// created
+	Protocol string
+	// Start is when the connection was attempted since the start of the
+	// trace
+	Start time.Duration
+	// Duration is how long connect() took, which for non-blocking sockets
+	// is only until it returned that the connection is in progress
+	Duration time.Duration
+	// Result is ok, or the error connect() returned, such as ECONNREFUSED,
+	// ETIMEDOUT or EINPROGRESS for non-blocking sockets
+	Result string
+}
+
+// NetworkActivity is the connections to other hosts the command attempted
+type NetworkActivity struct {
+	Connections []NetworkConnection
+}
+
+// networkTracker collects the NetworkActivity from the trace
+type networkTracker struct {
+	activity NetworkActivity
+	// protocols of the network sockets by pid and fd
+	protocols map[string]string
+	// connections of each pid which haven't returned yet
+	unfinished map[string]int
+}
+
+func newNetworkTracker() *networkTracker {
+	return &networkTracker{
+		protocols:  make(map[string]string),
+		unfinished: make(map[string]int),
+	}
+}
+
+// finish fills in the result and duration of the connection from the line
+// connect() returned on
+func (n *networkTracker) finish(conn *NetworkConnection, line string) {
+	if match := syscallResultRE.FindStringSubmatch(line); match != nil {
+		conn.Result = "ok"
+		if match[1] != "0" && match[2] != "" {
+			conn.Result = match[2]
+		}
+	}
+	if match := syscallTimeRE.FindStringSubmatch(line); match != nil {
+		secs, err := strconv.ParseFloat(match[1], 64)
+		if err == nil {
+			conn.Duration = time.Duration(secs * float64(time.Second))
+		}
+	}
+}
+
+func (n *networkTracker) handleLine(line string, start float64) {
+	if match := socketRE.FindStringSubmatch(line); match != nil {
+		protocol := "tcp"
+		if match[2] == "SOCK_DGRAM" {
+			protocol = "udp"
+		}
+		n.protocols[match[1]+":"+match[3]] = protocol
+		return
+	}
+	if match := connectRE.FindStringSubmatch(line); match != nil {
+		pid, err := strconv.Atoi(match[1])
+		if err != nil {
+			return
+		}
+		at, err := strconv.ParseFloat(match[2], 64)
+		if err != nil {
+			return
+		}
+		address := match[5] + ":" + match[4]
+		if strings.Contains(match[5], ":") {
+			address = "[" + match[5] + "]:" + match[4]
+		}
+		conn := NetworkConnection{
+			Pid:      pid,
+			Address:  address,
+			Protocol: n.protocols[match[1]+":"+match[3]],
+			Start:    unixFloatSecondsToTime(at).Sub(unixFloatSecondsToTime(start)),
+		}
+		if strings.HasSuffix(line, "<unfinished ...>") {
+			n.unfinished[match[1]] = len(n.activity.Connections)
+		} else {
+			n.finish(&conn, line)
+		}
+		n.activity.Connections = append(n.activity.Connections, conn)
+		return
+	}
+	if match := connectResumedRE.FindStringSubmatch(line); match != nil {
+		if i, ok := n.unfinished[match[1]]; ok {
+			delete(n.unfinished, match[1])
+			n.finish(&n.activity.Connections[i], line)
+		}
+	}
+}
+
+// Display shows the connections the command attempted, when they started and
+// how long they blocked it for
+func (n *NetworkActivity) Display(w io.Writer, opts DisplayOptions) {
+	if len(n.Connections) == 0 {
+		fmt.Fprintln(w, "No network connections were attempted")
+		return
+	}
+	fmt.Fprintf(w, "%d network connections were attempted:\n", len(n.Connections))
+	fmt.Fprintln(w, "\tStart\tDuration\tPid\tAddress\tResult")
+	for _, conn := range n.Connections {
+		address := conn.Address
+		if conn.Protocol != "" {
+			address += "/" + conn.Protocol
+		}
+		fmt.Fprintf(w, "\t%v\t%v\t%d\t%s\t%s\n", RoundDuration(conn.Start, opts.Precision), RoundDuration(conn.Duration, opts.Precision), conn.Pid, address, conn.Result)
+	}
+}
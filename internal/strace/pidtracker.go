@@ -17,31 +17,11 @@
 
 package strace
 
-// type childPidStart struct {
-// 	start float64
-// 	pid   string
-// }
-
-// type pidChildTracker struct {
-// 	pidToChildrenPIDs map[string][]childPidStart
-// }
-
-// func newPidChildTracker() *pidChildTracker {
-// 	return &pidChildTracker{
-// 		pidToChildrenPIDs: make(map[string][]childPidStart),
-// 	}
-// }
-
-// func (pct *pidChildTracker) Add(pid string, child string, start float64) {
-// 	if _, ok := pct.pidToChildrenPIDs[pid]; !ok {
-// 		pct.pidToChildrenPIDs[pid] = []childPidStart{}
-// 	}
-// 	pct.pidToChildrenPIDs[pid] = append(pct.pidToChildrenPIDs[pid], childPidStart{start: start, pid: child})
-// }
-
 type exeStart struct {
-	start float64
-	exe   string
+	start   float64
+	exe     string
+	syscall string
+	argv    []string
 }
 
 type pidTracker struct {
@@ -61,8 +41,26 @@ func (pt *pidTracker) getPid(pid string) (startTime float64, exe string) {
 	return 0, ""
 }
 
-func (pt *pidTracker) addPid(pid string, startTime float64, exe string) {
-	pt.pidToExeStart[pid] = exeStart{start: startTime, exe: exe}
+// getSyscall returns the syscall which executed the current exe of pid
+func (pt *pidTracker) getSyscall(pid string) string {
+	return pt.pidToExeStart[pid].syscall
+}
+
+// setArgv sets the arguments the current exe of pid was executed with
+func (pt *pidTracker) setArgv(pid string, argv []string) {
+	if exeStart, ok := pt.pidToExeStart[pid]; ok {
+		exeStart.argv = argv
+		pt.pidToExeStart[pid] = exeStart
+	}
+}
+
+// getArgv returns the arguments the current exe of pid was executed with
+func (pt *pidTracker) getArgv(pid string) []string {
+	return pt.pidToExeStart[pid].argv
+}
+
+func (pt *pidTracker) addPid(pid string, startTime float64, exe string, syscall string) {
+	pt.pidToExeStart[pid] = exeStart{start: startTime, exe: exe, syscall: syscall}
 }
 
 func (pt *pidTracker) deletePid(pid string) {
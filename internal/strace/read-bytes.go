@@ -0,0 +1,125 @@
+/*
+ * Copyright (C) 2019 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package strace
+
+import (
+	"fmt"
+	"io"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+)
+
+// the returned fd is followed by its path with -y, lines look like:
+// PID    TIME              SYSCALL
+// 121188 1574886788.027891 openat(AT_FDCWD, "/usr/share/fonts/truetype/dejavu/DejaVuSans.ttf", O_RDONLY|O_CLOEXEC) = 4</usr/share/fonts/truetype/dejavu/DejaVuSans.ttf>
+// 121188 1574886788.027966 open("/etc/ld.so.cache", O_RDONLY|O_CLOEXEC) = 3
+var openFdRE = regexp.MustCompile(`^([0-9]+)\ +[0-9.]+ open(?:at)?\((?:[^,"]+, )?"([^"]*)".*\) = ([0-9]+)(?:<([^>]*)>)?`)
+
+// lines look like:
+// 121188 1574886788.028001 read(4</usr/share/fonts/truetype/dejavu/DejaVuSans.ttf>, ""..., 4096) = 4096
+// 121188 1574886788.028012 pread64(3, ""..., 784, 64) = 784
+var readFdRE = regexp.MustCompile(`^([0-9]+)\ +[0-9.]+ (?:read|pread64|readv|preadv|preadv2)\(([0-9]+)(?:<([^>]*)>)?, .*\) = ([0-9]+)`)
+
+// how many of the files with the most bytes read are shown by DisplayReadBytes
+const displayedReadFiles = 10
+
+// FileReads is how much was read from a single file
+type FileReads struct {
+	Path  string
+	Bytes int64
+	Reads int
+}
+
+// readBytes sums up the bytes read from every file, mapping fds back to paths
+// with the open call when strace doesn't show the path of the fd itself
+type readBytes struct {
+	// fds maps "pid fd" to the path opened as fd
+	fds   map[string]string
+	files map[string]*FileReads
+}
+
+func newReadBytes() *readBytes {
+	return &readBytes{
+		fds:   make(map[string]string),
+		files: make(map[string]*FileReads),
+	}
+}
+
+func (r *readBytes) handleLine(line string) {
+	if match := openFdRE.FindStringSubmatch(line); match != nil {
+		path := match[2]
+		if match[4] != "" {
+			path = match[4]
+		}
+		r.fds[match[1]+" "+match[3]] = path
+		return
+	}
+
+	match := readFdRE.FindStringSubmatch(line)
+	if match == nil {
+		return
+	}
+	path := match[3]
+	if path == "" {
+		path = r.fds[match[1]+" "+match[2]]
+	}
+	// skip pipes, sockets and fds we didn't see being opened
+	if !filepath.IsAbs(path) {
+		return
+	}
+	n, err := strconv.ParseInt(match[4], 10, 64)
+	if err != nil {
+		return
+	}
+	reads, ok := r.files[path]
+	if !ok {
+		reads = &FileReads{Path: path}
+		r.files[path] = reads
+	}
+	reads.Bytes += n
+	reads.Reads++
+}
+
+// sorted returns the files with the most bytes read first
+func (r *readBytes) sorted() []FileReads {
+	files := make([]FileReads, 0, len(r.files))
+	for _, reads := range r.files {
+		files = append(files, *reads)
+	}
+	sort.Slice(files, func(i, j int) bool {
+		if files[i].Bytes == files[j].Bytes {
+			return files[i].Path < files[j].Path
+		}
+		return files[i].Bytes > files[j].Bytes
+	})
+	return files
+}
+
+// DisplayReadBytes shows the files which the most bytes were read from
+func (e *ExecvePaths) DisplayReadBytes(w io.Writer) {
+	fmt.Fprintf(w, "Files with the most bytes read:\n")
+	fmt.Fprintf(w, "\tBytes\tReads\tPath\n")
+	for i, reads := range e.ReadBytes {
+		if i == displayedReadFiles {
+			break
+		}
+		fmt.Fprintf(w, "\t%d\t%d\t%s\n", reads.Bytes, reads.Reads, reads.Path)
+	}
+}
@@ -0,0 +1,48 @@
+/*
+ * Copyright (C) 2019 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package strace_test
+
+import (
+	"strings"
+
+	"github.com/anonymouse64/etrace/internal/strace"
+
+	"gopkg.in/check.v1"
+)
+
+type readBytesTestSuite struct{}
+
+var _ = check.Suite(&readBytesTestSuite{})
+
+const sampleReadLog = `100 1580155329.000000 openat(AT_FDCWD, "/etc/ld.so.cache", O_RDONLY|O_CLOEXEC) = 3
+100 1580155329.000100 pread64(3, ""..., 784, 64) = 784
+100 1580155329.000200 read(3, ""..., 4096) = 100
+100 1580155329.000300 openat(AT_FDCWD, "/usr/share/fonts/font.ttf", O_RDONLY) = 4</usr/share/fonts/font.ttf>
+100 1580155329.000400 read(4</usr/share/fonts/font.ttf>, ""..., 65536) = 65536
+100 1580155329.000500 read(4</usr/share/fonts/font.ttf>, ""..., 65536) = 0
+100 1580155329.000600 read(5<pipe:[1234]>, ""..., 16) = 8
+101 1580155329.000700 read(3, ""..., 16) = 16
+100 1580155329.000800 read(6, ""..., 16) = -1 EAGAIN (Resource temporarily unavailable)`
+
+func (s *readBytesTestSuite) TestReadBytes(c *check.C) {
+	files := strace.ReadBytesFromLines(strings.Split(sampleReadLog, "\n"))
+	c.Check(files, check.DeepEquals, []strace.FileReads{
+		{Path: "/usr/share/fonts/font.ttf", Bytes: 65536, Reads: 2},
+		{Path: "/etc/ld.so.cache", Bytes: 884, Reads: 2},
+	})
+}
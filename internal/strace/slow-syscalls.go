@@ -0,0 +1,115 @@
+/*
+ * Copyright (C) 2019 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package strace
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// lines look like, with -T adding the time spent in the syscall at the end:
+// PID   TIME              SYSCALL
+// 21097 1580155329.401357 read(3, "\177ELF\2\1\1\0"..., 832) = 832 <0.000020>
+// and when other threads run while it blocks:
+// 21097 1580155329.902591 <... read resumed>"\177ELF\2\1\1\0"..., 832) = 832 <0.501234>
+var syscallNameRE = regexp.MustCompile(`^([0-9]+)\ +([0-9.]+) (?:<\.\.\. )?([a-z0-9_]+)(?:\(| resumed>)`)
+
+// SlowSyscall is a syscall which took at least
+// TraceOptions.MinSyscallDuration
+type SlowSyscall struct {
+	Pid     int
+	Syscall string
+	// Start is when the syscall was made since the start of the trace
+	Start    time.Duration
+	Duration time.Duration
+}
+
+// slowSyscallTracker collects the syscalls slower than min from the trace
+type slowSyscallTracker struct {
+	min  time.Duration
+	slow []SlowSyscall
+}
+
+func newSlowSyscallTracker(min time.Duration) *slowSyscallTracker {
+	return &slowSyscallTracker{min: min}
+}
+
+func (s *slowSyscallTracker) handleLine(line string, start float64) {
+	timeMatch := syscallTimeRE.FindStringSubmatch(line)
+	if timeMatch == nil {
+		return
+	}
+	secs, err := strconv.ParseFloat(timeMatch[1], 64)
+	if err != nil {
+		return
+	}
+	d := time.Duration(secs * float64(time.Second))
+	if d < s.min {
+		return
+	}
+	match := syscallNameRE.FindStringSubmatch(line)
+	if match == nil {
+		return
+	}
+	pid, err := strconv.Atoi(match[1])
+	if err != nil {
+		return
+	}
+	at, err := strconv.ParseFloat(match[2], 64)
+	if err != nil {
+		return
+	}
+	call := SlowSyscall{
+		Pid:      pid,
+		Syscall:  match[3],
+		Start:    unixFloatSecondsToTime(at).Sub(unixFloatSecondsToTime(start)),
+		Duration: d,
+	}
+	// a resumed syscall is shown when it returned rather than when it was
+	// made
+	if line[len(match[0])-1] == '>' {
+		call.Start -= d
+	}
+	s.slow = append(s.slow, call)
+}
+
+// slowest returns the slow syscalls, slowest first, only keeping max of them
+// if it's not 0
+func (s *slowSyscallTracker) slowest(max int) []SlowSyscall {
+	sort.SliceStable(s.slow, func(i, j int) bool {
+		return s.slow[i].Duration > s.slow[j].Duration
+	})
+	if max > 0 && len(s.slow) > max {
+		return s.slow[:max]
+	}
+	return s.slow
+}
+
+// displaySlowSyscalls shows the syscalls which were slower than the minimum
+// duration
+func displaySlowSyscalls(w io.Writer, calls []SlowSyscall, opts DisplayOptions) {
+	fmt.Fprintf(w, "%d slow syscalls:\n", len(calls))
+	fmt.Fprintln(w, "\tStart\tDuration\tPid\tSyscall")
+	for _, call := range calls {
+		fmt.Fprintf(w, "\t%v\t%v\t%d\t%s\n", RoundDuration(call.Start, opts.Precision), RoundDuration(call.Duration, opts.Precision), call.Pid, call.Syscall)
+	}
+}
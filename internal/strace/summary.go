@@ -0,0 +1,145 @@
+/*
+ * Copyright (C) 2019 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package strace
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SyscallStat is the time spent in and number of calls of a syscall
+type SyscallStat struct {
+	Name    string
+	Percent float64
+	Time    time.Duration
+	Calls   int
+	Errors  int
+}
+
+// SyscallSummary is the per syscall summary strace produces with -c
+type SyscallSummary struct {
+	// Syscalls is in the same order as strace shows them, the most time
+	// spent first
+	Syscalls []SyscallStat
+	Total    SyscallStat
+}
+
+// SummaryCommand returns an exec.Cmd which counts the time spent in every
+// syscall, writing strace's summary table to summaryPath when the command
+// exits
+func SummaryCommand(summaryPath string, opts TraceOptions, origCmd ...string) (*exec.Cmd, error) {
+	extraStraceOpts := []string{"-c", "-o", summaryPath}
+	for _, env := range opts.Env {
+		extraStraceOpts = append(extraStraceOpts, "-E", env)
+	}
+	return straceCommand(extraStraceOpts, origCmd...)
+}
+
+// ReadSyscallSummary parses the summary table written by strace -c at path
+func ReadSyscallSummary(path string) (*SyscallSummary, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ParseSyscallSummary(f)
+}
+
+// ParseSyscallSummary parses the summary table written by strace -c, which
+// looks like:
+//
+//	% time     seconds  usecs/call     calls    errors syscall
+//	------ ----------- ----------- --------- --------- ----------------
+//	 28.57    0.000040          40         1           execve
+//	 20.00    0.000028           3         8         1 openat
+//	------ ----------- ----------- --------- --------- ----------------
+//	100.00    0.000140                    44         3 total
+func ParseSyscallSummary(r io.Reader) (*SyscallSummary, error) {
+	summary := &SyscallSummary{
+		Total: SyscallStat{Name: "total"},
+	}
+	sawHeader := false
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, "% time") {
+			sawHeader = true
+			continue
+		}
+		if !sawHeader || line == "" || strings.HasPrefix(line, "---") {
+			continue
+		}
+		fields := strings.Fields(line)
+		// the total is computed from the syscalls instead, as which of its
+		// columns are empty varies between strace versions
+		if fields[len(fields)-1] == "total" {
+			break
+		}
+		// the errors column is empty for syscalls which never failed
+		if len(fields) != 5 && len(fields) != 6 {
+			return nil, fmt.Errorf("cannot parse syscall summary line %q", line)
+		}
+
+		stat := SyscallStat{Name: fields[len(fields)-1]}
+		var err error
+		if stat.Percent, err = strconv.ParseFloat(fields[0], 64); err != nil {
+			return nil, fmt.Errorf("cannot parse syscall summary line %q: %w", line, err)
+		}
+		seconds, err := strconv.ParseFloat(fields[1], 64)
+		if err != nil {
+			return nil, fmt.Errorf("cannot parse syscall summary line %q: %w", line, err)
+		}
+		stat.Time = time.Duration(seconds * float64(time.Second))
+		if stat.Calls, err = strconv.Atoi(fields[3]); err != nil {
+			return nil, fmt.Errorf("cannot parse syscall summary line %q: %w", line, err)
+		}
+		if len(fields) == 6 {
+			if stat.Errors, err = strconv.Atoi(fields[4]); err != nil {
+				return nil, fmt.Errorf("cannot parse syscall summary line %q: %w", line, err)
+			}
+		}
+
+		summary.Syscalls = append(summary.Syscalls, stat)
+		summary.Total.Percent += stat.Percent
+		summary.Total.Time += stat.Time
+		summary.Total.Calls += stat.Calls
+		summary.Total.Errors += stat.Errors
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if !sawHeader {
+		return nil, fmt.Errorf("cannot find syscall summary table")
+	}
+	return summary, nil
+}
+
+// Display shows the summary as a table
+func (s *SyscallSummary) Display(w io.Writer) {
+	fmt.Fprintf(w, "%d syscalls taking %v:\n", s.Total.Calls, s.Total.Time)
+	fmt.Fprintf(w, "\t%% time\tTime\tCalls\tErrors\tSyscall\n")
+	for _, stat := range s.Syscalls {
+		fmt.Fprintf(w, "\t%.2f\t%v\t%d\t%d\t%s\n", stat.Percent, stat.Time, stat.Calls, stat.Errors, stat.Name)
+	}
+}
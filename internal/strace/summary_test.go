@@ -0,0 +1,56 @@
+/*
+ * Copyright (C) 2019 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package strace_test
+
+import (
+	"strings"
+	"time"
+
+	"github.com/anonymouse64/etrace/internal/strace"
+
+	"gopkg.in/check.v1"
+)
+
+type summaryTestSuite struct{}
+
+var _ = check.Suite(&summaryTestSuite{})
+
+const sampleSummary = `% time     seconds  usecs/call     calls    errors syscall
+------ ----------- ----------- --------- --------- ----------------
+ 60.00    0.000300         300         1           execve
+ 40.00    0.000200          25         8         3 openat
+------ ----------- ----------- --------- --------- ----------------
+100.00    0.000500                     9         3 total
+`
+
+func (s *summaryTestSuite) TestParseSyscallSummary(c *check.C) {
+	summary, err := strace.ParseSyscallSummary(strings.NewReader(sampleSummary))
+	c.Assert(err, check.IsNil)
+	c.Check(summary.Syscalls, check.DeepEquals, []strace.SyscallStat{
+		{Name: "execve", Percent: 60, Time: 300 * time.Microsecond, Calls: 1},
+		{Name: "openat", Percent: 40, Time: 200 * time.Microsecond, Calls: 8, Errors: 3},
+	})
+	c.Check(summary.Total.Calls, check.Equals, 9)
+	c.Check(summary.Total.Errors, check.Equals, 3)
+	c.Check(summary.Total.Time, check.Equals, 500*time.Microsecond)
+}
+
+func (s *summaryTestSuite) TestParseSyscallSummaryMissingTable(c *check.C) {
+	_, err := strace.ParseSyscallSummary(strings.NewReader("strace: exec: No such file or directory\n"))
+	c.Check(err, check.ErrorMatches, "cannot find syscall summary table")
+}
@@ -0,0 +1,86 @@
+/*
+ * Copyright (C) 2019 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package strace
+
+import (
+	"regexp"
+)
+
+// lines look like:
+// PID   TIME              SYSCALL
+// 21097 1580155329.401357 clone(child_stack=0x7f3b7d7fdfb0, flags=CLONE_VM|CLONE_FS|CLONE_FILES|CLONE_SIGHAND|CLONE_THREAD|CLONE_SYSVSEM|CLONE_SETTLS|CLONE_PARENT_SETTID|CLONE_CHILD_CLEARTID, parent_tid=[21098], tls=0x7f3b7d7fe700, child_tidptr=0x7f3b7d7fe9d0) = 21098
+// 21097 1580155329.401357 clone3({flags=CLONE_VM|CLONE_FS|CLONE_FILES|CLONE_SIGHAND|CLONE_THREAD|CLONE_SYSVSEM|CLONE_SETTLS|CLONE_PARENT_SETTID|CLONE_CHILD_CLEARTID, child_tid=0x7f3b7d7fe9d0, parent_tid=0x7f3b7d7fe9d0, exit_signal=0, stack=0x7f3b7cffe000, stack_size=0x7fff00, tls=0x7f3b7d7fe6c0} => {parent_tid=[21098]}, 88) = 21098
+var threadCloneRE = regexp.MustCompile(`^([0-9]+)\ +[0-9.]+ clone3?\(.*CLONE_THREAD.*\) = ([0-9]+)`)
+
+// with other processes running, the clone may be split over two lines:
+// 21097 1580155329.401357 clone(child_stack=0x7f3b7d7fdfb0, flags=CLONE_VM|...|CLONE_THREAD|... <unfinished ...>
+// 21097 1580155329.401400 <... clone resumed>, parent_tid=[21098], tls=0x7f3b7d7fe700, child_tidptr=0x7f3b7d7fe9d0) = 21098
+var threadCloneUnfinishedRE = regexp.MustCompile(`^([0-9]+)\ +[0-9.]+ clone3?\(.*CLONE_THREAD.*<unfinished \.\.\.>`)
+var cloneResumedRE = regexp.MustCompile(`^([0-9]+)\ +[0-9.]+ <\.\.\. clone3? resumed>.* = ([0-9]+)`)
+
+// lines look like:
+// 21098 1580155329.501357 +++ exited with 0 +++
+// 21098 1580155329.501357 +++ killed by SIGKILL +++
+var taskExitRE = regexp.MustCompile(`^([0-9]+)\ +[0-9.]+ \+\+\+ (exited with|killed by)`)
+
+// threadTracker counts the threads created with clone(CLONE_THREAD) and how
+// many of them were running at the same time
+type threadTracker struct {
+	created int
+	peak    int
+
+	running map[string]bool
+	// pids with a thread creating clone() which hasn't returned yet
+	unfinished map[string]bool
+}
+
+func newThreadTracker() *threadTracker {
+	return &threadTracker{
+		running:    make(map[string]bool),
+		unfinished: make(map[string]bool),
+	}
+}
+
+func (t *threadTracker) addThread(tid string) {
+	t.created++
+	t.running[tid] = true
+	if len(t.running) > t.peak {
+		t.peak = len(t.running)
+	}
+}
+
+func (t *threadTracker) handleLine(line string) {
+	if match := threadCloneRE.FindStringSubmatch(line); match != nil {
+		t.addThread(match[2])
+		return
+	}
+	if match := threadCloneUnfinishedRE.FindStringSubmatch(line); match != nil {
+		t.unfinished[match[1]] = true
+		return
+	}
+	if match := cloneResumedRE.FindStringSubmatch(line); match != nil {
+		if t.unfinished[match[1]] {
+			delete(t.unfinished, match[1])
+			t.addThread(match[2])
+		}
+		return
+	}
+	if match := taskExitRE.FindStringSubmatch(line); match != nil {
+		delete(t.running, match[1])
+	}
+}
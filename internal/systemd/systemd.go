@@ -0,0 +1,135 @@
+/*
+ * Copyright (C) 2019 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package systemd
+
+import (
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var execCommandCombinedOutput = func(prog string, args ...string) ([]byte, error) {
+	return exec.Command(prog, args...).CombinedOutput()
+}
+
+// Unit is a systemd unit on either the system or the user's service manager
+type Unit struct {
+	Name string
+	User bool
+}
+
+// Timing is how long the unit took to start according to systemd
+type Timing struct {
+	// ActiveState and SubState are the state the unit ended up in, i.e.
+	// active and running
+	ActiveState string
+	SubState    string
+	// TimeToActive is the time from the unit leaving the inactive state to
+	// entering the active state, for Type=notify units this is when READY=1
+	// was sent
+	TimeToActive time.Duration
+	// MainPID is the pid of the main process of the unit, if it has one
+	MainPID int
+}
+
+// system units are managed through sudo, user units as the current user
+func (u Unit) systemctl(args ...string) ([]byte, error) {
+	if u.User {
+		return execCommandCombinedOutput("systemctl", append([]string{"--user"}, args...)...)
+	}
+	return execCommandCombinedOutput("sudo", append([]string{"systemctl"}, args...)...)
+}
+
+// Start starts the unit, waiting for the start job to finish which is when
+// the unit is active or has notified that it is ready
+func (u Unit) Start() error {
+	out, err := u.systemctl("start", u.Name)
+	if err != nil {
+		return fmt.Errorf("cannot start %s: %w (%s)", u.Name, err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// Stop stops the unit, waiting for it to be inactive
+func (u Unit) Stop() error {
+	out, err := u.systemctl("stop", u.Name)
+	if err != nil {
+		return fmt.Errorf("cannot stop %s: %w (%s)", u.Name, err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// Timing returns the state of the unit and how long it took to become active
+// the last time it was started
+func (u Unit) Timing() (*Timing, error) {
+	// showing properties doesn't need root
+	args := []string{"show",
+		"--property=ActiveState,SubState,MainPID,InactiveExitTimestampMonotonic,ActiveEnterTimestampMonotonic",
+		u.Name,
+	}
+	if u.User {
+		args = append([]string{"--user"}, args...)
+	}
+	out, err := execCommandCombinedOutput("systemctl", args...)
+	if err != nil {
+		return nil, fmt.Errorf("cannot show %s: %w (%s)", u.Name, err, strings.TrimSpace(string(out)))
+	}
+	return parseTiming(string(out))
+}
+
+func parseTiming(out string) (*Timing, error) {
+	props := make(map[string]string)
+	for _, line := range strings.Split(out, "\n") {
+		kv := strings.SplitN(line, "=", 2)
+		if len(kv) == 2 {
+			props[kv[0]] = kv[1]
+		}
+	}
+
+	t := &Timing{
+		ActiveState: props["ActiveState"],
+		SubState:    props["SubState"],
+	}
+	if t.ActiveState == "" {
+		return nil, fmt.Errorf("cannot find ActiveState in %q", out)
+	}
+	if pid, ok := props["MainPID"]; ok {
+		var err error
+		t.MainPID, err = strconv.Atoi(pid)
+		if err != nil {
+			return nil, fmt.Errorf("cannot parse MainPID: %w", err)
+		}
+	}
+
+	// the timestamps are in microseconds on the monotonic clock
+	var stamps [2]int64
+	for i, prop := range []string{"InactiveExitTimestampMonotonic", "ActiveEnterTimestampMonotonic"} {
+		usec, err := strconv.ParseInt(props[prop], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("cannot parse %s: %w", prop, err)
+		}
+		stamps[i] = usec
+	}
+	// a unit which never became active keeps the timestamp from an older start
+	if t.ActiveState == "active" && stamps[1] >= stamps[0] {
+		t.TimeToActive = time.Duration(stamps[1]-stamps[0]) * time.Microsecond
+	}
+	return t, nil
+}
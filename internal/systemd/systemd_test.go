@@ -0,0 +1,77 @@
+/*
+ * Copyright (C) 2019 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package systemd_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/anonymouse64/etrace/internal/systemd"
+
+	"gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) { check.TestingT(t) }
+
+type systemdTestSuite struct{}
+
+var _ = check.Suite(&systemdTestSuite{})
+
+func (s *systemdTestSuite) TestParseTiming(c *check.C) {
+	t, err := systemd.ParseTiming(`MainPID=1234
+ActiveState=active
+SubState=running
+InactiveExitTimestampMonotonic=5000000
+ActiveEnterTimestampMonotonic=5250000
+`)
+	c.Assert(err, check.IsNil)
+	c.Check(t, check.DeepEquals, &systemd.Timing{
+		ActiveState:  "active",
+		SubState:     "running",
+		TimeToActive: 250 * time.Millisecond,
+		MainPID:      1234,
+	})
+}
+
+func (s *systemdTestSuite) TestParseTimingFailed(c *check.C) {
+	t, err := systemd.ParseTiming(`MainPID=0
+ActiveState=failed
+SubState=failed
+InactiveExitTimestampMonotonic=5000000
+ActiveEnterTimestampMonotonic=0
+`)
+	c.Assert(err, check.IsNil)
+	c.Check(t.ActiveState, check.Equals, "failed")
+	c.Check(t.TimeToActive, check.Equals, time.Duration(0))
+}
+
+func (s *systemdTestSuite) TestStartUser(c *check.C) {
+	var calls [][]string
+	restore := systemd.MockExecCommand(func(prog string, args ...string) ([]byte, error) {
+		calls = append(calls, append([]string{prog}, args...))
+		return nil, nil
+	})
+	defer restore()
+
+	c.Assert(systemd.Unit{Name: "foo.service", User: true}.Start(), check.IsNil)
+	c.Assert(systemd.Unit{Name: "foo.service"}.Stop(), check.IsNil)
+	c.Check(calls, check.DeepEquals, [][]string{
+		{"systemctl", "--user", "start", "foo.service"},
+		{"sudo", "systemctl", "stop", "foo.service"},
+	})
+}
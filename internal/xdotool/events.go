@@ -0,0 +1,80 @@
+/*
+ * Copyright (C) 2019 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package xdotool
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// Event is an xdotool command run some time after the previous event, such as
+// pressing keys in or clicking on a window
+type Event struct {
+	After time.Duration
+	// Command is the xdotool command and its args, where {window} is replaced
+	// with the window being interacted with
+	Command []string
+}
+
+// ParseEvents parses events with one per line, of the delay since the
+// previous event followed by the xdotool command, like:
+// 500ms key --window {window} ctrl+n
+// 1s type --window {window} hello
+// Blank lines and lines starting with # are ignored.
+func ParseEvents(r io.Reader) ([]Event, error) {
+	var events []Event
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			return nil, fmt.Errorf("line %d: expected a delay and an xdotool command", n)
+		}
+		after, err := time.ParseDuration(fields[0])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", n, err)
+		}
+		events = append(events, Event{After: after, Command: fields[1:]})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return events, nil
+}
+
+// ReplayEvents runs the events against the window in order, waiting before
+// each for its delay
+func (x *xdotool) ReplayEvents(wid string, events []Event) error {
+	for i, e := range events {
+		time.Sleep(e.After)
+		args := make([]string, len(e.Command))
+		for j, arg := range e.Command {
+			args[j] = strings.Replace(arg, "{window}", wid, -1)
+		}
+		if out, err := x.output(args...); err != nil {
+			return fmt.Errorf("event %d %q: %v: %s", i+1, strings.Join(e.Command, " "), err, strings.TrimSpace(string(out)))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,52 @@
+/*
+ * Copyright (C) 2019 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package xdotool_test
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/anonymouse64/etrace/internal/xdotool"
+
+	"gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) { check.TestingT(t) }
+
+type eventsTestSuite struct{}
+
+var _ = check.Suite(&eventsTestSuite{})
+
+func (s *eventsTestSuite) TestParseEvents(c *check.C) {
+	events, err := xdotool.ParseEvents(strings.NewReader(`# open a new document
+500ms key --window {window} ctrl+n
+
+1s type --window {window} hello
+`))
+	c.Assert(err, check.IsNil)
+	c.Check(events, check.DeepEquals, []xdotool.Event{
+		{After: 500 * time.Millisecond, Command: []string{"key", "--window", "{window}", "ctrl+n"}},
+		{After: time.Second, Command: []string{"type", "--window", "{window}", "hello"}},
+	})
+
+	_, err = xdotool.ParseEvents(strings.NewReader("key ctrl+n\n"))
+	c.Check(err, check.ErrorMatches, `line 1: time: invalid duration "?key"?`)
+	_, err = xdotool.ParseEvents(strings.NewReader("\n1s\n"))
+	c.Check(err, check.ErrorMatches, "line 2: expected a delay and an xdotool command")
+}
@@ -0,0 +1,38 @@
+/*
+ * Copyright (C) 2019 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package xdotool
+
+func MockXdotoolCommand(new string) func() {
+	old := xdotoolCommand
+	xdotoolCommand = new
+	return func() {
+		xdotoolCommand = old
+	}
+}
+
+func MockXpropCommand(new string) func() {
+	old := xpropCommand
+	xpropCommand = new
+	return func() {
+		xpropCommand = old
+	}
+}
+
+func Search(x Xtooler, w Window) ([]string, error) {
+	return x.(*xdotool).search(w)
+}
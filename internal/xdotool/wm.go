@@ -0,0 +1,75 @@
+/*
+ * Copyright (C) 2019 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package xdotool
+
+import (
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// WindowManager is the window manager of an X display
+type WindowManager struct {
+	// Name is what the window manager calls itself, or the desktop from
+	// $XDG_CURRENT_DESKTOP if it couldn't be asked
+	Name string
+	// EWMH is whether the window manager supports the extended window manager
+	// hints, which wmctrl needs to close windows
+	EWMH bool
+}
+
+// xpropCommand is mocked in tests
+var xpropCommand = "xprop"
+
+func (x *xdotool) xprop(args ...string) (string, error) {
+	cmd := exec.Command(xpropCommand, args...)
+	if x.display != "" {
+		cmd.Env = append(os.Environ(), "DISPLAY="+x.display)
+	}
+	out, err := cmd.Output()
+	return strings.TrimSpace(string(out)), err
+}
+
+// WindowManager detects the window manager from the window it sets in
+// _NET_SUPPORTING_WM_CHECK on the root window, which EWMH window managers do,
+// falling back to the environment when xprop isn't installed
+func (x *xdotool) WindowManager() *WindowManager {
+	if _, err := exec.LookPath(xpropCommand); err != nil {
+		// assume whichever desktop is running has a full window manager
+		return &WindowManager{Name: os.Getenv("XDG_CURRENT_DESKTOP"), EWMH: true}
+	}
+
+	// the property looks like:
+	// _NET_SUPPORTING_WM_CHECK(WINDOW): window id # 0x800003
+	// or without a window manager:
+	// _NET_SUPPORTING_WM_CHECK:  not found.
+	out, err := x.xprop("-root", "_NET_SUPPORTING_WM_CHECK")
+	if err != nil || !strings.Contains(out, "window id # ") {
+		return &WindowManager{}
+	}
+	wmWindow := strings.Fields(out)[len(strings.Fields(out))-1]
+
+	wm := &WindowManager{EWMH: true}
+	// the name looks like:
+	// _NET_WM_NAME = "GNOME Shell"
+	out, err = x.xprop("-id", wmWindow, "-notype", "_NET_WM_NAME")
+	if kv := strings.SplitN(out, "=", 2); err == nil && len(kv) == 2 {
+		wm.Name = strings.Trim(strings.TrimSpace(kv[1]), `"`)
+	}
+	return wm
+}
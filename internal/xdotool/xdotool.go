@@ -20,25 +20,72 @@
 package xdotool
 
 import (
+	"errors"
+	"fmt"
 	"log"
+	"os"
 	"os/exec"
+	"regexp"
 	"strconv"
 	"strings"
+	"time"
 )
 
-type xdotool struct{}
+// xdotoolCommand is mocked in tests
+var xdotoolCommand = "xdotool"
+
+type xdotool struct {
+	// display is the X display to use, or empty to use $DISPLAY
+	display string
+}
 
 // Window represents a X11 window
 type Window struct {
 	Class string
 	Name  string
+	// LiteralClass matches Class as plain text rather than as a regular
+	// expression, still anywhere in the class and ignoring case like xdotool
+	LiteralClass bool
+	// Alternatives are other windows to look for at the same time, where a
+	// window matching any of them counts the same as one matching this one
+	Alternatives []Window
+}
+
+// AnyOf returns a Window matching any of ws
+func AnyOf(ws ...Window) Window {
+	w := ws[0]
+	w.Alternatives = append(w.Alternatives, ws[1:]...)
+	return w
+}
+
+func (w Window) searchArgs() []string {
+	if w.Class != "" && w.LiteralClass {
+		// xdotool matches the class as a case insensitive regular
+		// expression, so escape it
+		return []string{"--class", regexp.QuoteMeta(w.Class)}
+	}
+	if w.Class != "" {
+		return []string{"--class", w.Class}
+	}
+	return []string{"--name", w.Name}
+}
+
+// WindowAppearance is a window and the time it was first seen
+type WindowAppearance struct {
+	ID   string
+	Time time.Time
 }
 
 // Xtooler works with xdotool to perform various operations on X11 windows
 type Xtooler interface {
 	WaitForWindow(w Window) ([]string, error)
+	WaitForWindowTimeout(w Window, timeout time.Duration) ([]string, error)
+	WatchWindows(w Window, settle, timeout time.Duration) ([]WindowAppearance, error)
 	CloseWindowID(wid string) error
 	PidForWindowID(wid string) (int, error)
+	WaitForActive(wids []string, timeout time.Duration) (time.Time, error)
+	WindowManager() *WindowManager
+	ReplayEvents(wid string, events []Event) error
 }
 
 // MakeXDoTool returns a Xtooler that can interact with windows
@@ -46,9 +93,68 @@ func MakeXDoTool() Xtooler {
 	return &xdotool{}
 }
 
+// MakeXDoToolForDisplay returns a Xtooler that can interact with windows on
+// the given X display
+func MakeXDoToolForDisplay(display string) Xtooler {
+	return &xdotool{display: display}
+}
+
+func (x *xdotool) command(args ...string) *exec.Cmd {
+	cmd := exec.Command(xdotoolCommand, args...)
+	if x.display != "" {
+		cmd.Env = append(os.Environ(), "DISPLAY="+x.display)
+	}
+	return cmd
+}
+
+// how many times to try an xdotool command which fails transiently, i.e.
+// because the X server is busy, and how long to wait before the first retry,
+// which doubles after every failure
+const (
+	transientRetries = 4
+	retryBackoff     = 50 * time.Millisecond
+)
+
+// transientErrors are parts of xdotool errors which retrying can fix, as the
+// X server couldn't be reached for a moment or a window went away while it
+// was being looked at, anything else such as a missing xdotool or bad
+// arguments fails straight away
+var transientErrors = []string{
+	"Can't open display",
+	"Connection refused",
+	"BadWindow",
+}
+
+func isTransient(out []byte) bool {
+	msg := string(out)
+	for _, transient := range transientErrors {
+		if strings.Contains(msg, transient) {
+			return true
+		}
+	}
+	return false
+}
+
+// output runs xdotool with args, retrying with backoff when it fails
+// transiently
+func (x *xdotool) output(args ...string) ([]byte, error) {
+	backoff := retryBackoff
+	for attempt := 1; ; attempt++ {
+		out, err := x.command(args...).CombinedOutput()
+		if err == nil || attempt == transientRetries || !isTransient(out) {
+			return out, err
+		}
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
 func (x *xdotool) WaitForWindow(w Window) ([]string, error) {
+	if len(w.Alternatives) != 0 {
+		return x.pollForWindow(w)
+	}
 	if w.Class != "" {
-		return x.waitForWindowArgs([]string{"--class", w.Class})
+		return x.waitForWindowArgs(w.searchArgs())
 	} else if w.Name != "" {
 		return x.waitForWindowArgs([]string{"--name", w.Name})
 	} else {
@@ -58,7 +164,7 @@ func (x *xdotool) WaitForWindow(w Window) ([]string, error) {
 	var err error
 	out := []byte{}
 	for i := 0; i < 10; i++ {
-		out, err = exec.Command("xdotool", "search", "--sync", "--onlyvisible", "--class", w.Class).CombinedOutput()
+		out, err = x.command("search", "--sync", "--onlyvisible", "--class", w.Class).CombinedOutput()
 		if err != nil {
 			continue
 		}
@@ -69,24 +175,134 @@ func (x *xdotool) WaitForWindow(w Window) ([]string, error) {
 	return nil, err
 }
 
+// ErrWindowTimeout is returned by WaitForWindowTimeout when no window appeared
+// in time
+var ErrWindowTimeout = errors.New("timed out waiting for a window to appear")
+
+// ErrActiveTimeout is returned by WaitForActive when none of the windows
+// became active in time
+var ErrActiveTimeout = errors.New("timed out waiting for the window to become active")
+
+// WaitForWindowTimeout is WaitForWindow, giving up once timeout has passed
+func (x *xdotool) WaitForWindowTimeout(w Window, timeout time.Duration) ([]string, error) {
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		wids, err := x.search(w)
+		if err != nil {
+			return nil, err
+		}
+		if len(wids) != 0 {
+			return wids, nil
+		}
+		time.Sleep(watchPollInterval)
+	}
+	return nil, ErrWindowTimeout
+}
+
 func (x *xdotool) waitForWindowArgs(searchArgs []string) ([]string, error) {
-	windowids := []string{}
-	var err error
-	out := []byte{}
-	for i := 0; i < 10; i++ {
-		out, err = exec.Command("xdotool", append([]string{"search", "--sync", "--onlyvisible"}, searchArgs...)...).CombinedOutput()
+	// --sync waits for the window itself, so only transient failures are
+	// retried, by output
+	out, err := x.output(append([]string{"search", "--sync", "--onlyvisible"}, searchArgs...)...)
+	if err != nil {
+		log.Println(string(out))
+		return nil, err
+	}
+	return strings.Split(strings.TrimSpace(string(out)), "\n"), nil
+}
+
+// how often to look for new windows when watching for windows
+const watchPollInterval = 20 * time.Millisecond
+
+// how long to wait for a window with alternatives, as those can't use
+// xdotool's --sync
+const pollForWindowTimeout = 2 * time.Minute
+
+func (x *xdotool) pollForWindow(w Window) ([]string, error) {
+	wids, err := x.WaitForWindowTimeout(w, pollForWindowTimeout)
+	if err != ErrWindowTimeout {
+		return wids, err
+	}
+	return nil, fmt.Errorf("timed out waiting for any of the windows after %v", pollForWindowTimeout)
+}
+
+// search returns the visible windows matching w or any of its alternatives
+// right now, which may be none
+func (x *xdotool) search(w Window) ([]string, error) {
+	wids, err := x.searchOne(w)
+	if err != nil {
+		return nil, err
+	}
+	seen := make(map[string]bool, len(wids))
+	for _, wid := range wids {
+		seen[wid] = true
+	}
+	for _, alt := range w.Alternatives {
+		altWids, err := x.searchOne(alt)
 		if err != nil {
-			continue
+			return nil, err
+		}
+		for _, wid := range altWids {
+			if !seen[wid] {
+				seen[wid] = true
+				wids = append(wids, wid)
+			}
 		}
-		windowids = strings.Split(strings.TrimSpace(string(out)), "\n")
-		return windowids, nil
 	}
-	log.Println(string(out))
-	return nil, err
+	return wids, nil
+}
+
+// searchOne is search ignoring the alternatives
+func (x *xdotool) searchOne(w Window) ([]string, error) {
+	return x.searchWith(w.searchArgs())
+}
+
+func (x *xdotool) searchWith(searchArgs []string) ([]string, error) {
+	out, err := x.output(append([]string{"search", "--onlyvisible"}, searchArgs...)...)
+	// xdotool exits non-zero without any output when nothing matches yet
+	if err != nil && len(strings.TrimSpace(string(out))) != 0 {
+		log.Println(string(out))
+		return nil, err
+	}
+	if err != nil {
+		return nil, nil
+	}
+	return strings.Fields(string(out)), nil
+}
+
+// WatchWindows looks for windows matching w, recording when each new window
+// appears, until no new window has appeared for the settle period
+func (x *xdotool) WatchWindows(w Window, settle, timeout time.Duration) ([]WindowAppearance, error) {
+	var appeared []WindowAppearance
+	seen := make(map[string]bool)
+	deadline := time.Now().Add(timeout)
+	lastNew := time.Now()
+	for time.Now().Before(deadline) {
+		wids, err := x.search(w)
+		now := time.Now()
+		if err != nil {
+			return nil, err
+		}
+		for _, wid := range wids {
+			if !seen[wid] {
+				seen[wid] = true
+				appeared = append(appeared, WindowAppearance{ID: wid, Time: now})
+				lastNew = now
+			}
+		}
+
+		if len(appeared) != 0 && now.Sub(lastNew) >= settle {
+			return appeared, nil
+		}
+		time.Sleep(watchPollInterval)
+	}
+	if len(appeared) != 0 {
+		return appeared, nil
+	}
+	return nil, ErrWindowTimeout
 }
 
 func (x *xdotool) CloseWindowID(wid string) error {
-	out, err := exec.Command("xdotool", "windowkill", wid).CombinedOutput()
+	out, err := x.output("windowkill", wid)
 	if err != nil {
 		log.Println(string(out))
 		return err
@@ -95,10 +311,32 @@ func (x *xdotool) CloseWindowID(wid string) error {
 }
 
 func (x *xdotool) PidForWindowID(wid string) (int, error) {
-	out, err := exec.Command("xdotool", "getwindowpid", wid).CombinedOutput()
+	out, err := x.output("getwindowpid", wid)
 	if err != nil {
 		log.Println(string(out))
 		return 0, err
 	}
 	return strconv.Atoi(strings.TrimSpace(string(out)))
 }
+
+// WaitForActive waits for any of wids to become the active window, as set in
+// _NET_ACTIVE_WINDOW by the window manager, returning when it first was
+func (x *xdotool) WaitForActive(wids []string, timeout time.Duration) (time.Time, error) {
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		// there may be no active window at all while focus moves between
+		// windows, which xdotool fails for
+		out, err := x.command("getactivewindow").Output()
+		now := time.Now()
+		if err == nil {
+			active := strings.TrimSpace(string(out))
+			for _, wid := range wids {
+				if wid == active {
+					return now, nil
+				}
+			}
+		}
+		time.Sleep(watchPollInterval)
+	}
+	return time.Time{}, ErrActiveTimeout
+}
@@ -0,0 +1,145 @@
+/*
+ * Copyright (C) 2019 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package xdotool_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/anonymouse64/etrace/internal/xdotool"
+
+	"gopkg.in/check.v1"
+)
+
+type xdotoolTestSuite struct{}
+
+var _ = check.Suite(&xdotoolTestSuite{})
+
+// mockCommand writes script as the command named name and returns its path
+// along with the path of a log of the arguments it was called with
+func (s *xdotoolTestSuite) mockCommand(c *check.C, name, script string) (string, string) {
+	dir := c.MkDir()
+	path := filepath.Join(dir, name)
+	calls := filepath.Join(dir, "calls")
+	script = "#!/bin/sh\necho \"$DISPLAY $*\" >> " + calls + "\n" + script
+	c.Assert(ioutil.WriteFile(path, []byte(script), 0755), check.IsNil)
+	return path, calls
+}
+
+func (s *xdotoolTestSuite) calls(c *check.C, calls string) []string {
+	out, err := ioutil.ReadFile(calls)
+	c.Assert(err, check.IsNil)
+	return strings.Split(strings.TrimSpace(string(out)), "\n")
+}
+
+func (s *xdotoolTestSuite) TestWindowManager(c *check.C) {
+	xprop, calls := s.mockCommand(c, "xprop", `case "$1" in
+-root) echo '_NET_SUPPORTING_WM_CHECK(WINDOW): window id # 0x800003';;
+-id) echo '_NET_WM_NAME = "GNOME Shell"';;
+esac
+`)
+	defer xdotool.MockXpropCommand(xprop)()
+
+	wm := xdotool.MakeXDoToolForDisplay(":42").WindowManager()
+	c.Check(wm, check.DeepEquals, &xdotool.WindowManager{Name: "GNOME Shell", EWMH: true})
+	c.Check(s.calls(c, calls), check.DeepEquals, []string{
+		":42 -root _NET_SUPPORTING_WM_CHECK",
+		":42 -id 0x800003 -notype _NET_WM_NAME",
+	})
+}
+
+func (s *xdotoolTestSuite) TestWindowManagerUnnamed(c *check.C) {
+	xprop, _ := s.mockCommand(c, "xprop", `case "$1" in
+-root) echo '_NET_SUPPORTING_WM_CHECK(WINDOW): window id # 0x800003';;
+-id) echo '_NET_WM_NAME:  not found.'; exit 1;;
+esac
+`)
+	defer xdotool.MockXpropCommand(xprop)()
+
+	wm := xdotool.MakeXDoTool().WindowManager()
+	c.Check(wm, check.DeepEquals, &xdotool.WindowManager{EWMH: true})
+}
+
+func (s *xdotoolTestSuite) TestWindowManagerNone(c *check.C) {
+	xprop, _ := s.mockCommand(c, "xprop", "echo '_NET_SUPPORTING_WM_CHECK:  not found.'\n")
+	defer xdotool.MockXpropCommand(xprop)()
+
+	wm := xdotool.MakeXDoTool().WindowManager()
+	c.Check(wm, check.DeepEquals, &xdotool.WindowManager{})
+}
+
+func (s *xdotoolTestSuite) TestWindowManagerNoXprop(c *check.C) {
+	defer xdotool.MockXpropCommand(filepath.Join(c.MkDir(), "xprop"))()
+	old := os.Getenv("XDG_CURRENT_DESKTOP")
+	defer os.Setenv("XDG_CURRENT_DESKTOP", old)
+	os.Setenv("XDG_CURRENT_DESKTOP", "KDE")
+
+	wm := xdotool.MakeXDoTool().WindowManager()
+	c.Check(wm, check.DeepEquals, &xdotool.WindowManager{Name: "KDE", EWMH: true})
+}
+
+func (s *xdotoolTestSuite) TestSearch(c *check.C) {
+	xdo, calls := s.mockCommand(c, "xdotool", `case "$4" in
+firefox) printf '100\n200\n';;
+Navigator) printf '200\n300\n';;
+esac
+`)
+	defer xdotool.MockXdotoolCommand(xdo)()
+
+	w := xdotool.AnyOf(xdotool.Window{Class: "firefox"}, xdotool.Window{Name: "Navigator"})
+	wids, err := xdotool.Search(xdotool.MakeXDoToolForDisplay(":42"), w)
+	c.Assert(err, check.IsNil)
+	// windows matching more than one alternative are only listed once
+	c.Check(wids, check.DeepEquals, []string{"100", "200", "300"})
+	c.Check(s.calls(c, calls), check.DeepEquals, []string{
+		":42 search --onlyvisible --class firefox",
+		":42 search --onlyvisible --name Navigator",
+	})
+}
+
+func (s *xdotoolTestSuite) TestSearchLiteralClass(c *check.C) {
+	xdo, calls := s.mockCommand(c, "xdotool", "echo 100\n")
+	defer xdotool.MockXdotoolCommand(xdo)()
+
+	wids, err := xdotool.Search(xdotool.MakeXDoTool(), xdotool.Window{Class: "org.gnome.Calculator", LiteralClass: true})
+	c.Assert(err, check.IsNil)
+	c.Check(wids, check.DeepEquals, []string{"100"})
+	c.Check(s.calls(c, calls)[0], check.Matches, `.*search --onlyvisible --class org\\.gnome\\.Calculator`)
+}
+
+func (s *xdotoolTestSuite) TestSearchNoWindows(c *check.C) {
+	// xdotool exits non-zero without any output when nothing matches
+	xdo, _ := s.mockCommand(c, "xdotool", "exit 1\n")
+	defer xdotool.MockXdotoolCommand(xdo)()
+
+	wids, err := xdotool.Search(xdotool.MakeXDoTool(), xdotool.Window{Class: "firefox"})
+	c.Assert(err, check.IsNil)
+	c.Check(wids, check.HasLen, 0)
+}
+
+func (s *xdotoolTestSuite) TestSearchError(c *check.C) {
+	xdo, calls := s.mockCommand(c, "xdotool", "echo 'Unknown option: --bogus' >&2\nexit 1\n")
+	defer xdotool.MockXdotoolCommand(xdo)()
+
+	_, err := xdotool.Search(xdotool.MakeXDoTool(), xdotool.Window{Class: "firefox"})
+	c.Check(err, check.ErrorMatches, "exit status 1")
+	// not a transient failure, so it isn't retried
+	c.Check(s.calls(c, calls), check.HasLen, 1)
+}
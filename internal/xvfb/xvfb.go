@@ -0,0 +1,97 @@
+/*
+ * Copyright (C) 2019 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+// Package xvfb runs a virtual X server to run GUI apps without a display
+package xvfb
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// how long to wait for Xvfb to be ready to accept connections
+var startTimeout = 10 * time.Second
+
+// xvfbCommand is mocked in tests
+var xvfbCommand = "Xvfb"
+
+// Server is a running Xvfb
+type Server struct {
+	// Display is the display it's running on, such as :99
+	Display string
+	cmd     *exec.Cmd
+	exited  chan struct{}
+}
+
+// Start runs Xvfb on a free display with a single screen of the given size,
+// such as 1920x1080x24, returning once it's ready for clients
+func Start(screen string) (*Server, error) {
+	// Xvfb picks a free display and writes its number to the fd given with
+	// -displayfd once it's ready
+	r, w, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	cmd := exec.Command(xvfbCommand, "-displayfd", "3", "-screen", "0", screen, "-nolisten", "tcp")
+	cmd.ExtraFiles = []*os.File{w}
+	err = cmd.Start()
+	w.Close()
+	if err != nil {
+		return nil, fmt.Errorf("cannot start Xvfb: %w", err)
+	}
+	s := &Server{cmd: cmd, exited: make(chan struct{})}
+	go func() {
+		cmd.Wait()
+		close(s.exited)
+	}()
+
+	display := make(chan string, 1)
+	go func() {
+		// this fails when Xvfb exits without writing anything
+		line, _ := bufio.NewReader(r).ReadString('\n')
+		display <- strings.TrimSpace(line)
+	}()
+	select {
+	case d := <-display:
+		if d == "" {
+			s.Stop()
+			return nil, errors.New("Xvfb exited before it was ready")
+		}
+		s.Display = ":" + d
+		return s, nil
+	case <-time.After(startTimeout):
+		s.Stop()
+		return nil, fmt.Errorf("Xvfb was not ready within %v", startTimeout)
+	}
+}
+
+// Pid is the pid of the Xvfb process
+func (s *Server) Pid() int {
+	return s.cmd.Process.Pid
+}
+
+// Stop kills the server and waits for it to exit
+func (s *Server) Stop() {
+	s.cmd.Process.Kill()
+	<-s.exited
+}
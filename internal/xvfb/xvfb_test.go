@@ -0,0 +1,56 @@
+/*
+ * Copyright (C) 2019 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package xvfb_test
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/anonymouse64/etrace/internal/xvfb"
+
+	"gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) { check.TestingT(t) }
+
+type xvfbTestSuite struct{}
+
+var _ = check.Suite(&xvfbTestSuite{})
+
+func (s *xvfbTestSuite) mockXvfb(c *check.C, script string) func() {
+	path := filepath.Join(c.MkDir(), "Xvfb")
+	c.Assert(ioutil.WriteFile(path, []byte("#!/bin/sh\n"+script), 0755), check.IsNil)
+	return xvfb.MockXvfbCommand(path)
+}
+
+func (s *xvfbTestSuite) TestStart(c *check.C) {
+	defer s.mockXvfb(c, "echo 42 >&3\nexec sleep 10\n")()
+
+	server, err := xvfb.Start("1920x1080x24")
+	c.Assert(err, check.IsNil)
+	c.Check(server.Display, check.Equals, ":42")
+	server.Stop()
+}
+
+func (s *xvfbTestSuite) TestStartFails(c *check.C) {
+	defer s.mockXvfb(c, "echo 'cannot open display' >&2\nexit 1\n")()
+
+	_, err := xvfb.Start("1920x1080x24")
+	c.Check(err, check.ErrorMatches, "Xvfb exited before it was ready")
+}